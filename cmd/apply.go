@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type applyCmd struct {
+	installCmd
+	deregister         bool
+	suspendAutoScaling bool
+}
+
+func newApplyCmd() *cobra.Command {
+	apply := &applyCmd{}
+	cmd := &cobra.Command{
+		Use:   "apply [--task-definition-arn arn] [cluster] [service] [task_definition.json]",
+		Short: "Install or upgrade a service in an ECS cluster, whichever is needed",
+		Long: `This command brings a service to a new version of a task definition,
+creating it if needed.
+
+If the service does not exist in the cluster (or was deleted), it is created
+like czecs install does. Otherwise it is upgraded like czecs upgrade does,
+including changes to its deployment configuration such as --circuit-breaker.
+The action taken is logged, and reported as "install" or "upgrade" in the
+result document with --output json.
+
+Only the task definition and the circuit breaker are applied. The desired
+count (see czecs scale), load balancers, network configuration, health check
+grace period, platform version and deployment minimum and maximum percent are
+left as they are on an existing service, and are not set on a new one. Those an
+existing service sets, other than the desired count, are warned about and
+reported as unmanagedSettings in the result document.
+
+With --env, the cluster, service, task definition template and other settings
+are taken from that environment of the project config file (see czecs config);
+only a task definition template may then be passed as argument.`,
+		SilenceUsage: true,
+		Args:         cobra.RangeArgs(0, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, err := loadEnvironment(apply.env)
+			if err != nil {
+				return err
+			}
+			if env != nil {
+				apply.applyEnvironment(cmd, env)
+				if env.Deregister != nil && !cmd.Flags().Changed("deregister") {
+					apply.deregister = *env.Deregister
+				}
+				args, err = envArgs(env, args, apply.opts.TaskDefinitionArn, env.Cluster, env.Service)
+				if err != nil {
+					return err
+				}
+			}
+			if len(args) < 2 {
+				return fmt.Errorf("a cluster and service must be provided")
+			}
+			if (len(args) >= 3) == (apply.opts.TaskDefinitionArn != "") {
+				return fmt.Errorf("exactly one of a task definition JSON filename (czecs.json) or a task definition ARN via --task-definition-arn must be provided")
+			}
+			apply.opts.Cluster = args[0]
+			apply.opts.Service = args[1]
+			if len(args) >= 3 {
+				apply.opts.Template = args[2]
+			}
+
+			sess := newSession(env)
+			client := newClient(sess)
+			opts := czecs.UpgradeOptions{InstallOptions: apply.opts, Deregister: apply.deregister, SuspendAutoScaling: apply.suspendAutoScaling}
+			if apply.plan {
+				plan, err := client.PlanApply(opts)
+				if err != nil {
+					return err
+				}
+				printPlans(plan)
+				return writeResult(cmd, &planResult{Plans: []*czecs.Plan{plan}}, nil)
+			}
+			held, err := apply.locking.lockService(sess, client, apply.opts.Cluster, apply.opts.Service)
+			if err != nil {
+				return err
+			}
+			defer releaseLocks(held)
+			defer abortOnLostLocks([]*lock.Held{held}, client)()
+			result, err := client.Apply(opts)
+			if result != nil && err == nil {
+				log.Infof("Service %#v applied with %s", apply.opts.Service, result.Action)
+			}
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	addValuesFlags(cmd, &apply.opts.Values)
+	addSidecarFlag(cmd, &apply.opts.Values)
+	f := cmd.Flags()
+	f.BoolVar(&apply.opts.Rollback, "rollback", false, "rollback if deployment failed: delete a created service, or return an upgraded one to its previous version")
+	f.BoolVar(&apply.deregister, "deregister", false, "on upgrade, remove old task definition on success (or remove new task definition on failure)")
+	f.BoolVar(&apply.suspendAutoScaling, "suspend-autoscaling", false, "on upgrade, suspend the auto scaling of the service until the upgrade is done")
+	f.StringVar(&apply.opts.TaskDefinitionArn, "task-definition-arn", "", "Use existing task definition instead of reading template file.")
+	f.IntVarP(&apply.opts.Timeout, "timeout", "t", 600, "Seconds to wait for service to become stable before failing. Set to 0 for unlimited wait.")
+	f.BoolVar(&apply.opts.CircuitBreaker, "circuit-breaker", false, "enable the ECS deployment circuit breaker on the service, rolling back failed deployments")
+	f.IntVar(&apply.opts.MaxFailedTasks, "max-failed-tasks", 0, "fail the deployment once more than this many tasks failed to start. Set to 0 to disable.")
+	f.StringVar(&apply.opts.PreTask, "pre-task", "", "task JSON file to run to completion before creating or upgrading the service; the service is not changed if it fails")
+	f.StringVar(&apply.opts.PostTask, "post-task", "", "task JSON file to run to completion after the service is stable; the deployment fails (and is rolled back with --rollback) if it fails")
+	f.StringVar(&apply.env, "env", "", "use the settings of this environment of the project config file (.czecs.yaml)")
+	addLockFlags(cmd, &apply.locking)
+	addPlanFlag(cmd, &apply.plan)
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newApplyCmd())
+}
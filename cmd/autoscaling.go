@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/spf13/cobra"
+)
+
+func newAutoScalingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "autoscaling",
+		Short: "Manage the Application Auto Scaling of a service",
+		Long: `These commands manage the scalable target and the target tracking and step
+scaling policies of a service in Application Auto Scaling.
+
+The auto scaling template is a JSON template, filled in with values like task
+definition templates are, with the input of RegisterScalableTarget and of
+PutScalingPolicy for each policy. Their service namespace, resource ID and
+scalable dimension are those of the service, and are left out. For example:
+
+{
+  "scalableTarget": {"minCapacity": {{ .Values.min }}, "maxCapacity": {{ .Values.max }}},
+  "scalingPolicies": [
+    {
+      "policyName": "cpu",
+      "policyType": "TargetTrackingScaling",
+      "targetTrackingScalingPolicyConfiguration": {
+        "targetValue": 60,
+        "predefinedMetricSpecification": {"predefinedMetricType": "ECSServiceAverageCPUUtilization"}
+      }
+    }
+  ]
+}
+
+To keep auto scaling from changing the number of tasks during an upgrade, use
+czecs upgrade --suspend-autoscaling.`,
+	}
+	cmd.AddCommand(
+		newAutoScalingApplyCmd(),
+		newAutoScalingShowCmd(),
+		newAutoScalingDeleteCmd(),
+		newAutoScalingSuspendCmd(true),
+		newAutoScalingSuspendCmd(false),
+	)
+	return cmd
+}
+
+func newAutoScalingApplyCmd() *cobra.Command {
+	opts := czecs.AutoScalingOptions{}
+	cmd := &cobra.Command{
+		Use:   "apply [cluster] [service] [autoscaling.json]",
+		Short: "Register the scalable target and put the scaling policies of a service",
+		Long: `This command registers the scalable target of a service and puts its scaling
+policies, as given by the auto scaling template. Scaling policies of the service
+not in the template are deleted.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Cluster, opts.Service, opts.Template = args[0], args[1], args[2]
+			result, err := newClient(newSession(nil)).ApplyAutoScaling(opts)
+			if err == nil {
+				printAutoScaling(result)
+			}
+			return writeResult(cmd, result, err)
+		},
+	}
+	addValuesFlags(cmd, &opts.Values)
+	return cmd
+}
+
+func newAutoScalingShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "show [cluster] [service]",
+		Short:        "Show the scalable target and scaling policies of a service",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := newClient(newSession(nil)).DescribeAutoScaling(args[0], args[1])
+			if err == nil && result == nil {
+				err = fmt.Errorf("Service %#v in cluster %#v has no scalable target", args[1], args[0])
+			}
+			if err == nil {
+				printAutoScaling(result)
+			}
+			return writeResult(cmd, result, err)
+		},
+	}
+	return cmd
+}
+
+func newAutoScalingDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete [cluster] [service]",
+		Short: "Delete the scaling policies and scalable target of a service",
+		Long: `This command deletes the scaling policies of a service, then deregisters its
+scalable target. The desired count of the service is left as is.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := newClient(newSession(nil)).DeleteAutoScaling(args[0], args[1])
+			return writeResult(cmd, result, err)
+		},
+	}
+	return cmd
+}
+
+// newAutoScalingSuspendCmd returns the suspend command, or the resume command if not suspend.
+func newAutoScalingSuspendCmd(suspend bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "suspend [cluster] [service]",
+		Short: "Suspend all scaling of a service",
+		Long: `This command suspends scaling in, scaling out and scheduled scaling of a
+service, until czecs autoscaling resume is run.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := newClient(newSession(nil))
+			cluster, service := args[0], args[1]
+			result, err := client.DescribeAutoScaling(cluster, service)
+			if err == nil && result == nil {
+				err = fmt.Errorf("Service %#v in cluster %#v has no scalable target", service, cluster)
+			}
+			if err == nil && suspend {
+				_, err = client.SuspendAutoScaling(cluster, service)
+			} else if err == nil {
+				err = client.ResumeAutoScaling(cluster, service)
+			}
+			if err == nil {
+				result, err = client.DescribeAutoScaling(cluster, service)
+			}
+			if err == nil {
+				printAutoScaling(result)
+			}
+			return writeResult(cmd, result, err)
+		},
+	}
+	if !suspend {
+		cmd.Use = "resume [cluster] [service]"
+		cmd.Short = "Resume all scaling of a service"
+		cmd.Long = `This command resumes scaling in, scaling out and scheduled scaling of a
+service, e.g. after czecs autoscaling suspend.`
+	}
+	return cmd
+}
+
+// printAutoScaling prints the scalable target and scaling policies of a service, unless the result document
+// is written instead.
+func printAutoScaling(result *czecs.AutoScalingResult) {
+	if jsonOutput() {
+		return
+	}
+	suspended := ""
+	if result.Suspended {
+		suspended = " (suspended)"
+	}
+	fmt.Printf("%s: %d to %d tasks%s\n", result.ResourceID, result.MinCapacity, result.MaxCapacity, suspended)
+	for _, policy := range result.Policies {
+		fmt.Printf("    %s: %s\n", policy.Name, policy.Type)
+	}
+	for _, name := range result.DeletedPolicies {
+		fmt.Printf("    %s: deleted\n", name)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(newAutoScalingCmd())
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// projectConfigFile is the name of the project config file, looked up in the current directory and its parents
+const projectConfigFile = ".czecs.yaml"
+
+// projectConfig is the contents of a project config file. Settings at the top level apply to every environment.
+type projectConfig struct {
+	environmentConfig
+	Environments map[string]environmentConfig `json:"environments"`
+	Fetch        *fetchConfig                 `json:"fetch,omitempty"`
+}
+
+// environmentConfig holds the settings of one environment a project is deployed to.
+type environmentConfig struct {
+	Cluster      string   `json:"cluster,omitempty"`
+	Service      string   `json:"service,omitempty"`
+	Region       string   `json:"region,omitempty"`
+	Profile      string   `json:"profile,omitempty"`
+	RoleArn      string   `json:"roleArn,omitempty"`
+	Template     string   `json:"template,omitempty"`
+	ValuesSchema string   `json:"valuesSchema,omitempty"`
+	Balances     []string `json:"balances,omitempty"`
+	Set          []string `json:"set,omitempty"`
+	SetString    []string `json:"setString,omitempty"`
+	Timeout      *int     `json:"timeout,omitempty"`
+	Rollback     *bool    `json:"rollback,omitempty"`
+	Deregister   *bool    `json:"deregister,omitempty"`
+}
+
+// findProjectConfig returns the path of the project config file given by --config, or else the nearest
+// .czecs.yaml in the current directory or its parents. Returns "" if there is none.
+func findProjectConfig() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot determine current directory")
+	}
+	for {
+		path := filepath.Join(dir, projectConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// readProjectConfig reads a project config file. Relative template, values schema, balances and cache paths are
+// resolved relative to the directory of the config file.
+func readProjectConfig(path string) (*projectConfig, error) {
+	rawConfig, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading project config %v", path)
+	}
+	var config projectConfig
+	if err := yaml.Unmarshal(rawConfig, &config); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing project config %v", path)
+	}
+
+	dir := filepath.Dir(path)
+	if config.Fetch != nil && config.Fetch.CacheDir != "" {
+		config.Fetch.CacheDir = relativeTo(dir, []string{config.Fetch.CacheDir})[0]
+	}
+	config.environmentConfig.resolvePaths(dir)
+	for name, env := range config.Environments {
+		env.resolvePaths(dir)
+		config.Environments[name] = env
+	}
+	return &config, nil
+}
+
+func (e *environmentConfig) resolvePaths(dir string) {
+	if e.Template != "" {
+		e.Template = relativeTo(dir, []string{e.Template})[0]
+	}
+	if e.ValuesSchema != "" {
+		e.ValuesSchema = relativeTo(dir, []string{e.ValuesSchema})[0]
+	}
+	e.Balances = relativeTo(dir, e.Balances)
+}
+
+// environment returns the settings of the named environment, combined with the top level settings.
+func (c *projectConfig) environment(name string) (*environmentConfig, error) {
+	env, ok := c.Environments[name]
+	if !ok {
+		names := make([]string, 0, len(c.Environments))
+		for name := range c.Environments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown environment %#v; expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	resolved := c.environmentConfig
+	resolved.Balances = concat(resolved.Balances, env.Balances)
+	resolved.Set = concat(resolved.Set, env.Set)
+	resolved.SetString = concat(resolved.SetString, env.SetString)
+	for _, setting := range []struct{ value, override *string }{
+		{&resolved.Cluster, &env.Cluster},
+		{&resolved.Service, &env.Service},
+		{&resolved.Region, &env.Region},
+		{&resolved.Profile, &env.Profile},
+		{&resolved.RoleArn, &env.RoleArn},
+		{&resolved.Template, &env.Template},
+		{&resolved.ValuesSchema, &env.ValuesSchema},
+	} {
+		if *setting.override != "" {
+			*setting.value = *setting.override
+		}
+	}
+	if env.Timeout != nil {
+		resolved.Timeout = env.Timeout
+	}
+	if env.Rollback != nil {
+		resolved.Rollback = env.Rollback
+	}
+	if env.Deregister != nil {
+		resolved.Deregister = env.Deregister
+	}
+	return &resolved, nil
+}
+
+// loadEnvironment returns the settings of the named environment of the project config file, or nil if
+// no environment is given.
+func loadEnvironment(name string) (*environmentConfig, error) {
+	if name == "" {
+		return nil, nil
+	}
+	path, err := findProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("environment %#v given, but no %s found in the current directory or its parents", name, projectConfigFile)
+	}
+	log.Debugf("Using project config %#v", path)
+	config, err := readProjectConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.environment(name)
+}
+
+// envArgs returns the positional arguments of a command run with --env: the given leading arguments
+// taken from the environment (e.g. cluster and service), followed by the task definition template
+// if given in args or the environment.
+func envArgs(env *environmentConfig, args []string, taskDefinitionArn string, leading ...string) ([]string, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("only a task definition template can be passed as argument with --env")
+	}
+	for _, arg := range leading {
+		if arg == "" {
+			return nil, fmt.Errorf("the environment must set cluster and service")
+		}
+	}
+	if len(args) == 0 && env.Template != "" && taskDefinitionArn == "" {
+		args = []string{env.Template}
+	}
+	return append(leading, args...), nil
+}
+
+// applyEnvironment fills in settings of the environment not given on the command line. Balances and
+// values from the command line are applied after those of the environment, overriding them.
+func (i *installCmd) applyEnvironment(cmd *cobra.Command, env *environmentConfig) {
+	i.opts.Values.BalanceFiles = concat(env.Balances, i.opts.Values.BalanceFiles)
+	i.opts.Values.Set = concat(env.Set, i.opts.Values.Set)
+	i.opts.Values.SetString = concat(env.SetString, i.opts.Values.SetString)
+	flags := cmd.Flags()
+	if env.ValuesSchema != "" && !flags.Changed("values-schema") {
+		i.opts.Values.Schema = env.ValuesSchema
+	}
+	if env.Timeout != nil && !flags.Changed("timeout") {
+		i.opts.Timeout = *env.Timeout
+	}
+	if env.Rollback != nil && !flags.Changed("rollback") {
+		i.opts.Rollback = *env.Rollback
+	}
+}
+
+type configCmd struct {
+	env string
+}
+
+func newConfigCmd() *cobra.Command {
+	config := &configCmd{}
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the project config file",
+		Long: `The project config file .czecs.yaml defines the environments a project is
+deployed to. It is looked up in the current directory and its parents, unless
+given with --config. Example:
+
+balances: [balances.json]
+template: czecs.json
+environments:
+  staging:
+    cluster: example-cluster
+    service: example-staging-helloworld
+    region: us-west-2
+    profile: my_aws_profile
+    balances: [balances.staging.json]
+    set: [region=us-west-2]
+  prod:
+    cluster: example-cluster
+    service: example-prod-helloworld
+    region: us-west-2
+    profile: my_aws_profile
+    roleArn: arn:aws:iam::123456789012:role/deploy
+    balances: [balances.prod.json]
+    set: [region=us-west-2]
+    timeout: 900
+    rollback: true
+    deregister: true
+
+Settings at the top level apply to every environment. Balances and values of
+an environment are applied after the top level ones. Relative paths are
+relative to the directory of the config file.
+
+Commands run with --env use the settings of that environment, e.g.
+czecs upgrade --env staging. Flags given on the command line override them.
+
+The fetch section, only allowed at the top level, configures how every
+command reads templates and balances from URIs; --fetch-timeout,
+--fetch-retries and --cache-dir override it:
+
+fetch:
+  timeout: 30
+  retries: 3
+  cacheDir: .czecs-cache
+  auth:
+    templates.example.com:
+      bearerTokenEnv: TEMPLATES_TOKEN
+    artifacts.example.com:
+      username: deploy
+      passwordEnv: ARTIFACTS_PASSWORD
+
+Secrets are read from the named environment variables when a file is first
+read from the host, so they only need to be set for commands reading from it.
+The Authorization header sent to other hosts can be given as
+CZECS_HTTP_AUTH_<HOST>, e.g. CZECS_HTTP_AUTH_TEMPLATES_EXAMPLE_COM="Bearer TOKEN".`,
+	}
+
+	viewCmd := &cobra.Command{
+		Use:          "view",
+		Short:        "Show the resolved settings of an environment, or the whole project config",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			view, err := config.view()
+			return writeResult(cmd, view, err)
+		},
+	}
+	viewCmd.Flags().StringVar(&config.env, "env", "", "environment to show")
+	cmd.AddCommand(viewCmd)
+	return cmd
+}
+
+// view returns the project config, or the settings of one environment with --env, printing them as YAML
+// unless the result document is written instead.
+func (c *configCmd) view() (interface{}, error) {
+	path, err := findProjectConfig()
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no %s found in the current directory or its parents", projectConfigFile)
+	}
+	project, err := readProjectConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var view interface{} = project
+	if c.env != "" {
+		view, err = project.environment(c.env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if jsonOutput() {
+		return view, nil
+	}
+	out, err := yaml.Marshal(view)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot format project config")
+	}
+	fmt.Print(string(out))
+	return nil, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newConfigCmd())
+}
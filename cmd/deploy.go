@@ -0,0 +1,585 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// deployManifest describes a set of tasks and services that are deployed together.
+// Cluster, balances and values at the top level apply to every task and service.
+type deployManifest struct {
+	Cluster    string          `json:"cluster"`
+	Balances   []string        `json:"balances"`
+	Set        []string        `json:"set"`
+	SetString  []string        `json:"setString"`
+	Rollback   bool            `json:"rollback"`
+	Deregister bool            `json:"deregister"`
+	Timeout    *int            `json:"timeout"`
+	Tasks      []deployTask    `json:"tasks"`
+	Services   []deployService `json:"services"`
+}
+
+// deployTask is a one-off task run before any service is upgraded. Tasks in the same stage are run in
+// parallel; stages are run in ascending order.
+type deployTask struct {
+	Name              string   `json:"name"`
+	Template          string   `json:"template"`
+	Cluster           string   `json:"cluster"`
+	Balances          []string `json:"balances"`
+	Set               []string `json:"set"`
+	SetString         []string `json:"setString"`
+	TaskDefinitionArn string   `json:"taskDefinitionArn"`
+	// TaskDefinitionFrom names a service in the manifest; the task is run with its new task definition.
+	TaskDefinitionFrom string `json:"taskDefinitionFrom"`
+	Stage              int    `json:"stage"`
+	Timeout            *int   `json:"timeout"`
+}
+
+// deployService is a service upgraded to a new task definition. Services in the same stage are
+// upgraded in parallel; stages are upgraded in ascending order.
+type deployService struct {
+	Name              string   `json:"name"`
+	Service           string   `json:"service"`
+	Template          string   `json:"template"`
+	Cluster           string   `json:"cluster"`
+	Balances          []string `json:"balances"`
+	Set               []string `json:"set"`
+	SetString         []string `json:"setString"`
+	TaskDefinitionArn string   `json:"taskDefinitionArn"`
+	Stage             int      `json:"stage"`
+	CircuitBreaker    bool     `json:"circuitBreaker"`
+	Timeout           *int     `json:"timeout"`
+}
+
+// deployedService tracks the progress of one service of the manifest during a deploy.
+type deployedService struct {
+	name              string
+	stage             int
+	opts              czecs.UpgradeOptions
+	oldTaskDefinition string
+	taskDefnArn       string
+	// registered is set if the deploy registered taskDefnArn from a template
+	registered   bool
+	started      bool
+	deploymentID string
+	rolledBack   bool
+	err          error
+}
+
+// deployResult is the result document of the deploy command.
+type deployResult struct {
+	Tasks    []deployTaskResult    `json:"tasks"`
+	Services []deployServiceResult `json:"services"`
+}
+
+type deployTaskResult struct {
+	Name string `json:"name"`
+	*czecs.RunTaskResult
+}
+
+type deployServiceResult struct {
+	Name              string `json:"name"`
+	Service           string `json:"service"`
+	Cluster           string `json:"cluster"`
+	Stage             int    `json:"stage"`
+	OldTaskDefinition string `json:"oldTaskDefinition,omitempty"`
+	TaskDefinitionArn string `json:"taskDefinitionArn,omitempty"`
+	DeploymentID      string `json:"deploymentId,omitempty"`
+	// Status is upgraded, rolled back, failed, or skipped if the service was never upgraded
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type deployCmd struct {
+	values     czecs.Values
+	rollback   bool
+	deregister bool
+	timeout    int
+	locking    lockOptions
+}
+
+func newDeployCmd() *cobra.Command {
+	deploy := &deployCmd{}
+	cmd := &cobra.Command{
+		Use:   "deploy [manifest.yaml]",
+		Short: "Deploy several tasks and services together from a manifest",
+		Long: `This command deploys a set of tasks and services described in a manifest file.
+
+The task definitions of all services are registered first. Then the tasks in
+the manifest are run stage by stage, and must all succeed. Finally the services
+are upgraded stage by stage. Tasks or services with the same stage are run in
+parallel; the stage defaults to 0.
+If any service fails to upgrade and rollback is enabled, all services that were
+already upgraded are rolled back to their previous task definitions.
+
+Relative template and balances paths in a local manifest are relative to the
+manifest's directory. Values passed on the command line via --balances, --set
+and --set-string apply to every task and service, overriding the manifest.
+
+Example manifest:
+
+cluster: example-cluster
+balances: [balances.prod.json]
+rollback: true
+tasks:
+  - name: migrations
+    template: migrate.json
+    taskDefinitionFrom: web
+  - name: warm-cache
+    template: warm-cache.json
+    taskDefinitionFrom: web
+    stage: 1
+services:
+  - name: web
+    service: example-prod-web
+    template: web.json
+  - name: worker
+    service: example-prod-worker
+    template: worker.json
+  - name: scheduler
+    service: example-prod-scheduler
+    template: scheduler.json
+    stage: 1`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Created first, since the manifest itself may be read from S3
+			sess := newSession(nil)
+			manifest, err := readDeployManifest(args[0])
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("rollback") {
+				manifest.Rollback = deploy.rollback
+			}
+			if cmd.Flags().Changed("deregister") {
+				manifest.Deregister = deploy.deregister
+			}
+			if cmd.Flags().Changed("timeout") || manifest.Timeout == nil {
+				manifest.Timeout = &deploy.timeout
+			}
+
+			client := newClient(sess)
+			var locks []*lock.Held
+			defer func() { releaseLocks(locks...) }()
+			for _, service := range manifest.Services {
+				held, err := deploy.locking.lockService(sess, client, clusterFor(manifest, service.Cluster), service.Service)
+				if err != nil {
+					return err
+				}
+				locks = append(locks, held)
+			}
+			defer abortOnLostLocks(locks, client)()
+			result := &deployResult{}
+			err = deploy.run(manifest, client, result)
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	addValuesFlags(cmd, &deploy.values)
+	f := cmd.Flags()
+	f.BoolVar(&deploy.rollback, "rollback", false, "rollback all upgraded services to previous versions if any deployment failed; overrides the manifest")
+	f.BoolVar(&deploy.deregister, "deregister", false, "remove old task definitions on success (or new task definitions on failure); overrides the manifest")
+	f.IntVarP(&deploy.timeout, "timeout", "t", 600, "Seconds to wait for each task or service before failing, unless set in the manifest. Set to 0 for unlimited wait.")
+	addLockFlags(cmd, &deploy.locking)
+
+	return cmd
+}
+
+// readDeployManifest reads a YAML or JSON deploy manifest. Relative paths in a local or git manifest are
+// resolved relative to the manifest's directory.
+func readDeployManifest(manifestFile string) (*deployManifest, error) {
+	// A manifest from git is read from a checkout, so that the paths in it are relative to the checkout
+	manifestFile, err := tasks.Resolve(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	rawManifest, err := tasks.ReadFileOrURI(manifestFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading deploy manifest %v", manifestFile)
+	}
+	var manifest deployManifest
+	if err := yaml.Unmarshal(rawManifest, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing deploy manifest %v", manifestFile)
+	}
+
+	names := map[string]bool{}
+	for i := range manifest.Services {
+		service := &manifest.Services[i]
+		if service.Name == "" {
+			service.Name = service.Service
+		}
+		if service.Service == "" {
+			service.Service = service.Name
+		}
+		if service.Name == "" {
+			return nil, fmt.Errorf("service #%d in deploy manifest has no name", i+1)
+		}
+		if names[service.Name] {
+			return nil, fmt.Errorf("duplicate service %#v in deploy manifest", service.Name)
+		}
+		names[service.Name] = true
+		if (service.Template != "") == (service.TaskDefinitionArn != "") {
+			return nil, fmt.Errorf("exactly one of template or taskDefinitionArn must be provided for service %#v", service.Name)
+		}
+	}
+	for i := range manifest.Tasks {
+		task := &manifest.Tasks[i]
+		if task.Name == "" {
+			task.Name = fmt.Sprintf("task #%d", i+1)
+		}
+		if task.Template == "" {
+			return nil, fmt.Errorf("no template provided for task %#v", task.Name)
+		}
+		if task.TaskDefinitionFrom != "" && !names[task.TaskDefinitionFrom] {
+			return nil, fmt.Errorf("task %#v uses the task definition of unknown service %#v", task.Name, task.TaskDefinitionFrom)
+		}
+	}
+
+	if !tasks.IsURI(manifestFile) {
+		dir := filepath.Dir(manifestFile)
+		manifest.Balances = relativeTo(dir, manifest.Balances)
+		for i := range manifest.Tasks {
+			task := &manifest.Tasks[i]
+			task.Template = relativeTo(dir, []string{task.Template})[0]
+			task.Balances = relativeTo(dir, task.Balances)
+		}
+		for i := range manifest.Services {
+			service := &manifest.Services[i]
+			if service.Template != "" {
+				service.Template = relativeTo(dir, []string{service.Template})[0]
+			}
+			service.Balances = relativeTo(dir, service.Balances)
+		}
+	}
+	return &manifest, nil
+}
+
+// relativeTo resolves relative local file paths relative to dir, leaving absolute paths and URIs as is.
+func relativeTo(dir string, paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		if tasks.IsURI(path) || filepath.IsAbs(path) {
+			resolved[i] = path
+		} else {
+			resolved[i] = filepath.Join(dir, path)
+		}
+	}
+	return resolved
+}
+
+// valuesFor combines the values of the manifest, a task or service, and the command line, in increasing precedence.
+func (d *deployCmd) valuesFor(manifest *deployManifest, balances, set, setString []string) czecs.Values {
+	return czecs.Values{
+		BalanceFiles: concat(manifest.Balances, balances, d.values.BalanceFiles),
+		Set:          concat(manifest.Set, set, d.values.Set),
+		SetString:    concat(manifest.SetString, setString, d.values.SetString),
+		Partials:     d.values.Partials,
+		Schema:       d.values.Schema,
+		Strict:       d.values.Strict,
+	}
+}
+
+func concat(lists ...[]string) []string {
+	result := []string{}
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+	return result
+}
+
+func clusterFor(manifest *deployManifest, cluster string) string {
+	if cluster != "" {
+		return cluster
+	}
+	return manifest.Cluster
+}
+
+func timeoutFor(manifest *deployManifest, timeout *int) int {
+	if timeout != nil {
+		return *timeout
+	}
+	return *manifest.Timeout
+}
+
+// run deploys the manifest, filling in result with the outcome of every task and service as it goes.
+func (d *deployCmd) run(manifest *deployManifest, client *czecs.Client, result *deployResult) error {
+	services := make([]*deployedService, len(manifest.Services))
+	byName := map[string]*deployedService{}
+	for i, service := range manifest.Services {
+		opts := czecs.UpgradeOptions{}
+		opts.Cluster = clusterFor(manifest, service.Cluster)
+		opts.Service = service.Service
+		opts.Values = d.valuesFor(manifest, service.Balances, service.Set, service.SetString)
+		opts.Timeout = timeoutFor(manifest, service.Timeout)
+		opts.CircuitBreaker = service.CircuitBreaker
+		services[i] = &deployedService{
+			name:  service.Name,
+			stage: service.Stage,
+			opts:  opts,
+		}
+		byName[service.Name] = services[i]
+	}
+	defer func() { result.Services = newDeployServiceResults(services) }()
+
+	// Look up every service and register all new task definitions before changing anything,
+	// so that missing services and template errors abort the deploy early.
+	for i, service := range services {
+		existing, err := client.DescribeService(service.opts.Cluster, service.opts.Service)
+		if err != nil {
+			d.deregisterNew(client, manifest, services[:i])
+			return errors.Wrapf(err, "service %s", service.name)
+		}
+		service.oldTaskDefinition = aws.StringValue(existing.TaskDefinition)
+		log.Infof("Existing task definition of service %s: %#v", service.name, service.oldTaskDefinition)
+		taskDefnArn, err := client.NewTaskDefinition(manifest.Services[i].Template, service.opts.Values, manifest.Services[i].TaskDefinitionArn)
+		if err != nil {
+			d.deregisterNew(client, manifest, services[:i])
+			return errors.Wrapf(err, "service %s", service.name)
+		}
+		service.taskDefnArn = taskDefnArn
+		service.registered = manifest.Services[i].Template != ""
+	}
+
+	if err := d.runTasks(client, manifest, byName, result); err != nil {
+		d.deregisterNew(client, manifest, services)
+		return err
+	}
+
+	err := d.upgradeServices(client, services)
+	if err != nil {
+		if manifest.Rollback && client.Context != nil && client.Context.Err() != nil {
+			log.Warnf("Not rolling back services, since the deploy was aborted: %s", client.Context.Err())
+			d.deregisterNew(client, manifest, notStarted(services))
+		} else if manifest.Rollback {
+			if rollbackErr := d.rollbackServices(client, manifest, services); rollbackErr != nil {
+				return errors.Wrapf(err, "%s; also", rollbackErr)
+			}
+		} else {
+			d.deregisterNew(client, manifest, notStarted(services))
+		}
+		return err
+	}
+
+	if manifest.Deregister {
+		for _, service := range services {
+			if service.oldTaskDefinition != service.taskDefnArn {
+				client.DeregisterTaskDefinition(service.oldTaskDefinition, "old")
+			}
+		}
+	}
+	log.Infof("Successfully deployed %d service(s)", len(services))
+	return nil
+}
+
+// runTasks runs the tasks of the manifest stage by stage, stopping after the first stage with a failure.
+func (d *deployCmd) runTasks(client *czecs.Client, manifest *deployManifest, services map[string]*deployedService, result *deployResult) error {
+	stages := make([]int, len(manifest.Tasks))
+	for i, task := range manifest.Tasks {
+		stages[i] = task.Stage
+	}
+	for _, stage := range byStage(stages) {
+		taskResults := make([]*czecs.RunTaskResult, len(stage))
+		errs := make([]error, len(stage))
+		var wg sync.WaitGroup
+		for i, index := range stage {
+			wg.Add(1)
+			go func(i int, task deployTask) {
+				defer wg.Done()
+				taskResults[i], errs[i] = d.runTask(client, manifest, task, services)
+			}(i, manifest.Tasks[index])
+		}
+		wg.Wait()
+
+		var failures []string
+		for i, index := range stage {
+			task := manifest.Tasks[index]
+			result.Tasks = append(result.Tasks, deployTaskResult{Name: task.Name, RunTaskResult: taskResults[i]})
+			if errs[i] != nil {
+				failures = append(failures, fmt.Sprintf("task %s: %s", task.Name, errs[i]))
+			}
+		}
+		if len(failures) > 0 {
+			return errors.New(strings.Join(failures, "; "))
+		}
+	}
+	return nil
+}
+
+// byStage groups the indexes of the given stages by stage, in ascending order of stage.
+func byStage(stages []int) [][]int {
+	indexes := map[int][]int{}
+	var order []int
+	for i, stage := range stages {
+		if _, ok := indexes[stage]; !ok {
+			order = append(order, stage)
+		}
+		indexes[stage] = append(indexes[stage], i)
+	}
+	sort.Ints(order)
+	grouped := make([][]int, len(order))
+	for i, stage := range order {
+		grouped[i] = indexes[stage]
+	}
+	return grouped
+}
+
+// runTask runs a one-off task of the manifest to completion.
+func (d *deployCmd) runTask(client *czecs.Client, manifest *deployManifest, task deployTask, services map[string]*deployedService) (*czecs.RunTaskResult, error) {
+	log.Infof("Running task %#v", task.Name)
+	opts := czecs.RunTaskOptions{
+		Template:          task.Template,
+		Values:            d.valuesFor(manifest, task.Balances, task.Set, task.SetString),
+		Cluster:           clusterFor(manifest, task.Cluster),
+		TaskDefinitionArn: task.TaskDefinitionArn,
+		Timeout:           timeoutFor(manifest, task.Timeout),
+	}
+	if task.TaskDefinitionFrom != "" {
+		opts.TaskDefinitionArn = services[task.TaskDefinitionFrom].taskDefnArn
+	}
+	return client.RunTask(opts)
+}
+
+// upgradeService updates a service to the given task definition, already registered, returning the ID of the deployment.
+func upgradeService(client *czecs.Client, service *deployedService, taskDefnArn string) (string, error) {
+	opts := service.opts
+	opts.TaskDefinitionArn = taskDefnArn
+	result, err := client.Upgrade(opts)
+	if result == nil {
+		return "", err
+	}
+	return result.DeploymentID, err
+}
+
+// upgradeServices upgrades the services stage by stage, stopping after the first stage with a failure.
+func (d *deployCmd) upgradeServices(client *czecs.Client, services []*deployedService) error {
+	stages := make([]int, len(services))
+	for i, service := range services {
+		stages[i] = service.stage
+	}
+	for _, indexes := range byStage(stages) {
+		stage := make([]*deployedService, len(indexes))
+		for i, index := range indexes {
+			stage[i] = services[index]
+		}
+		var wg sync.WaitGroup
+		for _, service := range stage {
+			service.started = true
+			serviceClient := *client
+			if len(stage) > 1 {
+				serviceClient.ProgressPrefix = fmt.Sprintf("[%s] ", service.name)
+			}
+			wg.Add(1)
+			go func(service *deployedService) {
+				defer wg.Done()
+				service.deploymentID, service.err = upgradeService(&serviceClient, service, service.taskDefnArn)
+			}(service)
+		}
+		wg.Wait()
+
+		var failures []string
+		for _, service := range stage {
+			if service.err != nil {
+				failures = append(failures, fmt.Sprintf("service %s: %s", service.name, service.err))
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("deploy failed: %s", strings.Join(failures, "; "))
+		}
+	}
+	return nil
+}
+
+// rollbackServices returns every service whose upgrade was started to its old task definition,
+// in reverse order of upgrade, deregistering their new task definitions if deregistration is enabled.
+func (d *deployCmd) rollbackServices(client *czecs.Client, manifest *deployManifest, services []*deployedService) error {
+	var failures []string
+	for i := len(services) - 1; i >= 0; i-- {
+		service := services[i]
+		if !service.started || service.oldTaskDefinition == service.taskDefnArn {
+			continue
+		}
+		log.Warnf("Rolling back service %#v to old task definition %#v", service.opts.Service, service.oldTaskDefinition)
+		if _, err := upgradeService(client, service, service.oldTaskDefinition); err != nil {
+			failures = append(failures, fmt.Sprintf("service %s: cannot rollback: %s", service.name, err))
+			continue
+		}
+		service.rolledBack = true
+		if service.registered && manifest.Deregister {
+			client.DeregisterTaskDefinition(service.taskDefnArn, "new")
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("rollback failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// deregisterNew deregisters the new task definitions of services that were never upgraded to them,
+// if deregistration is enabled.
+func (d *deployCmd) deregisterNew(client *czecs.Client, manifest *deployManifest, services []*deployedService) {
+	if !manifest.Deregister {
+		return
+	}
+	for _, service := range services {
+		// Only deregister task definitions registered by this deploy
+		if service.registered {
+			client.DeregisterTaskDefinition(service.taskDefnArn, "new")
+		}
+	}
+}
+
+func newDeployServiceResults(services []*deployedService) []deployServiceResult {
+	var results []deployServiceResult
+	for _, service := range services {
+		result := deployServiceResult{
+			Name:              service.name,
+			Service:           service.opts.Service,
+			Cluster:           service.opts.Cluster,
+			Stage:             service.stage,
+			OldTaskDefinition: service.oldTaskDefinition,
+			TaskDefinitionArn: service.taskDefnArn,
+			DeploymentID:      service.deploymentID,
+			Status:            "skipped",
+		}
+		if service.err != nil {
+			result.Error = service.err.Error()
+		}
+		switch {
+		case service.rolledBack:
+			result.Status = "rolled back"
+		case service.err != nil:
+			result.Status = "failed"
+		case service.started:
+			result.Status = "upgraded"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func notStarted(services []*deployedService) []*deployedService {
+	var result []*deployedService
+	for _, service := range services {
+		if !service.started {
+			result = append(result, service)
+		}
+	}
+	return result
+}
+
+func init() {
+	rootCmd.AddCommand(newDeployCmd())
+}
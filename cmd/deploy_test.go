@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+	"github.com/sirupsen/logrus"
+)
+
+const testCluster = "test"
+
+// deployTestFiles are the templates used by the manifests of the tests, by file name.
+var deployTestFiles = map[string]string{
+	"web.json": `{
+  "family": "test-web",
+  "containerDefinitions": [{"name": "web", "image": "example/web:{{ .Values.tag }}", "memoryReservation": 128}]
+}`,
+	"worker.json": `{
+  "family": "test-worker",
+  "containerDefinitions": [{"name": "worker", "image": "example/web:{{ .Values.tag }}", "memoryReservation": 128}]
+}`,
+	"migrate.json": `{"taskDefinition": "test-web"}`,
+}
+
+// newDeployTest writes the manifest and the templates to a temporary directory, and installs the web and
+// worker services with tag v1 in the fake.
+func newDeployTest(t *testing.T, manifest string) (*ecsfake.ECS, *czecs.Client, *deployManifest) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{"manifest.yaml": manifest}
+	for name, content := range deployTestFiles {
+		files[name] = content
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := ecsfake.New(testCluster)
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	client := czecs.New(fake, ecsfake.Region)
+	client.Log = log
+	client.WaiterOptions = fake.WaiterOptions()
+	for _, service := range []string{"web", "worker"} {
+		_, err := client.Install(czecs.InstallOptions{
+			Cluster:  testCluster,
+			Service:  service,
+			Template: filepath.Join(dir, service+".json"),
+			Values:   czecs.Values{Set: []string{"tag=v1"}},
+			Timeout:  15,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parsed, err := readDeployManifest(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := 15
+	parsed.Timeout = &timeout
+	return fake, client, parsed
+}
+
+// serviceTaskDefinition returns the family:revision of the task definition of a service of the fake.
+func serviceTaskDefinition(t *testing.T, fake *ecsfake.ECS, service string) string {
+	t.Helper()
+	output, err := fake.DescribeServices(&ecs.DescribeServicesInput{Cluster: aws.String(testCluster), Services: []*string{&service}})
+	if err != nil || len(output.Services) == 0 {
+		t.Fatalf("DescribeServices %s: %v", service, err)
+	}
+	arn := aws.StringValue(output.Services[0].TaskDefinition)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func taskDefinitionStatus(t *testing.T, fake *ecsfake.ECS, taskDefinition string) string {
+	t.Helper()
+	output, err := fake.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &taskDefinition})
+	if err != nil {
+		t.Fatalf("DescribeTaskDefinition %s: %s", taskDefinition, err)
+	}
+	return aws.StringValue(output.TaskDefinition.Status)
+}
+
+const deployTestManifest = `
+cluster: test
+set: [tag=v2]
+tasks:
+  - name: migrations
+    template: migrate.json
+    taskDefinitionFrom: web
+  - name: seed
+    template: migrate.json
+    taskDefinitionFrom: web
+    stage: 1
+services:
+  - name: web
+    template: web.json
+  - name: worker
+    template: worker.json
+    stage: 1
+`
+
+func TestDeploy(t *testing.T) {
+	tests := []struct {
+		name string
+		// behaviors of the new task definitions, by family:revision
+		behaviors  map[string]ecsfake.Behavior
+		rollback   bool
+		deregister bool
+
+		wantErr string
+		// wantTasks are the names of the tasks run
+		wantTasks []string
+		// wantStatus is the status of each service in the result
+		wantStatus map[string]string
+		// wantTaskDefinition is the task definition of each service afterwards
+		wantTaskDefinition map[string]string
+		// wantNew is the status of the new task definitions afterwards
+		wantNew string
+	}{
+		{
+			name:               "success",
+			wantTasks:          []string{"migrations", "seed"},
+			wantStatus:         map[string]string{"web": "upgraded", "worker": "upgraded"},
+			wantTaskDefinition: map[string]string{"web": "test-web:2", "worker": "test-worker:2"},
+			wantNew:            ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "task failed",
+			behaviors:          map[string]ecsfake.Behavior{"test-web:2": {ExitCodes: map[string]int64{"web": 1}}},
+			deregister:         true,
+			wantErr:            "task migrations",
+			wantTasks:          []string{"migrations"},
+			wantStatus:         map[string]string{"web": "skipped", "worker": "skipped"},
+			wantTaskDefinition: map[string]string{"web": "test-web:1", "worker": "test-worker:1"},
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:               "service failed, all rolled back",
+			behaviors:          map[string]ecsfake.Behavior{"test-worker:2": {Unable: true}},
+			rollback:           true,
+			wantErr:            "service worker",
+			wantTasks:          []string{"migrations", "seed"},
+			wantStatus:         map[string]string{"web": "rolled back", "worker": "rolled back"},
+			wantTaskDefinition: map[string]string{"web": "test-web:1", "worker": "test-worker:1"},
+			// Without deregister, the new task definitions are kept
+			wantNew: ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "service failed, all rolled back and deregistered",
+			behaviors:          map[string]ecsfake.Behavior{"test-worker:2": {Unable: true}},
+			rollback:           true,
+			deregister:         true,
+			wantErr:            "service worker",
+			wantTasks:          []string{"migrations", "seed"},
+			wantStatus:         map[string]string{"web": "rolled back", "worker": "rolled back"},
+			wantTaskDefinition: map[string]string{"web": "test-web:1", "worker": "test-worker:1"},
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:               "service failed without rollback",
+			behaviors:          map[string]ecsfake.Behavior{"test-worker:2": {Unable: true}},
+			wantErr:            "service worker",
+			wantTasks:          []string{"migrations", "seed"},
+			wantStatus:         map[string]string{"web": "upgraded", "worker": "failed"},
+			wantTaskDefinition: map[string]string{"web": "test-web:2", "worker": "test-worker:2"},
+			wantNew:            ecs.TaskDefinitionStatusActive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, client, manifest := newDeployTest(t, deployTestManifest)
+			for taskDefinition, behavior := range test.behaviors {
+				fake.Behave(taskDefinition, behavior)
+			}
+			manifest.Rollback, manifest.Deregister = test.rollback, test.deregister
+
+			result := &deployResult{}
+			err := (&deployCmd{}).run(manifest, client, result)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			}
+
+			var tasks []string
+			for _, task := range result.Tasks {
+				tasks = append(tasks, task.Name)
+			}
+			if !reflect.DeepEqual(tasks, test.wantTasks) {
+				t.Errorf("tasks = %v, want %v", tasks, test.wantTasks)
+			}
+			for _, service := range result.Services {
+				if service.Status != test.wantStatus[service.Name] {
+					t.Errorf("status of %s = %#v, want %#v", service.Name, service.Status, test.wantStatus[service.Name])
+				}
+				if got := serviceTaskDefinition(t, fake, service.Service); got != test.wantTaskDefinition[service.Name] {
+					t.Errorf("task definition of %s = %#v, want %#v", service.Name, got, test.wantTaskDefinition[service.Name])
+				}
+			}
+			for _, taskDefinition := range []string{"test-web:2", "test-worker:2"} {
+				if status := taskDefinitionStatus(t, fake, taskDefinition); status != test.wantNew {
+					t.Errorf("status of %s = %#v, want %#v", taskDefinition, status, test.wantNew)
+				}
+			}
+		})
+	}
+}
+
+func TestDeployTaskStages(t *testing.T) {
+	// The second task of stage 0 fails, so the task of stage 1 is not run
+	fake, client, manifest := newDeployTest(t, `
+cluster: test
+set: [tag=v2]
+tasks:
+  - name: late
+    template: migrate.json
+    stage: 1
+  - name: first
+    template: migrate.json
+  - name: failing
+    template: migrate.json
+    taskDefinitionFrom: web
+services:
+  - name: web
+    template: web.json
+`)
+	fake.Behave("test-web:2", ecsfake.Behavior{ExitCodes: map[string]int64{"web": 1}})
+
+	result := &deployResult{}
+	err := (&deployCmd{}).run(manifest, client, result)
+	if err == nil || !strings.Contains(err.Error(), "task failing") {
+		t.Fatalf("expected task failing to fail, got %v", err)
+	}
+	var tasks []string
+	for _, task := range result.Tasks {
+		tasks = append(tasks, task.Name)
+	}
+	if want := []string{"first", "failing"}; !reflect.DeepEqual(tasks, want) {
+		t.Errorf("tasks = %v, want %v", tasks, want)
+	}
+}
+
+func TestByStage(t *testing.T) {
+	got := byStage([]int{1, 0, 2, 0, 1})
+	if want := [][]int{{1, 3}, {0, 4}, {2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("byStage = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/spf13/cobra"
+)
+
+// fetchConfig configures how templates, balances and other files are read from URIs, in the project config file.
+type fetchConfig struct {
+	Timeout  *int   `json:"timeout,omitempty"`
+	Retries  *int   `json:"retries,omitempty"`
+	CacheDir string `json:"cacheDir,omitempty"`
+	// Auth is the authentication to use for each host
+	Auth map[string]authConfig `json:"auth,omitempty"`
+}
+
+// authConfig is the authentication to a host. Secrets are read from environment variables, to keep them
+// out of the config file.
+type authConfig struct {
+	BearerTokenEnv string `json:"bearerTokenEnv,omitempty"`
+	Username       string `json:"username,omitempty"`
+	PasswordEnv    string `json:"passwordEnv,omitempty"`
+}
+
+// fetchOptions are the global flags configuring how files are read from URIs
+type fetchOptions struct {
+	timeout  int
+	retries  int
+	cacheDir string
+}
+
+var fetchFlags fetchOptions
+
+func addFetchFlags(cmd *cobra.Command) {
+	defaults := tasks.DefaultFetchOptions()
+	f := cmd.PersistentFlags()
+	f.IntVar(&fetchFlags.timeout, "fetch-timeout", int(defaults.Timeout/time.Second), "Seconds to wait for each request reading a file from an http(s) or S3 URI. Set to 0 for unlimited wait.")
+	f.IntVar(&fetchFlags.retries, "fetch-retries", defaults.Retries, "number of times to retry reading a file from a URI after network or server errors")
+	f.StringVar(&fetchFlags.cacheDir, "cache-dir", os.Getenv("CZECS_CACHE_DIR"), "cache files read from URIs in this directory, revalidating them by ETag (default is $CZECS_CACHE_DIR; no cache if empty)")
+}
+
+// configureFetch sets how files are read from URIs, from the global flags and the fetch settings of the
+// project config file, if any; flags given on the command line override the config file.
+func configureFetch(cmd *cobra.Command) error {
+	config := &fetchConfig{}
+	path, err := findProjectConfig()
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		project, err := readProjectConfig(path)
+		if err != nil {
+			return err
+		}
+		if project.Fetch != nil {
+			config = project.Fetch
+		}
+	}
+
+	flags := cmd.Flags()
+	opts := tasks.DefaultFetchOptions()
+	opts.Timeout = time.Duration(fetchFlags.timeout) * time.Second
+	if config.Timeout != nil && !flags.Changed("fetch-timeout") {
+		opts.Timeout = time.Duration(*config.Timeout) * time.Second
+	}
+	opts.Retries = fetchFlags.retries
+	if config.Retries != nil && !flags.Changed("fetch-retries") {
+		opts.Retries = *config.Retries
+	}
+	opts.CacheDir = fetchFlags.cacheDir
+	if config.CacheDir != "" && !flags.Changed("cache-dir") {
+		opts.CacheDir = config.CacheDir
+	}
+	// Resolved when reading from the host, so that commands not reading from it don't need its secrets
+	opts.Authorize = func(host string) (string, error) {
+		auth, ok := config.Auth[host]
+		if !ok {
+			return "", nil
+		}
+		return auth.authorization(host)
+	}
+	tasks.SetFetchOptions(opts)
+	return nil
+}
+
+// authorization returns the Authorization header of the authentication to the host.
+func (a authConfig) authorization(host string) (string, error) {
+	switch {
+	case a.BearerTokenEnv != "":
+		token := os.Getenv(a.BearerTokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s holding the bearer token for %s is not set", a.BearerTokenEnv, host)
+		}
+		return "Bearer " + token, nil
+	case a.Username != "":
+		password := os.Getenv(a.PasswordEnv)
+		if a.PasswordEnv != "" && password == "" {
+			return "", fmt.Errorf("environment variable %s holding the password for %s is not set", a.PasswordEnv, host)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.Username+":"+password)), nil
+	}
+	return "", fmt.Errorf("authentication for %s must set bearerTokenEnv, or username and passwordEnv", host)
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// lockOptions configures the deployment lock taken by commands changing a service.
+type lockOptions struct {
+	backend string
+	holder  string
+	timeout int
+	ttl     int
+}
+
+func addLockFlags(cmd *cobra.Command, o *lockOptions) {
+	f := cmd.Flags()
+	f.StringVar(&o.backend, "lock", "", "lock the service while deploying, to prevent concurrent czecs runs; one of dynamodb:TABLE or tags (tags can only lock existing services, so not on install)")
+	f.StringVar(&o.holder, "lock-holder", lock.DefaultHolder(), "identity recorded as the holder of the lock")
+	f.IntVar(&o.timeout, "lock-timeout", 0, "Seconds to wait for the lock if someone else holds it. Set to 0 to fail immediately.")
+	f.IntVar(&o.ttl, "lock-ttl", 1800, "Seconds after which the lock expires unless renewed; it is renewed while czecs runs.")
+}
+
+// locker returns the lock backend given by --lock, locking services reachable through svc,
+// or nil if locking is disabled.
+func (o *lockOptions) locker(sess *session.Session, svc ecsiface.ECSAPI) (lock.Locker, error) {
+	switch {
+	case o.backend == "":
+		return nil, nil
+	case o.backend == "tags":
+		return lock.NewTagLocker(svc), nil
+	case strings.HasPrefix(o.backend, "dynamodb:"):
+		return lock.NewDynamoDBLocker(dynamodb.New(sess), strings.TrimPrefix(o.backend, "dynamodb:")), nil
+	}
+	return nil, fmt.Errorf("unknown lock backend %#v; expected dynamodb:TABLE or tags", o.backend)
+}
+
+// lockService acquires the deployment lock of a service in the region of the client. Returns nil if locking
+// is disabled; a nil lock can still be released.
+func (o *lockOptions) lockService(sess *session.Session, client *czecs.Client, cluster string, service string) (*lock.Held, error) {
+	locker, err := o.locker(sess, client.ECS)
+	if locker == nil || err != nil {
+		return nil, err
+	}
+	if o.ttl <= 0 {
+		return nil, fmt.Errorf("--lock-ttl must be a positive number of seconds")
+	}
+	return lock.Acquire(locker, lockKey(client.Region, cluster, service), o.holder, time.Duration(o.ttl)*time.Second, time.Duration(o.timeout)*time.Second)
+}
+
+// checkNewService returns an error if the lock backend cannot lock a service that does not exist yet.
+func (o *lockOptions) checkNewService() error {
+	if o.backend == "tags" {
+		return fmt.Errorf("--lock tags can only lock existing services, so it cannot be used to install one; use --lock dynamodb:TABLE instead")
+	}
+	return nil
+}
+
+// releaseLocks releases deployment locks taken by lockService, logging any errors.
+func releaseLocks(locks ...*lock.Held) {
+	for _, held := range locks {
+		if err := held.Release(); err != nil {
+			log.Warnf("%s; it will expire on its own", err)
+		}
+	}
+}
+
+// abortOnLostLocks makes the waits of the given clients fail once any of the given deployment locks is lost,
+// since someone else may then deploy the same services. The returned function stops watching the locks.
+func abortOnLostLocks(locks []*lock.Held, clients ...*czecs.Client) context.CancelFunc {
+	ctx, cancel := lock.Context(context.Background(), locks...)
+	for _, client := range clients {
+		client.Context = ctx
+	}
+	return cancel
+}
+
+// lockKey returns the key of the deployment lock of a service. It includes the region, since clusters of the same
+// name in different regions are different clusters.
+func lockKey(region string, cluster string, service string) string {
+	return fmt.Sprintf("%s/%s/%s", region, cluster, service)
+}
+
+type lockCmd struct {
+	lockOptions
+}
+
+func newLockCmd() *cobra.Command {
+	lockCommand := &lockCmd{}
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Inspect and manage deployment locks",
+		Long: `Deployment locks prevent concurrent czecs runs from deploying the same service.
+
+They are taken by install, upgrade, apply, deploy, restart and scale when run with --lock. Rolling back is part
+of these commands (--rollback), so it happens under the same lock; there is no separate rollback command to lock.
+
+A lock is renewed while czecs runs. If it is lost, because someone else took it or it expired before it could
+be renewed, czecs stops waiting for the deployment and fails without rolling back, since another run may
+now be deploying the service.`,
+	}
+
+	statusCmd := &cobra.Command{
+		Use:          "status [cluster] [service]",
+		Short:        "Show who holds the deployment lock of a service",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lockCommand.status(args[0], args[1])
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	forceUnlockCmd := &cobra.Command{
+		Use:   "force-unlock [cluster] [service]",
+		Short: "Remove the deployment lock of a service, regardless of who holds it",
+		Long: `This command removes the deployment lock of a service.
+
+Only use this if the holder of the lock is known to no longer be running
+(e.g. a killed CI job); the lock would otherwise expire after its TTL.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lockCommand.forceUnlock(args[0], args[1])
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	for _, subCmd := range []*cobra.Command{statusCmd, forceUnlockCmd} {
+		subCmd.Flags().StringVar(&lockCommand.backend, "lock", "", "lock backend; one of dynamodb:TABLE or tags")
+		subCmd.MarkFlagRequired("lock")
+		cmd.AddCommand(subCmd)
+	}
+	return cmd
+}
+
+// lockStatusResult is the result document of the lock status command.
+type lockStatusResult struct {
+	Key    string     `json:"key"`
+	Locked bool       `json:"locked"`
+	Lock   *lock.Lock `json:"lock,omitempty"`
+}
+
+// forceUnlockResult is the result document of the lock force-unlock command.
+type forceUnlockResult struct {
+	Key string `json:"key"`
+	// Removed is the lock that was removed, if any
+	Removed *lock.Lock `json:"removed,omitempty"`
+}
+
+func (l *lockCmd) status(cluster string, service string) (*lockStatusResult, error) {
+	locker, region, err := l.newLocker()
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(region, cluster, service)
+	current, err := locker.Status(key)
+	if err != nil {
+		return nil, err
+	}
+	if !jsonOutput() {
+		if current == nil {
+			fmt.Printf("%s is not locked\n", key)
+		} else {
+			fmt.Printf("%s\n", current)
+		}
+	}
+	return &lockStatusResult{Key: key, Locked: current != nil, Lock: current}, nil
+}
+
+func (l *lockCmd) forceUnlock(cluster string, service string) (*forceUnlockResult, error) {
+	locker, region, err := l.newLocker()
+	if err != nil {
+		return nil, err
+	}
+	key := lockKey(region, cluster, service)
+	current, err := locker.Status(key)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		log.Warnf("Removing lock %s", current)
+	}
+	if err := locker.ForceUnlock(key); err != nil {
+		return nil, err
+	}
+	return &forceUnlockResult{Key: key, Removed: current}, nil
+}
+
+// newLocker returns the lock backend, and the region whose services it locks.
+func (l *lockCmd) newLocker() (lock.Locker, string, error) {
+	sess := newSession(nil)
+	client := newClient(sess)
+	locker, err := l.locker(sess, client.ECS)
+	return locker, client.Region, err
+}
+
+func init() {
+	rootCmd.AddCommand(newLockCmd())
+}
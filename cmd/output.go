@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	outputFormat string
+	// startedAt is the time the command started, reported in its result document
+	startedAt time.Time
+	// resultWritten is set once the result document was written
+	resultWritten bool
+)
+
+// commandOutput is the document written to stdout by every command run with --output json.
+type commandOutput struct {
+	Command         string      `json:"command"`
+	Success         bool        `json:"success"`
+	Error           string      `json:"error,omitempty"`
+	StartedAt       time.Time   `json:"startedAt"`
+	FinishedAt      time.Time   `json:"finishedAt"`
+	DurationSeconds float64     `json:"durationSeconds"`
+	Result          interface{} `json:"result,omitempty"`
+}
+
+func checkOutputFormat() error {
+	if outputFormat != outputText && outputFormat != outputJSON {
+		return fmt.Errorf("unknown output format %#v; expected %s or %s", outputFormat, outputText, outputJSON)
+	}
+	return nil
+}
+
+// jsonOutput returns whether stdout is reserved for the result document of the command.
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+// progressOutput returns where progress is written: stdout, unless it is reserved for the result document.
+func progressOutput() io.Writer {
+	if jsonOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// writeError writes the result document of a command that failed before producing any result, such
+// as on invalid arguments.
+func writeError(cmd *cobra.Command, err error) {
+	if !resultWritten && checkOutputFormat() == nil {
+		writeResult(cmd, nil, err)
+	}
+}
+
+// writeResult writes the result document of the command with --output json, and returns err. The result
+// is written even if the command failed, with whatever the command found out before failing.
+func writeResult(cmd *cobra.Command, result interface{}, err error) error {
+	if !jsonOutput() {
+		return err
+	}
+	finishedAt := time.Now()
+	output := commandOutput{
+		Command:         strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" "),
+		Success:         err == nil,
+		StartedAt:       startedAt,
+		FinishedAt:      finishedAt,
+		DurationSeconds: finishedAt.Sub(startedAt).Seconds(),
+		Result:          result,
+	}
+	if err != nil {
+		output.Error = err.Error()
+	}
+	document, marshalErr := json.MarshalIndent(output, "", "  ")
+	if marshalErr != nil {
+		return errors.Wrap(marshalErr, "cannot format result")
+	}
+	fmt.Println(string(document))
+	resultWritten = true
+	return err
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/spf13/cobra"
+)
+
+// planResult is the result document of a command run with --plan.
+type planResult struct {
+	Plans []*czecs.Plan `json:"plans"`
+}
+
+// addPlanFlag adds the flag making a command print its plan instead of changing anything.
+func addPlanFlag(cmd *cobra.Command, plan *bool) {
+	cmd.Flags().BoolVar(plan, "plan", false, "only print the actions that would be taken, and the changes they would make, without changing anything")
+}
+
+// printPlans prints the plans, unless the result document is written instead.
+func printPlans(plans ...*czecs.Plan) {
+	if jsonOutput() {
+		return
+	}
+	for _, plan := range plans {
+		fmt.Printf("Plan to %s service %#v in cluster %#v:\n", plan.Action, plan.Service, plan.Cluster)
+		for i, action := range plan.Actions {
+			fmt.Printf("  %d. %s: %s\n", i+1, action.Action, action.Description)
+			for _, change := range action.Changes {
+				fmt.Printf("       %s\n", change)
+			}
+		}
+		changes := 0
+		for _, action := range plan.Actions {
+			changes += len(action.Changes)
+		}
+		fmt.Printf("%d action(s), %d changed field(s)\n", len(plan.Actions), changes)
+	}
+}
@@ -1,25 +1,25 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
-	"github.com/chanzuckerberg/czecs/tasks"
-	"github.com/imdario/mergo"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/util"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"k8s.io/helm/pkg/strvals"
 )
 
 type registerCmd struct {
-	balanceFiles []string
-	values       []string
-	stringValues []string
-	strict       bool
-	dryRun       bool
+	values czecs.Values
+	dryRun bool
+}
+
+// registerResult is the result document of the register command.
+type registerResult struct {
+	TaskDefinitionArn string `json:"taskDefinitionArn,omitempty"`
+	// TaskDefinition is the rendered task definition, with --dry-run
+	TaskDefinition json.RawMessage `json:"taskDefinition,omitempty"`
 }
 
 func newRegisterCmd() *cobra.Command {
@@ -36,95 +36,63 @@ czecs register --set foo=bar --set baz=qux,spam=ham --balances balances.json cze
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			logLevel := log.InfoLevel
-			if debug { // debug overrides quiet
-				logLevel = log.DebugLevel
-			} else if quiet {
-				logLevel = log.FatalLevel
-			}
-			log.SetLevel(logLevel)
-
-			sess := session.Must(session.NewSessionWithOptions(session.Options{
-				SharedConfigState: session.SharedConfigEnable,
-			}))
-			svc := ecs.New(sess)
-			return register.run(args, svc)
+			result, err := register.run(args, newClient(newSession(nil)))
+			return writeResult(cmd, result, err)
 		},
 	}
 
-	f := cmd.Flags()
-	f.BoolVar(&register.strict, "strict", false, "fail on lint warnings")
-	f.StringSliceVarP(&register.balanceFiles, "balances", "f", []string{}, "specify values in a JSON file or an S3 URL")
-	f.StringSliceVar(&register.values, "set", []string{}, "set values on the command line (can repeat or use comma-separated values)")
-	f.StringSliceVar(&register.stringValues, "set-string", []string{}, "set STRING values on the command line (can repeat or use comma-separated values)")
-	f.BoolVar(&register.dryRun, "dry-run", false, "Do not actually register task definition; just print resulting task definition")
+	addValuesFlags(cmd, &register.values)
+	addSidecarFlag(cmd, &register.values)
+	cmd.Flags().BoolVar(&register.dryRun, "dry-run", false, "Do not actually register task definition; just print resulting task definition")
 	return cmd
 }
 
-func (r *registerCmd) registerTaskDefinition(taskDefnJSON string, svc ecsiface.ECSAPI) (string, error) {
-	var balances map[string]interface{}
-	balances, err := mergeValues(r.balanceFiles, r.values, r.stringValues)
-	if err != nil {
-		return "", err
-	}
-	values := map[string]interface{}{
-		"Values": balances,
-	}
-	log.Debugf("Values used for template: %#v", values)
-
-	registerTaskDefinitionInput, err := tasks.ParseTaskDefinition(taskDefnJSON, values, r.strict)
-	if err != nil {
-		return "", errors.Wrap(err, "cannot parse task definition")
-	}
-
-	if r.dryRun {
-		fmt.Printf("%#v\n", registerTaskDefinitionInput)
-		return "", nil
-	}
+// addValuesFlags adds the flags giving the values of templates.
+func addValuesFlags(cmd *cobra.Command, values *czecs.Values) {
+	f := cmd.Flags()
+	f.BoolVar(&values.Strict, "strict", false, "fail on lint warnings")
+	f.StringSliceVarP(&values.BalanceFiles, "balances", "f", []string{}, "specify values in a JSON file or an S3 URL")
+	f.StringSliceVar(&values.Set, "set", []string{}, "set values on the command line (can repeat or use comma-separated values)")
+	f.StringSliceVar(&values.SetString, "set-string", []string{}, "set STRING values on the command line (can repeat or use comma-separated values)")
+	f.StringSliceVar(&values.Partials, "partials", []string{}, "load partial templates from these directories of _*.tpl files, files or S3 URLs (in addition to the _*.tpl files next to the template, whose named templates they override)")
+	f.StringVar(&values.Schema, "values-schema", "", "validate values against this JSON Schema file or S3 URL (default is the values.schema.json next to the template, if any)")
+}
 
-	log.Debugf("Task definition: %+v", registerTaskDefinitionInput)
-	registerTaskDefinitionOutput, err := svc.RegisterTaskDefinition(registerTaskDefinitionInput)
-	if err != nil {
-		return "", errors.Wrap(err, "cannot register task definition")
-	}
-	taskDefn := registerTaskDefinitionOutput.TaskDefinition
-	log.Infof("Successfully registered task definition %#v", *taskDefn.TaskDefinitionArn)
-	return *taskDefn.TaskDefinitionArn, nil
+// addSidecarFlag adds the flag giving the sidecars added to task definitions.
+func addSidecarFlag(cmd *cobra.Command, values *czecs.Values) {
+	cmd.Flags().StringSliceVar(&values.Sidecars, "sidecar", []string{}, "add the containers of this sidecar (from sidecars/NAME.json next to the template, which may be a URL, or a file or S3 URL) to the task definition; can repeat")
 }
 
-func mergeValues(balanceFiles []string, values []string, stringValues []string) (map[string]interface{}, error) {
-	base := map[string]interface{}{}
-	for _, filePath := range balanceFiles {
-		balances, err := tasks.ParseBalances(filePath)
+func (r *registerCmd) run(args []string, client *czecs.Client) (*registerResult, error) {
+	if r.dryRun {
+		registerTaskDefinitionInput, err := client.RenderTaskDefinition(args[0], r.values)
 		if err != nil {
 			return nil, err
 		}
-		if err := mergo.Merge(&base, balances, mergo.WithOverride); err != nil {
-			return nil, err
-		}
-	}
-	for _, value := range values {
-		if err := strvals.ParseInto(value, base); err != nil {
-			return nil, errors.Wrap(err, "failed parsing --set data")
+		taskDefinition, err := util.MarshalAPIJSON(registerTaskDefinitionInput)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot format task definition")
 		}
-	}
-	for _, value := range stringValues {
-		if err := strvals.ParseIntoString(value, base); err != nil {
-			return nil, errors.Wrap(err, "failed parsing --set-string data")
+		if !jsonOutput() {
+			// Like render, print the task definition as the API takes it
+			out, err := json.MarshalIndent(taskDefinition, "", "  ")
+			if err != nil {
+				return nil, errors.Wrap(err, "cannot format task definition")
+			}
+			fmt.Println(string(out))
+			return nil, nil
 		}
+		return &registerResult{TaskDefinition: taskDefinition}, nil
 	}
-	return base, nil
-}
 
-func (r *registerCmd) run(args []string, svc ecsiface.ECSAPI) error {
-	taskDefnArn, err := r.registerTaskDefinition(args[0], svc)
+	taskDefnArn, err := client.Register(czecs.RegisterOptions{Template: args[0], Values: r.values})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !r.dryRun {
+	if !jsonOutput() {
 		fmt.Printf("%s\n", taskDefnArn)
 	}
-	return nil
+	return &registerResult{TaskDefinitionArn: taskDefnArn}, nil
 }
 
 func init() {
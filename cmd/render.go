@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type renderCmd struct {
+	values     czecs.Values
+	format     string
+	task       bool
+	service    czecs.InstallOptions
+	showValues bool
+}
+
+// renderResult is the result document of the render command.
+type renderResult struct {
+	// Rendered is the rendered RegisterTaskDefinition, RunTask or CreateService input
+	Rendered     json.RawMessage        `json:"rendered,omitempty"`
+	Values       map[string]interface{} `json:"values,omitempty"`
+	ValueSources map[string]string      `json:"valueSources,omitempty"`
+}
+
+// valuesView is the output of render --show-values.
+type valuesView struct {
+	Values  map[string]interface{} `json:"values"`
+	Sources map[string]string      `json:"sources"`
+}
+
+func newRenderCmd() *cobra.Command {
+	render := &renderCmd{}
+	cmd := &cobra.Command{
+		Use:   "render [--task | --service name --cluster cluster] [template.json]",
+		Short: "Render a template to JSON or YAML accepted by the ECS API",
+		Long: `This command renders a template with the given values, without calling ECS.
+
+The output uses the field names of the ECS API, so it can be passed to
+e.g. aws ecs register-task-definition --cli-input-json, or to Terraform.
+
+By default the template is a task definition template. With --task, it is a
+task template as accepted by czecs task. With --service and --cluster, the
+output is the CreateService input czecs install would use to create the
+service from the task definition template, referring to the task definition
+by its family.
+
+With --show-values, the merged values are printed instead, along with the
+balances file, --set or --set-string value each value came from. For example:
+
+czecs render --show-values -f balances.json --set image.tag=v2`,
+		SilenceUsage: true,
+		Args:         cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if render.format != outputJSON && render.format != "yaml" {
+				return fmt.Errorf("unknown format %#v; expected json or yaml", render.format)
+			}
+			if render.task && render.service.Service != "" {
+				return fmt.Errorf("--task cannot be combined with --service")
+			}
+			if (render.service.Service != "") != (render.service.Cluster != "") {
+				return fmt.Errorf("--service and --cluster must be provided together")
+			}
+			if len(args) < 1 && !render.showValues {
+				return fmt.Errorf("a template must be provided")
+			}
+			result, err := render.run(args, newClient(newSession(nil)))
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	addValuesFlags(cmd, &render.values)
+	addSidecarFlag(cmd, &render.values)
+	f := cmd.Flags()
+	f.StringVar(&render.format, "format", outputJSON, "format of the output; json or yaml")
+	f.BoolVar(&render.task, "task", false, "render a task template instead of a task definition template")
+	f.StringVar(&render.service.Service, "service", "", "render the CreateService input of a service with this name")
+	f.StringVar(&render.service.Cluster, "cluster", "", "cluster of the service rendered with --service")
+	f.BoolVar(&render.service.CircuitBreaker, "circuit-breaker", false, "enable the ECS deployment circuit breaker on the service rendered with --service")
+	f.BoolVar(&render.showValues, "show-values", false, "print the merged values and the source of each value instead")
+	return cmd
+}
+
+func (r *renderCmd) run(args []string, client *czecs.Client) (*renderResult, error) {
+	if r.showValues {
+		explanation, err := r.values.Explain()
+		if err != nil {
+			return nil, err
+		}
+		values, sources := explanation.Values, explanation.Sources()
+		if !jsonOutput() {
+			return nil, r.print(valuesView{Values: values, Sources: sources})
+		}
+		return &renderResult{Values: values, ValueSources: sources}, nil
+	}
+
+	rendered, err := r.render(args[0], client)
+	if err != nil {
+		return nil, err
+	}
+	if !jsonOutput() {
+		return nil, r.print(rendered)
+	}
+	return &renderResult{Rendered: rendered}, nil
+}
+
+// render renders the template to the JSON of the ECS API input.
+func (r *renderCmd) render(template string, client *czecs.Client) (json.RawMessage, error) {
+	var input interface{}
+	if r.task {
+		runTaskInput, err := client.RenderTask(template, r.values)
+		if err != nil {
+			return nil, err
+		}
+		input = runTaskInput
+	} else {
+		registerTaskDefinitionInput, err := client.RenderTaskDefinition(template, r.values)
+		if err != nil {
+			return nil, err
+		}
+		input = registerTaskDefinitionInput
+		if r.service.Service != "" {
+			input = czecs.CreateServiceInput(r.service, aws.StringValue(registerTaskDefinitionInput.Family))
+		}
+	}
+	rendered, err := util.MarshalAPIJSON(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot format rendered template")
+	}
+	return rendered, nil
+}
+
+// print prints v in the format chosen with --format.
+func (r *renderCmd) print(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot format output")
+	}
+	if r.format == "yaml" {
+		if out, err = yaml.JSONToYAML(out); err != nil {
+			return errors.Wrap(err, "cannot format output")
+		}
+		fmt.Print(string(out))
+		return nil
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newRenderCmd())
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/spf13/cobra"
+)
+
+type restartCmd struct {
+	opts        czecs.RestartOptions
+	parallelism int
+	locking     lockOptions
+}
+
+// restartTarget is a service that is restarted.
+type restartTarget struct {
+	waveTarget
+	result *czecs.RestartResult
+}
+
+// restartResult is the result document of the restart command.
+type restartResult struct {
+	Cluster  string                 `json:"cluster"`
+	Services []serviceRestartResult `json:"services"`
+}
+
+// serviceRestartResult is the outcome of restarting one service.
+type serviceRestartResult struct {
+	Service string `json:"service"`
+	// Status is restarted, failed, or skipped if the restart was not started since an earlier one failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*czecs.RestartResult
+}
+
+func newRestartCmd() *cobra.Command {
+	restart := &restartCmd{}
+	cmd := &cobra.Command{
+		Use:   "restart [cluster] [service...]",
+		Short: "Replace every task of services without changing their task definition",
+		Long: `This command starts a new deployment of the current task definition of each
+service, so that all of its tasks are replaced, e.g. to pick up secrets rotated
+in SSM Parameter Store or Secrets Manager. It waits for each service to become
+stable, and reports the IDs of the old and new tasks.
+
+Services are restarted in waves of --parallelism services at a time; no new
+wave is started once a restart failed. For example:
+
+czecs restart --parallelism 2 my-cluster web worker scheduler`,
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			restart.opts.Cluster = args[0]
+			var targets []*restartTarget
+			seen := map[string]bool{}
+			for _, service := range args[1:] {
+				if seen[service] {
+					return fmt.Errorf("service %#v is given more than once", service)
+				}
+				seen[service] = true
+				targets = append(targets, &restartTarget{waveTarget: waveTarget{name: service}})
+			}
+
+			sess := newSession(nil)
+			client := newClient(sess)
+			var locks []*lock.Held
+			defer func() { releaseLocks(locks...) }()
+			for _, target := range targets {
+				held, err := restart.locking.lockService(sess, client, restart.opts.Cluster, target.name)
+				if err != nil {
+					return err
+				}
+				locks = append(locks, held)
+			}
+			defer abortOnLostLocks(locks, client)()
+			err := restart.run(client, targets)
+			return writeResult(cmd, restart.newResult(targets), err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.IntVarP(&restart.opts.Timeout, "timeout", "t", 600, "Seconds to wait for service to become stable before failing. Set to 0 for unlimited wait.")
+	f.IntVar(&restart.opts.MaxFailedTasks, "max-failed-tasks", 0, "fail the restart once more than this many tasks failed to start. Set to 0 to disable.")
+	f.IntVar(&restart.parallelism, "parallelism", 1, "number of services to restart at the same time")
+	addLockFlags(cmd, &restart.locking)
+
+	return cmd
+}
+
+// waves returns how the services are restarted.
+func (r *restartCmd) waves() waves {
+	return waves{noun: "service", verb: "restart", done: "restarted", parallelism: r.parallelism}
+}
+
+func (r *restartCmd) newResult(targets []*restartTarget) *restartResult {
+	result := &restartResult{Cluster: r.opts.Cluster}
+	for _, target := range targets {
+		result.Services = append(result.Services, serviceRestartResult{
+			Service:       target.name,
+			Status:        target.status(r.waves()),
+			Error:         target.errorMessage(),
+			RestartResult: target.result,
+		})
+	}
+	return result
+}
+
+// run restarts every target service.
+func (r *restartCmd) run(client *czecs.Client, targets []*restartTarget) error {
+	// Check every service exists before restarting any
+	for _, target := range targets {
+		if _, err := client.DescribeService(r.opts.Cluster, target.name); err != nil {
+			return err
+		}
+	}
+
+	waveTargets := make([]*waveTarget, len(targets))
+	for i, target := range targets {
+		waveTargets[i] = &target.waveTarget
+	}
+	return r.waves().run(waveTargets, func(i int, progressPrefix string) error {
+		target := targets[i]
+		targetClient := *client
+		targetClient.ProgressPrefix = progressPrefix
+		opts := r.opts
+		opts.Service = target.name
+		var err error
+		if target.result, err = targetClient.Restart(opts); err == nil {
+			target.summary = fmt.Sprintf("replaced tasks %s with %s", taskList(target.result.OldTaskIDs), taskList(target.result.NewTaskIDs))
+		}
+		return err
+	})
+}
+
+// taskList formats task IDs for logging.
+func taskList(ids []string) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	return strings.Join(ids, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(newRestartCmd())
+}
@@ -3,13 +3,16 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
-	debug bool
-	quiet bool
+	debug      bool
+	quiet      bool
+	configFile string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -20,13 +23,33 @@ var rootCmd = &cobra.Command{
 
 czecs takes a task definition template and any user provided values to fill in the template,
 creates a corresponding task definition, and modifies/creates the ECS service.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		logLevel := log.InfoLevel
+		if debug { // debug overrides quiet
+			logLevel = log.DebugLevel
+		} else if quiet {
+			logLevel = log.FatalLevel
+		}
+		log.SetLevel(logLevel)
+		if err := checkOutputFormat(); err != nil {
+			return err
+		}
+		return configureFetch(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	startedAt = time.Now()
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
+		if jsonOutput() {
+			writeError(cmd, err)
+			// stdout only holds the result document
+			fmt.Fprintln(os.Stderr, err)
+		} else {
+			fmt.Println(err)
+		}
 		os.Exit(1)
 	}
 }
@@ -34,4 +57,15 @@ func Execute() {
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable verbose output")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "do not output to console; use return code to determine success/failure")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputText, "output format; json writes a single result document to stdout, and logs and progress to stderr")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "project config file (default is the nearest .czecs.yaml)")
+	addFetchFlags(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&awsFlags.profile, "profile", "", "AWS profile to use (default is $AWS_PROFILE, or the profile of --env)")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.region, "region", "", "AWS region to use (default is $AWS_REGION, or the region of --env)")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.roleArn, "role-arn", "", "ARN of an IAM role to assume for all AWS calls")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.externalID, "external-id", "", "external ID to pass when assuming --role-arn")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.mfaSerial, "mfa-serial", "", "serial number or ARN of the MFA device to use when assuming --role-arn; the token is read from stdin")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.endpointURL, "endpoint-url", "", "send all AWS requests to this URL instead of the AWS endpoints, e.g. LocalStack")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.ecsEndpoint, "ecs-endpoint", "", "send ECS requests to this URL (overrides --endpoint-url)")
+	rootCmd.PersistentFlags().StringVar(&awsFlags.s3Endpoint, "s3-endpoint", "", "send S3 requests to this URL using path-style addressing (overrides --endpoint-url)")
 }
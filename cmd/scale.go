@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/spf13/cobra"
+)
+
+type scaleCmd struct {
+	opts    czecs.ScaleOptions
+	locking lockOptions
+}
+
+func newScaleCmd() *cobra.Command {
+	scale := &scaleCmd{}
+	cmd := &cobra.Command{
+		Use:   "scale --count count [cluster] [service]",
+		Short: "Change the number of tasks of a service",
+		Long: `This command updates the desired count of a service, then waits for it to
+become stable.
+
+If the service has auto scaling (see czecs autoscaling), a count outside its
+capacity is warned about, since auto scaling brings the count back within it.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("count") {
+				return fmt.Errorf("a desired count must be provided via --count")
+			}
+			if scale.opts.Count < 0 {
+				return fmt.Errorf("the desired count cannot be negative")
+			}
+			scale.opts.Cluster, scale.opts.Service = args[0], args[1]
+			sess := newSession(nil)
+			client := newClient(sess)
+			held, err := scale.locking.lockService(sess, client, scale.opts.Cluster, scale.opts.Service)
+			if err != nil {
+				return err
+			}
+			defer releaseLocks(held)
+			defer abortOnLostLocks([]*lock.Held{held}, client)()
+			result, err := client.Scale(scale.opts)
+			return writeResult(cmd, result, err)
+		},
+	}
+
+	f := cmd.Flags()
+	f.Int64Var(&scale.opts.Count, "count", 0, "desired number of tasks of the service")
+	f.IntVarP(&scale.opts.Timeout, "timeout", "t", 600, "Seconds to wait for service to become stable before failing. Set to 0 for unlimited wait.")
+	addLockFlags(cmd, &scale.locking)
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newScaleCmd())
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/spf13/cobra"
+)
+
+// scheduleListResult is the result document of schedule list.
+type scheduleListResult struct {
+	Schedules []czecs.Schedule `json:"schedules"`
+}
+
+func newScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage tasks run on a schedule by EventBridge",
+		Long: `These commands manage scheduled tasks: EventBridge rules with a cron or rate
+schedule expression, whose target runs a task in an ECS cluster.
+
+The task is given by a task template, a RunTask input like czecs task takes.
+Its task definition, cluster, count, launch type, platform version, group,
+network configuration and overrides are used by the ECS target of the rule.`,
+	}
+	cmd.AddCommand(newScheduleCreateCmd(), newScheduleUpdateCmd(), newScheduleDeleteCmd(), newScheduleListCmd())
+	return cmd
+}
+
+// addScheduleFlags adds the flags configuring the rule and target of a schedule.
+func addScheduleFlags(cmd *cobra.Command, opts *czecs.ScheduleOptions) {
+	addValuesFlags(cmd, &opts.Values)
+	f := cmd.Flags()
+	f.StringVar(&opts.ScheduleExpression, "schedule", "", "EventBridge schedule expression, e.g. \"cron(0 12 * * ? *)\" or \"rate(1 hour)\"")
+	f.StringVar(&opts.RoleArn, "role", "", "ARN of the IAM role EventBridge runs the task with")
+	f.StringVar(&opts.Cluster, "cluster", "", "Cluster to use, overriding any provided in the task JSON.")
+	f.StringVar(&opts.TaskDefinitionArn, "task-definition-arn", "", "Task definition ARN to use, overriding any provided in the task JSON.")
+	f.StringVar(&opts.TaskDefinitionTemplate, "task-definition", "", "Task definition template to register and use, overriding any task definition provided in the task JSON.")
+	f.StringVar(&opts.Description, "description", "", "description of the EventBridge rule")
+	f.StringVar(&opts.State, "state", "", "state of the EventBridge rule, ENABLED or DISABLED")
+}
+
+func checkScheduleOptions(opts czecs.ScheduleOptions) error {
+	if opts.TaskDefinitionArn != "" && opts.TaskDefinitionTemplate != "" {
+		return fmt.Errorf("at most one of --task-definition and --task-definition-arn can be provided")
+	}
+	return nil
+}
+
+func newScheduleCreateCmd() *cobra.Command {
+	opts := czecs.ScheduleOptions{}
+	cmd := &cobra.Command{
+		Use:   "create --schedule expression --role arn [--task-definition task_definition.json] name task.json",
+		Short: "Schedule a task with a new EventBridge rule",
+		Long: `This command creates an EventBridge rule of the given name running the task
+of the task template on the given schedule. If a task definition template is
+given by --task-definition, it is registered first and the task runs it.
+
+EventBridge needs an IAM role, given by --role, allowed to run the task (and
+to pass its task role and execution role to ECS).`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkScheduleOptions(opts); err != nil {
+				return err
+			}
+			opts.Name, opts.Template = args[0], args[1]
+			result, err := newClient(newSession(nil)).CreateSchedule(opts)
+			return writeResult(cmd, result, err)
+		},
+	}
+	addScheduleFlags(cmd, &opts)
+	return cmd
+}
+
+func newScheduleUpdateCmd() *cobra.Command {
+	opts := czecs.ScheduleOptions{}
+	plan := false
+	cmd := &cobra.Command{
+		Use:   "update [--schedule expression] [--role arn] [--task-definition task_definition.json] name task.json",
+		Short: "Update a scheduled task",
+		Long: `This command updates the EventBridge rule of the given name, and its ECS
+target, to run the task of the task template. The schedule expression, role,
+description and state of the rule are kept unless given.
+
+The rendered schedule is compared with the existing one first, and nothing is
+changed if they are identical. A task definition template given by
+--task-definition is only registered if it differs from the task definition
+currently scheduled. With --plan, the changes are only printed.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkScheduleOptions(opts); err != nil {
+				return err
+			}
+			opts.Name, opts.Template = args[0], args[1]
+			client := newClient(newSession(nil))
+			if plan {
+				result, err := client.PlanSchedule(opts)
+				if err == nil {
+					printScheduleChanges(result)
+				}
+				return writeResult(cmd, result, err)
+			}
+			result, err := client.UpdateSchedule(opts)
+			return writeResult(cmd, result, err)
+		},
+	}
+	addScheduleFlags(cmd, &opts)
+	addPlanFlag(cmd, &plan)
+	return cmd
+}
+
+// printScheduleChanges prints the changes an update would make, unless the result document is written instead.
+func printScheduleChanges(result *czecs.ScheduleResult) {
+	if jsonOutput() {
+		return
+	}
+	fmt.Printf("Plan to update schedule %#v:\n", result.Name)
+	for _, change := range result.Changes {
+		fmt.Printf("       %s\n", change)
+	}
+	fmt.Printf("%d changed field(s)\n", len(result.Changes))
+}
+
+func newScheduleDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete name",
+		Short: "Delete a scheduled task",
+		Long: `This command removes the targets of the EventBridge rule of the given name,
+then deletes the rule. Task definitions are left registered.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := newClient(newSession(nil)).DeleteSchedule(args[0])
+			return writeResult(cmd, result, err)
+		},
+	}
+	return cmd
+}
+
+func newScheduleListCmd() *cobra.Command {
+	prefix := ""
+	cmd := &cobra.Command{
+		Use:   "list [--prefix prefix] [cluster]",
+		Short: "List scheduled tasks",
+		Long: `This command lists the EventBridge rules with a schedule expression and an
+ECS target, running tasks in the given cluster or in any cluster.`,
+		SilenceUsage: true,
+		Args:         cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cluster := ""
+			if len(args) > 0 {
+				cluster = args[0]
+			}
+			schedules, err := newClient(newSession(nil)).ListSchedules(prefix, cluster)
+			if err == nil && !jsonOutput() {
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(w, "NAME\tSCHEDULE\tSTATE\tCLUSTER\tTASK DEFINITION\tCOUNT\n")
+				for _, schedule := range schedules {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", schedule.Name, schedule.ScheduleExpression, schedule.State,
+						schedule.ClusterArn, schedule.TaskDefinitionArn, schedule.TaskCount)
+				}
+				w.Flush()
+			}
+			return writeResult(cmd, &scheduleListResult{Schedules: schedules}, err)
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "only list rules whose name starts with this prefix")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newScheduleCmd())
+}
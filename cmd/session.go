@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/chanzuckerberg/czecs/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// awsOptions are the global flags selecting the AWS credentials and region used by all commands
+type awsOptions struct {
+	profile    string
+	region     string
+	roleArn    string
+	externalID string
+	mfaSerial  string
+	// endpointURL replaces the endpoint of every AWS service; ecsEndpoint and s3Endpoint replace that of a single one
+	endpointURL string
+	ecsEndpoint string
+	s3Endpoint  string
+}
+
+var awsFlags awsOptions
+
+// newSession creates the AWS session shared by all clients of a command, including the S3 client used
+// to read templates and balances. The profile, region and role are taken from the global flags, falling
+// back to those of the environment (if any), and then to the usual AWS environment variables and shared config.
+func newSession(env *environmentConfig) *session.Session {
+	profile, region, roleArn := awsFlags.profile, awsFlags.region, awsFlags.roleArn
+	if env != nil {
+		if profile == "" {
+			profile = env.Profile
+		}
+		if region == "" {
+			region = env.Region
+		}
+		if roleArn == "" {
+			roleArn = env.RoleArn
+		}
+	}
+
+	options := session.Options{
+		Profile:                 profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	}
+	if region != "" {
+		options.Config.Region = aws.String(region)
+	}
+	if resolver := endpointResolver(); resolver != nil {
+		options.Config.EndpointResolver = resolver
+		// Stand-ins for S3 rarely serve bucket subdomains
+		options.Config.S3ForcePathStyle = aws.Bool(awsFlags.s3Endpoint != "" || awsFlags.endpointURL != "")
+	}
+	sess := session.Must(session.NewSessionWithOptions(options))
+
+	if roleArn != "" {
+		log.Debugf("Assuming role %#v", roleArn)
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, roleArn, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = "czecs"
+				if awsFlags.externalID != "" {
+					p.ExternalID = aws.String(awsFlags.externalID)
+				}
+				if awsFlags.mfaSerial != "" {
+					p.SerialNumber = aws.String(awsFlags.mfaSerial)
+					p.TokenProvider = stscreds.StdinTokenProvider
+				}
+			}),
+		})
+	}
+
+	tasks.SetSession(sess)
+	return sess
+}
+
+// endpointResolver returns a resolver sending the requests of AWS services to the endpoints given by the
+// global flags, e.g. to run against LocalStack, or nil if no endpoint was given.
+func endpointResolver() endpoints.Resolver {
+	if awsFlags.endpointURL == "" && awsFlags.ecsEndpoint == "" && awsFlags.s3Endpoint == "" {
+		return nil
+	}
+	overrides := map[string]string{
+		ecs.EndpointsID: awsFlags.ecsEndpoint,
+		s3.EndpointsID:  awsFlags.s3Endpoint,
+	}
+	return endpoints.ResolverFunc(func(service string, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		url := overrides[service]
+		if url == "" {
+			url = awsFlags.endpointURL
+		}
+		if url == "" {
+			return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		}
+		log.Debugf("Using endpoint %#v for %s", url, service)
+		return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+	})
+}
+
+// newClient creates the czecs client of a command using ECS, EventBridge and Application Auto Scaling clients
+// of the session, with the given config overrides. Progress is written unless quiet.
+func newClient(sess *session.Session, configs ...*aws.Config) *czecs.Client {
+	svc := ecs.New(sess, configs...)
+	client := czecs.New(svc, aws.StringValue(svc.Config.Region))
+	client.EventBridge = eventbridge.New(sess, configs...)
+	client.AutoScaling = applicationautoscaling.New(sess, configs...)
+	if log.GetLevel() >= log.InfoLevel {
+		client.Progress = progressOutput()
+	}
+	if log.GetLevel() == log.DebugLevel {
+		client.WaiterOptions = []request.WaiterOption{util.DebugSleepProgressWithContext}
+	}
+	return client
+}
@@ -2,193 +2,312 @@ package cmd
 
 import (
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
-	"github.com/chanzuckerberg/czecs/util"
+	"github.com/chanzuckerberg/czecs/lock"
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 type upgradeCmd struct {
 	installCmd
-	deregister bool
+	deregister         bool
+	suspendAutoScaling bool
+
+	clusters    []string
+	parallelism int
+}
+
+// upgradeTarget is a cluster (possibly in another region) in which the service is upgraded.
+type upgradeTarget struct {
+	waveTarget
+	cluster string
+	region  string
+	client  *czecs.Client
+
+	taskDefnArn string
+	result      *czecs.UpgradeResult
+}
+
+// upgradeResult is the result document of the upgrade command.
+type upgradeResult struct {
+	Service  string          `json:"service"`
+	Clusters []clusterResult `json:"clusters"`
+}
+
+// clusterResult is the outcome of the upgrade in one cluster.
+type clusterResult struct {
+	Cluster string `json:"cluster"`
+	Region  string `json:"region"`
+	// Status is upgraded, failed, or skipped if the upgrade was not started since an earlier one failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*czecs.UpgradeResult
+}
+
+// onNewTaskDefinition returns whether the service was left running the new task definition.
+func (t *upgradeTarget) onNewTaskDefinition() bool {
+	return t.result != nil && t.result.OnNewTaskDefinition
 }
 
 func newUpgradeCmd() *cobra.Command {
 	upgrade := &upgradeCmd{}
 	cmd := &cobra.Command{
-		Use:   "upgrade [--task-definition-arn arn] [cluster] [service] [task_definition.json]",
+		Use:   "upgrade [--task-definition-arn arn] [--cluster cluster[@region]...] [cluster] [service] [task_definition.json]",
 		Short: "Upgrade an existing service in an ECS cluster",
 		Long: `This command upgrades a service to a new version of a task definition.
 
-The task must already exist.`,
+The task must already exist.
+
+To upgrade the same service in several clusters, pass each cluster with
+--cluster instead of the cluster argument, optionally suffixed with @region for
+clusters outside the default region. The task definition is registered once per
+region. Clusters are upgraded in waves of --parallelism clusters at a time; no
+new wave is started once an upgrade in any cluster failed. For example:
+
+czecs upgrade --cluster prod-a --cluster prod-b@us-east-1 --parallelism 2 my-service czecs.json
+
+The --pre-task and --post-task tasks run in every cluster, so tasks such as
+database migrations must be safe to run more than once.
+
+With --env, the cluster, service, task definition template and other settings
+are taken from that environment of the project config file (see czecs config);
+only a task definition template may then be passed as argument.`,
 		SilenceUsage: true,
-		Args:         cobra.RangeArgs(2, 3),
+		Args:         cobra.RangeArgs(0, 3),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			logLevel := log.InfoLevel
-			if debug { // debug overrides quiet
-				logLevel = log.DebugLevel
-			} else if quiet {
-				logLevel = log.FatalLevel
+			env, err := loadEnvironment(upgrade.env)
+			if err != nil {
+				return err
+			}
+			if env != nil {
+				upgrade.applyEnvironment(cmd, env)
+				if env.Deregister != nil && !cmd.Flags().Changed("deregister") {
+					upgrade.deregister = *env.Deregister
+				}
+				leading := []string{env.Cluster, env.Service}
+				if len(upgrade.clusters) > 0 {
+					leading = leading[1:]
+				}
+				args, err = envArgs(env, args, upgrade.opts.TaskDefinitionArn, leading...)
+				if err != nil {
+					return err
+				}
 			}
-			log.SetLevel(logLevel)
 
-			if (len(args) >= 3) == (upgrade.taskDefinitionArn != "") {
+			sess := newSession(env)
+
+			var targets []*upgradeTarget
+			if len(args) < 1 {
+				return fmt.Errorf("a service must be provided")
+			}
+			if len(upgrade.clusters) == 0 {
+				if len(args) < 2 {
+					return fmt.Errorf("a cluster and service must be provided")
+				}
+				targets = append(targets, &upgradeTarget{waveTarget: waveTarget{name: args[0]}, cluster: args[0], client: newClient(sess)})
+				args = args[1:]
+			} else {
+				if len(args) > 2 {
+					return fmt.Errorf("the cluster argument cannot be combined with --cluster")
+				}
+				for _, clusterSpec := range upgrade.clusters {
+					targets = append(targets, newUpgradeTarget(sess, clusterSpec))
+				}
+			}
+			if (len(args) >= 2) == (upgrade.opts.TaskDefinitionArn != "") {
 				return fmt.Errorf("exactly one of a task definition JSON filename (czecs.json) or a task definition ARN via --task-definition-arn must be provided")
 			}
 
-			sess := session.Must(session.NewSessionWithOptions(session.Options{
-				SharedConfigState: session.SharedConfigEnable,
-			}))
-			config := sess.Config
+			if upgrade.plan {
+				result, err := upgrade.planTargets(args, targets)
+				return writeResult(cmd, result, err)
+			}
 
-			svc := ecs.New(sess)
-			return upgrade.run(args, svc, config)
+			var locks []*lock.Held
+			defer func() { releaseLocks(locks...) }()
+			for _, target := range targets {
+				held, err := upgrade.locking.lockService(sess, target.client, target.cluster, args[0])
+				if err != nil {
+					return err
+				}
+				locks = append(locks, held)
+			}
+			var clients []*czecs.Client
+			for _, target := range targets {
+				clients = append(clients, target.client)
+			}
+			defer abortOnLostLocks(locks, clients...)()
+			err = upgrade.run(args, targets)
+			return writeResult(cmd, upgrade.newResult(args[0], targets), err)
 		},
 	}
 
+	addValuesFlags(cmd, &upgrade.opts.Values)
+	addSidecarFlag(cmd, &upgrade.opts.Values)
 	f := cmd.Flags()
-	f.BoolVar(&upgrade.strict, "strict", false, "fail on lint warnings")
-	f.StringSliceVarP(&upgrade.balanceFiles, "balances", "f", []string{}, "specify values in a JSON file or an S3 URL")
-	f.StringSliceVar(&upgrade.values, "set", []string{}, "set values on the command line (can repeat or use comma-separated values)")
-	f.StringSliceVar(&upgrade.stringValues, "set-string", []string{}, "set STRING values on the command line (can repeat or use comma-separated values)")
-	f.BoolVar(&upgrade.rollback, "rollback", false, "rollback to previous version if deployment failed")
+	f.BoolVar(&upgrade.opts.Rollback, "rollback", false, "rollback to previous version if deployment failed")
 	f.BoolVar(&upgrade.deregister, "deregister", false, "remove old task definition on success (or remove new task definition on failure)")
-	f.StringVar(&upgrade.taskDefinitionArn, "task-definition-arn", "", "Use existing task definition instead of reading template file.")
-	f.IntVarP(&upgrade.timeout, "timeout", "t", 600, "Seconds to wait for service to become stable before failing. Set to 0 for unlimited wait.")
+	f.BoolVar(&upgrade.suspendAutoScaling, "suspend-autoscaling", false, "suspend the auto scaling of the service until the upgrade is done, restoring it even if the upgrade failed")
+	f.StringVar(&upgrade.opts.TaskDefinitionArn, "task-definition-arn", "", "Use existing task definition instead of reading template file.")
+	f.IntVarP(&upgrade.opts.Timeout, "timeout", "t", 600, "Seconds to wait for service to become stable before failing. Set to 0 for unlimited wait.")
+	f.BoolVar(&upgrade.opts.CircuitBreaker, "circuit-breaker", false, "enable the ECS deployment circuit breaker on the service, rolling back failed deployments")
+	f.IntVar(&upgrade.opts.MaxFailedTasks, "max-failed-tasks", 0, "fail the deployment once more than this many tasks failed to start. Set to 0 to disable.")
+	f.StringVar(&upgrade.opts.PreTask, "pre-task", "", "task JSON file to run to completion before upgrading the service; the service is not upgraded if it fails. Runs once per cluster with --cluster, so e.g. migrations must be safe to repeat")
+	f.StringVar(&upgrade.opts.PostTask, "post-task", "", "task JSON file to run to completion after the service is stable; the deployment fails (and is rolled back with --rollback) if it fails. Runs once per cluster with --cluster")
+	f.StringSliceVar(&upgrade.clusters, "cluster", []string{}, "cluster to upgrade the service in, as cluster or cluster@region (can repeat or use comma-separated values)")
+	f.IntVar(&upgrade.parallelism, "parallelism", 1, "number of clusters to upgrade at the same time when using --cluster")
+	f.StringVar(&upgrade.env, "env", "", "use the settings of this environment of the project config file (.czecs.yaml)")
+	addLockFlags(cmd, &upgrade.locking)
+	addPlanFlag(cmd, &upgrade.plan)
 
 	return cmd
 }
 
-func (u *upgradeCmd) run(args []string, svc ecsiface.ECSAPI, config *aws.Config) error {
-	cluster := args[0]
-	u.service = args[1]
-
-	describeServicesOutput, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-		Cluster:  &cluster,
-		Services: []*string{&u.service},
-	})
-	if err != nil {
-		return errors.Wrap(err, "cannot describe services")
+// newUpgradeTarget creates the target for a --cluster value of the form cluster or cluster@region.
+func newUpgradeTarget(sess *session.Session, clusterSpec string) *upgradeTarget {
+	target := &upgradeTarget{waveTarget: waveTarget{name: clusterSpec}, cluster: clusterSpec}
+	if at := strings.LastIndex(clusterSpec, "@"); at >= 0 {
+		target.cluster = clusterSpec[:at]
+		target.region = clusterSpec[at+1:]
 	}
-	if len(describeServicesOutput.Failures) != 0 {
-		for _, failure := range describeServicesOutput.Failures {
-			if *failure.Reason == "MISSING" {
-				return fmt.Errorf("Service %#v does not exist in cluster %#v. Use outside tool or czecs install to create service", u.service, cluster)
-			}
-		}
-		return fmt.Errorf("Error retrieving information about existing service %#v: %#v", u.service, describeServicesOutput.Failures)
+	config := &aws.Config{}
+	if target.region != "" {
+		config.Region = aws.String(target.region)
 	}
-	var oldTaskDefinition *string
-	for _, existingService := range describeServicesOutput.Services {
-		if *existingService.ServiceName == u.service || *existingService.ServiceArn == u.service {
-			oldTaskDefinition = existingService.TaskDefinition
-		}
-	}
-	if oldTaskDefinition == nil {
-		return fmt.Errorf("Error retrieving information about existing service %#v: no error/failure during DescribeServices but service not found in response", u.service)
-	}
-	log.Infof("Existing task definition %#v", *oldTaskDefinition)
+	target.client = newClient(sess, config)
+	return target
+}
 
-	var taskDefnArn string
-	if len(args) >= 3 {
-		taskDefnArn, err = u.registerTaskDefinition(args[2], svc)
-		if err != nil {
-			return err
-		}
-	} else {
-		// Verify task definition exists
-		_, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
-			TaskDefinition: &u.taskDefinitionArn,
+// waves returns how the clusters are upgraded.
+func (u *upgradeCmd) waves() waves {
+	return waves{noun: "cluster", verb: "upgrade", done: "upgraded", parallelism: u.parallelism}
+}
+
+func (u *upgradeCmd) newResult(service string, targets []*upgradeTarget) *upgradeResult {
+	result := &upgradeResult{Service: service}
+	for _, target := range targets {
+		result.Clusters = append(result.Clusters, clusterResult{
+			Cluster:       target.cluster,
+			Region:        target.client.Region,
+			Status:        target.status(u.waves()),
+			Error:         target.errorMessage(),
+			UpgradeResult: target.result,
 		})
-		if err != nil {
-			return errors.Wrapf(err, "cannot retrieve task definition %#v", u.taskDefinitionArn)
-		}
-		taskDefnArn = u.taskDefinitionArn
 	}
+	return result
+}
 
-	err = u.deployUpgrade(svc, cluster, taskDefnArn, config)
-	if err != nil {
-		if u.rollback {
-			log.Warnf("Rolling back service %#v to old task definition %#v", u.service, oldTaskDefinition)
-			rollbackErr := u.deployUpgrade(svc, cluster, *oldTaskDefinition, config)
-			if rollbackErr != nil {
-				// TODO(mbarrien): Report original
-				return errors.Wrap(rollbackErr, "cannot rollback")
-			}
-			log.Debugf("Deregistering new task definition %#v", taskDefnArn)
-			_, deregisterErr := svc.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
-				TaskDefinition: &taskDefnArn,
-			})
-			if deregisterErr != nil {
-				log.Warnf("Error deregistering task definition after rollback: %#v", err.Error())
-				log.Warnf("You will have to manually deregister the new task. Using AWS CLI you can run 'aws ecs deregister-task-definition --task-definition %s'", taskDefnArn)
-				// Intentionally swallow error; let the original error bubble up
-			}
-		}
-		return err
+// planTargets returns the plans of upgrading the service in every cluster.
+func (u *upgradeCmd) planTargets(args []string, targets []*upgradeTarget) (*planResult, error) {
+	opts := czecs.UpgradeOptions{InstallOptions: u.opts, Deregister: u.deregister, SuspendAutoScaling: u.suspendAutoScaling}
+	opts.Service = args[0]
+	if len(args) >= 2 {
+		opts.Template = args[1]
 	}
-
-	if u.deregister && oldTaskDefinition != nil {
-		log.Debugf("Deregistering old task definition %#v", *oldTaskDefinition)
-		_, err := svc.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
-			TaskDefinition: oldTaskDefinition,
-		})
+	result := &planResult{}
+	for _, target := range targets {
+		opts.Cluster = target.cluster
+		plan, err := target.client.PlanUpgrade(opts)
 		if err != nil {
-			log.Warnf("Error deregistering task definition: %#v", err.Error())
-			log.Warnf("You will have to manually deregister the old task. Using AWS CLI you can run 'aws ecs deregister-task-definition --task-definition %s'", *oldTaskDefinition)
-			// Intentionally swallow error; this isn't fatal
+			if len(targets) > 1 {
+				return nil, errors.Wrapf(err, "cluster %s", target.name)
+			}
+			return nil, err
 		}
+		result.Plans = append(result.Plans, plan)
 	}
-	return nil
+	printPlans(result.Plans...)
+	return result, nil
 }
 
-func (u *upgradeCmd) deployUpgrade(svc ecsiface.ECSAPI, cluster string, taskDefnArn string, config *aws.Config) error {
-	log.Infof("Updating service %#v in cluster %#v to task definition %#v", u.service, cluster, taskDefnArn)
-	log.Infof("Service info location: https://%s.console.aws.amazon.com/ecs/home?region=%s#/clusters/%s/services/%s/details", *config.Region, *config.Region, cluster, u.service)
+// run upgrades the service given in args[0] in every target, to the task definition template
+// in args[1] (or --task-definition-arn).
+func (u *upgradeCmd) run(args []string, targets []*upgradeTarget) error {
+	opts := czecs.UpgradeOptions{InstallOptions: u.opts, Deregister: u.deregister, SuspendAutoScaling: u.suspendAutoScaling}
+	opts.Service = args[0]
 
-	// Get the primary deployment's updated date, default to now if missing
-	updatedAt := time.Now()
-	updateServiceOutput, err := svc.UpdateService(&ecs.UpdateServiceInput{
-		Cluster:        &cluster,
-		Service:        &u.service,
-		TaskDefinition: &taskDefnArn,
-	})
-	if err != nil {
-		// TODO(mbarrien) Avoid rollback?
-		return err
+	// Check the service exists everywhere before changing anything
+	for _, target := range targets {
+		if _, err := target.client.DescribeService(target.cluster, opts.Service); err != nil {
+			if len(targets) > 1 {
+				return errors.Wrapf(err, "cluster %s", target.name)
+			}
+			return err
+		}
+	}
+
+	// Register the task definition once per region
+	var template string
+	if len(args) >= 2 {
+		template = args[1]
 	}
-	for _, deployment := range updateServiceOutput.Service.Deployments {
-		if *deployment.Status == "PRIMARY" {
-			updatedAt = *deployment.UpdatedAt
-			break
+	taskDefnArns := map[string]string{}
+	for _, target := range targets {
+		region := target.client.Region
+		taskDefnArn, ok := taskDefnArns[region]
+		if !ok {
+			var err error
+			taskDefnArn, err = target.client.NewTaskDefinition(template, opts.Values, opts.TaskDefinitionArn)
+			if err != nil {
+				// Nothing was deployed yet, so the task definitions registered in other regions are unused
+				if template != "" {
+					for _, registered := range targets {
+						if arn, ok := taskDefnArns[registered.client.Region]; ok {
+							registered.client.DeregisterTaskDefinition(arn, "new")
+							delete(taskDefnArns, registered.client.Region)
+						}
+					}
+				}
+				if len(targets) > 1 {
+					return errors.Wrapf(err, "cluster %s", target.name)
+				}
+				return err
+			}
+			taskDefnArns[region] = taskDefnArn
 		}
+		target.taskDefnArn = taskDefnArn
 	}
 
-	// Intentionally using printf directly, since we want this to be on the same line as the
-	// progress dots.
-	if log.GetLevel() >= log.InfoLevel {
-		fmt.Printf("Waiting for service %#v in cluster %#v to task definition %#v to be stable", u.service, cluster, taskDefnArn)
+	waveTargets := make([]*waveTarget, len(targets))
+	for i, target := range targets {
+		waveTargets[i] = &target.waveTarget
 	}
+	err := u.waves().run(waveTargets, func(i int, progressPrefix string) error {
+		target := targets[i]
+		client := *target.client
+		client.ProgressPrefix = progressPrefix
+		targetOpts := opts
+		targetOpts.Cluster = target.cluster
+		targetOpts.Template = ""
+		targetOpts.TaskDefinitionArn = target.taskDefnArn
+		var err error
+		target.result, err = client.Upgrade(targetOpts)
+		target.summary = fmt.Sprintf("upgraded to task definition %#v", target.taskDefnArn)
+		return err
+	})
 
-	opts := append(util.WaiterDelay(u.timeout, 15), util.GetFailOnAbortContext(updatedAt))
-	if log.GetLevel() >= log.InfoLevel {
-		opts = append(opts, util.SleepProgressWithContext)
-	} else if log.GetLevel() == log.DebugLevel {
-		opts = append(opts, util.DebugSleepProgressWithContext)
+	// Only remove a new task definition once no cluster in its region uses it any more
+	if template != "" && (u.deregister || u.opts.Rollback) {
+		for region, taskDefnArn := range taskDefnArns {
+			var regionClient *czecs.Client
+			inUse := false
+			for _, target := range targets {
+				if target.client.Region == region {
+					regionClient = target.client
+					inUse = inUse || target.onNewTaskDefinition()
+				}
+			}
+			if !inUse {
+				regionClient.DeregisterTaskDefinition(taskDefnArn, "new")
+			}
+		}
 	}
 
-	return svc.WaitUntilServicesStableWithContext(
-		aws.BackgroundContext(),
-		&ecs.DescribeServicesInput{
-			Cluster:  &cluster,
-			Services: []*string{updateServiceOutput.Service.ServiceArn}},
-		opts...)
+	return err
 }
 
 func init() {
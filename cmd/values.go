@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/chanzuckerberg/czecs/pkg/czecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type valuesCmd struct {
+	values czecs.Values
+}
+
+func newValuesCmd() *cobra.Command {
+	valuesCommand := &valuesCmd{}
+	cmd := &cobra.Command{
+		Use:   "values",
+		Short: "Inspect, encrypt and decrypt the values filling in templates",
+	}
+
+	explainCmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Explain where each of the merged values came from",
+		Long: `This command merges the values given by --balances, --set and --set-string
+like every other command does, then prints each value with its final value
+and the sources that set it, in increasing precedence; the last source
+provided the final value. For example:
+
+czecs values explain -f balances.json -f balances.prod.json --set image.tag=v2
+
+Maps are merged key by key; any other value replaces the previous one. Values
+whose type was changed by a later source, e.g. a map replaced by a string, are
+replaced entirely and listed at the end; other commands warn about them.`,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Sets the session used to read values from S3
+			newSession(nil)
+			explanation, err := valuesCommand.explain()
+			return writeResult(cmd, explanation, err)
+		},
+	}
+	addValuesFlags(explainCmd, &valuesCommand.values)
+	cmd.AddCommand(explainCmd)
+	cmd.AddCommand(newValuesEncryptCmd(), newValuesDecryptCmd(), newValuesEditCmd())
+	return cmd
+}
+
+// sopsSupport describes the subset of the sops format czecs supports, for the help of the commands reading or
+// writing encrypted balances files.
+const sopsSupport = `czecs supports the subset of the sops format it needs, rather than embedding
+sops: JSON and YAML files whose data key is encrypted with AWS KMS keys or to
+age recipients, including the encrypted and unencrypted suffixes and regexes
+and mac_only_encrypted. Files with key groups (key_groups and
+shamir_threshold) are rejected. PGP, GCP KMS, Azure Key Vault and Vault keys
+cannot decrypt files, but are kept when a file is edited. INI, dotenv and
+binary files are not supported, and YAML files with comments cannot be
+encrypted or edited, since czecs would lose the comments. Use sops itself for
+those files.`
+
+// encryptionResult is the result document of values encrypt, decrypt and edit.
+type encryptionResult struct {
+	File string `json:"file"`
+	// Format is the encrypted format of the file, sops or envelope
+	Format string `json:"format"`
+	// Content is the encrypted or decrypted file, unless it was written in place or edited
+	Content string `json:"content,omitempty"`
+}
+
+type valuesEncryptCmd struct {
+	opts     tasks.EncryptOptions
+	envelope bool
+	inPlace  bool
+}
+
+func newValuesEncryptCmd() *cobra.Command {
+	encrypt := &valuesEncryptCmd{}
+	cmd := &cobra.Command{
+		Use:   "encrypt [--kms key]... [--age recipient]... [--envelope] [-i] balances.json",
+		Short: "Encrypt a balances file",
+		Long: `This command encrypts a JSON or YAML balances file, so that it can be committed
+without its secrets in plaintext. Every command reading balances files decrypts
+encrypted ones in memory, as long as it can decrypt their data key.
+
+By default the file is encrypted in the sops format, compatible with the sops
+tool: each value is encrypted on its own, keeping the keys readable, with a data
+key encrypted with each AWS KMS key given by --kms and to each age recipient
+given by --age. Values whose key, or the key of a map they are in, ends with
+--unencrypted-suffix are left in plaintext. For example:
+
+czecs values encrypt --kms arn:aws:kms:us-west-2:123456789012:alias/balances -i balances.prod.json
+
+With --envelope, the whole file is encrypted with a data key generated by the
+single KMS key given by --kms, in the czecs envelope format.
+
+KMS keys given by ARN are used in their own region, other keys in --region. age
+keys to decrypt files with are read like sops does, from $SOPS_AGE_KEY, the file
+named by $SOPS_AGE_KEY_FILE, or sops/age/keys.txt in the user config directory.
+
+` + sopsSupport,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newSession(nil)
+			result, err := encrypt.run(args[0])
+			return writeResult(cmd, result, err)
+		},
+	}
+	f := cmd.Flags()
+	f.StringArrayVar(&encrypt.opts.KMSKeys, "kms", []string{}, "AWS KMS key to encrypt the data key with, by ARN, ID or alias (can specify multiple)")
+	f.StringArrayVar(&encrypt.opts.AgeRecipients, "age", []string{}, "age public key to encrypt the data key to (can specify multiple)")
+	f.BoolVar(&encrypt.envelope, "envelope", false, "encrypt the whole file in the czecs envelope format instead of the sops format")
+	f.StringVar(&encrypt.opts.UnencryptedSuffix, "unencrypted-suffix", "_unencrypted", "leave values whose key ends with this suffix in plaintext")
+	f.BoolVarP(&encrypt.inPlace, "in-place", "i", false, "write the encrypted file in place instead of printing it")
+	return cmd
+}
+
+func (e *valuesEncryptCmd) run(file string) (*encryptionResult, error) {
+	plaintext, err := readBalancesFile(file, e.inPlace)
+	if err != nil {
+		return nil, err
+	}
+	if e.envelope {
+		e.opts.Format = tasks.FormatEnvelope
+	}
+	encrypted, err := tasks.EncryptBalances(plaintext, e.opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot encrypt %v", file)
+	}
+	return writeBalancesFile(file, encrypted, tasks.EncryptedFormat(encrypted), e.inPlace)
+}
+
+func newValuesDecryptCmd() *cobra.Command {
+	inPlace := false
+	cmd := &cobra.Command{
+		Use:   "decrypt [-i] balances.json",
+		Short: "Decrypt a balances file",
+		Long: `This command decrypts a balances file encrypted by czecs values encrypt or by
+sops, printing it in the format it was encrypted from. See czecs values encrypt
+--help for the keys used.
+
+` + sopsSupport,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newSession(nil)
+			result, err := decryptBalancesFile(args[0], inPlace)
+			return writeResult(cmd, result, err)
+		},
+	}
+	cmd.Flags().BoolVarP(&inPlace, "in-place", "i", false, "write the decrypted file in place instead of printing it")
+	return cmd
+}
+
+func decryptBalancesFile(file string, inPlace bool) (*encryptionResult, error) {
+	encrypted, err := readBalancesFile(file, inPlace)
+	if err != nil {
+		return nil, err
+	}
+	format := tasks.EncryptedFormat(encrypted)
+	plaintext, err := tasks.DecryptBalances(encrypted)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decrypt %v", file)
+	}
+	return writeBalancesFile(file, plaintext, format, inPlace)
+}
+
+func newValuesEditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit balances.json",
+		Short: "Edit an encrypted balances file",
+		Long: `This command decrypts a balances file into a temporary file, opens it in $EDITOR
+(vi by default), then encrypts the edited file again for the same keys. The file
+is left unchanged if nothing was edited. See czecs values encrypt --help for the
+keys used.
+
+` + sopsSupport,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newSession(nil)
+			result, err := editBalancesFile(args[0])
+			return writeResult(cmd, result, err)
+		},
+	}
+	return cmd
+}
+
+func editBalancesFile(file string) (*encryptionResult, error) {
+	encrypted, err := readBalancesFile(file, true)
+	if err != nil {
+		return nil, err
+	}
+	result := &encryptionResult{File: file, Format: tasks.EncryptedFormat(encrypted)}
+	plaintext, err := tasks.DecryptBalances(encrypted)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decrypt %v", file)
+	}
+
+	// The plaintext is only written to a file readable by the user, removed once edited
+	tmp, err := ioutil.TempFile("", "czecs-*"+filepath.Ext(file))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(plaintext)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	edit := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	edit.Stdin, edit.Stdout, edit.Stderr = os.Stdin, progressOutput(), os.Stderr
+	if err := edit.Run(); err != nil {
+		return nil, errors.Wrapf(err, "editor %v failed", strings.Join(editor, " "))
+	}
+	edited, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(edited, plaintext) {
+		log.Infof("%v is unchanged", file)
+		return result, nil
+	}
+	if _, err := yaml.YAMLToJSON(edited); err != nil {
+		return nil, errors.Wrapf(err, "edited %v is not valid JSON or YAML; it is left unchanged", file)
+	}
+	reencrypted, err := tasks.ReencryptBalances(encrypted, edited)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot encrypt %v", file)
+	}
+	return writeBalancesFile(file, reencrypted, result.Format, true)
+}
+
+// readBalancesFile reads a balances file to encrypt or decrypt, which must be local to be written in place.
+func readBalancesFile(file string, inPlace bool) ([]byte, error) {
+	if inPlace && tasks.IsURI(file) {
+		return nil, fmt.Errorf("%v is not a local file; it cannot be written in place", file)
+	}
+	content, err := tasks.ReadFileOrURI(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %v", file)
+	}
+	return content, nil
+}
+
+// writeBalancesFile writes the encrypted or decrypted content in place, keeping the mode of the file, or
+// prints it, unless stdout is reserved for the result document.
+func writeBalancesFile(file string, content []byte, format string, inPlace bool) (*encryptionResult, error) {
+	result := &encryptionResult{File: file, Format: format}
+	if !inPlace {
+		if jsonOutput() {
+			result.Content = string(content)
+		} else {
+			fmt.Print(string(content))
+		}
+		return result, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, content, info.Mode().Perm()); err != nil {
+		return nil, errors.Wrapf(err, "cannot write %v", file)
+	}
+	log.Infof("Wrote %v", file)
+	return result, nil
+}
+
+func (v *valuesCmd) explain() (*czecs.Explanation, error) {
+	explanation, err := v.values.Explain()
+	if err != nil {
+		return nil, err
+	}
+	if jsonOutput() {
+		return explanation, nil
+	}
+	for _, value := range explanation.Explained {
+		formatted, err := json.Marshal(value.Value)
+		if err != nil {
+			formatted = []byte(fmt.Sprintf("%#v", value.Value))
+		}
+		fmt.Printf("%s = %s\n", value.Path, formatted)
+		for _, source := range value.Sources {
+			fmt.Printf("    %s\n", source)
+		}
+	}
+	if len(explanation.TypeChanges) > 0 {
+		fmt.Printf("\nType changes:\n")
+		for _, change := range explanation.TypeChanges {
+			fmt.Printf("    %s\n", change)
+		}
+	}
+	return explanation, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newValuesCmd())
+}
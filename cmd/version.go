@@ -21,17 +21,19 @@ func newVersionCmd() *cobra.Command {
 		SilenceUsage: true,
 		Args:         cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return version.run()
+			return version.run(cmd)
 		},
 	}
 	return cmd
 }
 
-func (v *versionCmd) run() error {
+func (v *versionCmd) run(cmd *cobra.Command) error {
 	ver, err := util.VersionString()
 	if err != nil {
-		return err
+		return writeResult(cmd, nil, err)
 	}
-	fmt.Println(ver)
-	return nil
+	if !jsonOutput() {
+		fmt.Println(ver)
+	}
+	return writeResult(cmd, map[string]string{"version": ver}, nil)
 }
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// waveTarget is one target of an action run in waves, e.g. a cluster in which a service is upgraded.
+type waveTarget struct {
+	// name identifies the target in logs and progress output
+	name    string
+	started bool
+	err     error
+	// summary describes the outcome of the action in the log once it succeeded
+	summary string
+}
+
+// waves runs an action on several targets, --parallelism targets at a time; no new wave is started once
+// the action failed on any target.
+type waves struct {
+	// noun is what a target is, e.g. "cluster"
+	noun string
+	// verb is the action, e.g. "upgrade", and done its past participle, e.g. "upgraded"
+	verb        string
+	done        string
+	parallelism int
+}
+
+// run runs action on every target, giving it the index of the target and the prefix of its progress output,
+// which is "" unless other targets run at the same time, then logs the outcome of each target.
+func (w waves) run(targets []*waveTarget, action func(i int, progressPrefix string) error) error {
+	parallelism := w.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	failed := 0
+	for wave := 0; wave < len(targets) && failed == 0; wave += parallelism {
+		end := wave + parallelism
+		if end > len(targets) {
+			end = len(targets)
+		}
+		var wg sync.WaitGroup
+		for i := wave; i < end; i++ {
+			target := targets[i]
+			target.started = true
+			progressPrefix := ""
+			if end-wave > 1 {
+				progressPrefix = fmt.Sprintf("[%s] ", target.name)
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				target.err = action(i, progressPrefix)
+			}(i)
+		}
+		wg.Wait()
+		for _, target := range targets[wave:end] {
+			if target.err != nil {
+				failed++
+			}
+		}
+	}
+
+	noun := strings.ToUpper(w.noun[:1]) + w.noun[1:]
+	for _, target := range targets {
+		switch {
+		case target.err != nil && len(targets) == 1:
+			// Returned as is
+		case target.err != nil:
+			log.Errorf("%s %s: failed: %s", noun, target.name, target.err)
+		case target.started:
+			log.Infof("%s %s: %s", noun, target.name, target.summary)
+		default:
+			log.Warnf("%s %s: not %s, since an earlier %s failed", noun, target.name, w.done, w.verb)
+		}
+	}
+	if len(targets) == 1 {
+		return targets[0].err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s failed for %d of %d %ss", w.verb, failed, len(targets), w.noun)
+	}
+	return nil
+}
+
+// status returns the status of the target in result documents: failed, the done word of its waves, or
+// skipped if the action was not started since an earlier one failed.
+func (t *waveTarget) status(w waves) string {
+	switch {
+	case t.err != nil:
+		return "failed"
+	case t.started:
+		return w.done
+	default:
+		return "skipped"
+	}
+}
+
+// errorMessage returns the error of the target in result documents, or "" if none.
+func (t *waveTarget) errorMessage() string {
+	if t.err == nil {
+		return ""
+	}
+	return t.err.Error()
+}
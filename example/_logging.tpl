@@ -0,0 +1,10 @@
+{{- define "logConfiguration" -}}
+"logConfiguration": {
+  "logDriver": "awslogs",
+  "options": {
+    "awslogs-group": "{{ .Values.logs_group }}",
+    "awslogs-region": "{{ .Values.region }}",
+    "awslogs-stream-prefix": "{{ .Values.project }}-{{ .Values.env }}-{{ .Values.name }}"
+  }
+}
+{{- end -}}
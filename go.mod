@@ -1,13 +1,15 @@
 module github.com/chanzuckerberg/czecs
 
 require (
-	github.com/aws/aws-sdk-go v1.23.8
+	filippo.io/age v1.0.0
+	github.com/aws/aws-sdk-go v1.38.0
 	github.com/cloudflare/cfssl v0.0.0-20181213083726-b94e044bb51e
-	github.com/ghodss/yaml v1.0.0 // indirect
+	github.com/ghodss/yaml v1.0.0
 	github.com/imdario/mergo v0.3.6
-	github.com/pkg/errors v0.8.1
+	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
-	golang.org/x/net v0.0.0-20181213202711-891ebc4b82d6 // indirect
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
+	gopkg.in/yaml.v2 v2.2.8
 	k8s.io/helm v2.12.0+incompatible
 )
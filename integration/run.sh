@@ -0,0 +1,70 @@
+#!/usr/bin/env bash
+# Runs czecs end to end against a local stand-in for ECS, KMS and S3; see README.md.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+ADDR=${STANDIN_ADDR:-127.0.0.1:4599}
+BIN=$(mktemp -d)
+trap 'kill $STANDIN_PID 2>/dev/null || true; rm -rf "$BIN"' EXIT
+
+go build -o "$BIN/czecs" ..
+go build -o "$BIN/standin" ./standin
+"$BIN/standin" -addr "$ADDR" -clusters integration -s3-dir testdata/s3 -behaviors testdata/behaviors.json &
+STANDIN_PID=$!
+for _ in $(seq 50); do
+  curl -s -o /dev/null "http://$ADDR/" && break
+  sleep 0.1
+done
+
+export AWS_ACCESS_KEY_ID=integration AWS_SECRET_ACCESS_KEY=integration AWS_REGION=us-west-2
+export AWS_CONFIG_FILE=/dev/null AWS_SHARED_CREDENTIALS_FILE=/dev/null
+BALANCES=s3://integration/balances.json
+FAILED=0
+
+# check DESCRIPTION EXPECTED_STATUS PATTERN ARGS... runs czecs with --output json, and checks its exit
+# status and that the result document matches the pattern.
+check() {
+  local description=$1 expected=$2 pattern=$3
+  shift 3
+  local status=0 output
+  output=$("$BIN/czecs" --endpoint-url "http://$ADDR" --output json "$@" 2>"$BIN/stderr") || status=$?
+  if [ "$status" -ne "$expected" ] || ! grep -q -- "$pattern" <<<"$output"; then
+    echo "FAIL: $description (exit status $status, expected $expected)"
+    echo "$output"
+    cat "$BIN/stderr"
+    FAILED=1
+    return
+  fi
+  echo "ok: $description"
+}
+
+check "values are read from S3" 0 '"v1"' \
+  values explain -f "$BALANCES"
+"$BIN/czecs" --endpoint-url "http://$ADDR" values encrypt --kms alias/integration \
+  testdata/s3/integration/balances.json >"$BIN/balances.sops.json"
+check "sops values are decrypted with KMS" 0 '"v1"' \
+  values explain -f "$BIN/balances.sops.json"
+"$BIN/czecs" --endpoint-url "http://$ADDR" values encrypt --envelope --kms alias/integration \
+  testdata/s3/integration/balances.json >"$BIN/balances.envelope.json"
+check "envelope values are decrypted with KMS" 0 '"v1"' \
+  values explain -f "$BIN/balances.envelope.json"
+check "install creates the service" 0 'task-definition/integration-web:1' \
+  install --name web -f "$BALANCES" integration testdata/czecs.json
+check "unplaceable tasks fail the upgrade without the circuit breaker" 1 'unable to place a task' \
+  upgrade --rollback --timeout 60 -f "$BALANCES" --set tag=v2 integration web testdata/czecs.json
+check "failed upgrade is rolled back" 1 '"rolledBack": true' \
+  upgrade --rollback --circuit-breaker -f "$BALANCES" --set tag=v2 integration web testdata/czecs.json
+check "apply upgrades the existing service" 0 '"action": "upgrade"' \
+  apply --deregister --lock tags -f "$BALANCES" --set tag=v3 integration web testdata/czecs.json
+check "scale waits for the new count" 0 '"runningCount": 3' \
+  scale --count 3 integration web
+check "restart replaces the tasks" 0 '"status": "restarted"' \
+  restart --lock tags integration web
+check "lock is released" 0 '"locked": false' \
+  lock status --lock tags integration web
+check "register creates a task definition" 0 'task-definition/integration-migrate:1' \
+  register -f "$BALANCES" testdata/migrate.json
+check "task fails with the exit code of its container" 1 '"exitCode": 3' \
+  task -f "$BALANCES" testdata/migrate-task.json
+
+exit $FAILED
@@ -0,0 +1,100 @@
+// Command standin serves a local stand-in for the ECS, KMS and S3 APIs, for running czecs end to end without
+// AWS. ECS requests are answered by an in-memory ECS (see pkg/ecsfake), KMS requests by an in-memory KMS with
+// local keys (see pkg/kmsfake), and S3 GetObject requests using path-style addressing are served from the
+// files of a directory, with buckets as its subdirectories.
+//
+// Usage:
+//
+//	standin -addr 127.0.0.1:4599 -clusters integration -s3-dir testdata/s3 -behaviors testdata/behaviors.json
+//	czecs --endpoint-url http://127.0.0.1:4599 ...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+	"github.com/chanzuckerberg/czecs/pkg/kmsfake"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:4599", "address to listen on")
+	clusters := flag.String("clusters", "integration", "comma separated names of the ECS clusters to create")
+	s3Dir := flag.String("s3-dir", ".", "directory holding a subdirectory of objects for each S3 bucket")
+	behaviors := flag.String("behaviors", "", "JSON file of ecsfake.Behavior by task definition family or family:revision")
+	kmsKeys := flag.String("kms-keys", "alias/integration", "comma separated IDs or aliases of the KMS keys to create")
+	debug := flag.Bool("debug", false, "log every request")
+	flag.Parse()
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	fake := ecsfake.New(strings.Split(*clusters, ",")...)
+	if *behaviors != "" {
+		if err := behave(fake, *behaviors); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	kms := kmsfake.New(strings.Split(*kmsKeys, ",")...)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		log.Debugf("%s %s %s", r.Method, r.URL.Path, target)
+		switch {
+		case strings.HasPrefix(target, "TrentService."):
+			kms.ServeHTTP(w, r)
+		case target != "":
+			fake.ServeHTTP(w, r)
+		default:
+			serveObject(w, r, *s3Dir)
+		}
+	})
+	log.Infof("Serving ECS, KMS and S3 on http://%s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, handler))
+}
+
+// behave configures the fake with the behaviors of the given file.
+func behave(fake *ecsfake.ECS, filename string) error {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var behaviors map[string]ecsfake.Behavior
+	if err := json.Unmarshal(raw, &behaviors); err != nil {
+		return fmt.Errorf("cannot parse behaviors %s: %v", filename, err)
+	}
+	for taskDefinition, behavior := range behaviors {
+		fake.Behave(taskDefinition, behavior)
+	}
+	return nil
+}
+
+// serveObject answers a path-style S3 GetObject request, /bucket/key, from the files of dir.
+func serveObject(w http.ResponseWriter, r *http.Request, dir string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "only GetObject is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	filename := filepath.Join(dir, filepath.FromSlash(path.Clean(r.URL.Path)))
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>", r.URL.Path)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(content)
+}
@@ -0,0 +1,149 @@
+package lock
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// DynamoDBLocker stores locks as items of a DynamoDB table, using conditional writes so that only one
+// holder can take a lock. The table must have a string partition key named LockID. Expired items are
+// ignored; optionally enable DynamoDB TTL on the ExpiresAt attribute to clean them up.
+type DynamoDBLocker struct {
+	svc   dynamodbiface.DynamoDBAPI
+	table string
+	// Now returns the current time; can be replaced to control lock expiry.
+	Now func() time.Time
+}
+
+// NewDynamoDBLocker creates a lock backend using the given DynamoDB table.
+func NewDynamoDBLocker(svc dynamodbiface.DynamoDBAPI, table string) *DynamoDBLocker {
+	return &DynamoDBLocker{
+		svc:   svc,
+		table: table,
+		Now:   time.Now,
+	}
+}
+
+// Acquire implements Locker.
+func (d *DynamoDBLocker) Acquire(key string, holder string, ttl time.Duration) error {
+	now := d.Now()
+	expiresAt := unixAttribute(now.Add(ttl))
+
+	// Take the lock if it is free or expired
+	_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: &d.table,
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockID":     {S: &key},
+			"Holder":     {S: &holder},
+			"AcquiredAt": unixAttribute(now),
+			"ExpiresAt":  expiresAt,
+		},
+		ConditionExpression:       aws.String("attribute_not_exists(LockID) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":now": unixAttribute(now)},
+	})
+	if err == nil {
+		return nil
+	}
+	if !isConditionalCheckFailed(err) {
+		return errors.Wrap(err, "cannot write lock")
+	}
+
+	// Otherwise extend it if we already hold it
+	_, err = d.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           &d.table,
+		Key:                 map[string]*dynamodb.AttributeValue{"LockID": {S: &key}},
+		UpdateExpression:    aws.String("SET ExpiresAt = :expires"),
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":expires": expiresAt,
+			":holder":  {S: &holder},
+		},
+	})
+	if err == nil {
+		return nil
+	}
+	if !isConditionalCheckFailed(err) {
+		return errors.Wrap(err, "cannot extend lock")
+	}
+
+	current, err := d.Status(key)
+	if err != nil {
+		return err
+	}
+	return &LockedError{Lock: current}
+}
+
+// Release implements Locker.
+func (d *DynamoDBLocker) Release(key string, holder string) error {
+	_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                 &d.table,
+		Key:                       map[string]*dynamodb.AttributeValue{"LockID": {S: &key}},
+		ConditionExpression:       aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":holder": {S: &holder}},
+	})
+	if isConditionalCheckFailed(err) {
+		// Someone else took over the lock after ours expired; nothing to release
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "cannot delete lock")
+	}
+	return nil
+}
+
+// Status implements Locker.
+func (d *DynamoDBLocker) Status(key string) (*Lock, error) {
+	output, err := d.svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      &d.table,
+		Key:            map[string]*dynamodb.AttributeValue{"LockID": {S: &key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read lock")
+	}
+	if output.Item == nil {
+		return nil, nil
+	}
+	lock := &Lock{
+		Key:        key,
+		Holder:     aws.StringValue(output.Item["Holder"].S),
+		AcquiredAt: timeAttribute(output.Item["AcquiredAt"]),
+		ExpiresAt:  timeAttribute(output.Item["ExpiresAt"]),
+	}
+	if !d.Now().Before(lock.ExpiresAt) {
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// ForceUnlock implements Locker.
+func (d *DynamoDBLocker) ForceUnlock(key string) error {
+	_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: &d.table,
+		Key:       map[string]*dynamodb.AttributeValue{"LockID": {S: &key}},
+	})
+	return errors.Wrap(err, "cannot delete lock")
+}
+
+func isConditionalCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func unixAttribute(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
+
+func timeAttribute(value *dynamodb.AttributeValue) time.Time {
+	if value == nil || value.N == nil {
+		return time.Time{}
+	}
+	seconds, _ := strconv.ParseInt(*value.N, 10, 64)
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,209 @@
+// Package lock implements deployment locks, which prevent concurrent czecs runs from changing the
+// same service at the same time.
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Lock describes a held deployment lock.
+type Lock struct {
+	Key        string    `json:"key"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (l *Lock) String() string {
+	return fmt.Sprintf("%s held by %s since %s (expires %s)", l.Key, l.Holder, l.AcquiredAt.Format(time.RFC3339), l.ExpiresAt.Format(time.RFC3339))
+}
+
+// Locker is a backend storing deployment locks. Locks expire after their TTL, so a crashed holder
+// cannot block deployments forever.
+type Locker interface {
+	// Acquire takes the lock for key if it is free or expired, or extends it if already held by holder.
+	// If the lock is held by someone else, a *LockedError is returned.
+	Acquire(key string, holder string, ttl time.Duration) error
+	// Release frees the lock for key, if it is held by holder.
+	Release(key string, holder string) error
+	// Status returns the current lock for key, or nil if it is not held (or expired).
+	Status(key string) (*Lock, error)
+	// ForceUnlock frees the lock for key regardless of who holds it.
+	ForceUnlock(key string) error
+}
+
+// LockedError is returned when acquiring a lock held by someone else.
+type LockedError struct {
+	Lock *Lock
+}
+
+func (e *LockedError) Error() string {
+	if e.Lock == nil {
+		return "lock is held by someone else"
+	}
+	return fmt.Sprintf("lock %s", e.Lock)
+}
+
+// DefaultHolder identifies this process as user@host:pid, or by $CZECS_LOCK_HOLDER if set (e.g. to a CI job URL).
+func DefaultHolder() string {
+	if holder := os.Getenv("CZECS_LOCK_HOLDER"); holder != "" {
+		return holder
+	}
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s:%d", username, hostname, os.Getpid())
+}
+
+// Held is an acquired lock, renewed in the background until released or lost.
+type Held struct {
+	locker Locker
+	key    string
+	holder string
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	// lost is closed once the lock is lost, after setting err
+	lost chan struct{}
+	err  error
+}
+
+// Acquire takes the lock for key, retrying every few seconds until timeout (0 means fail immediately
+// if the lock is held). Once acquired, the lock is renewed every third of its TTL until released.
+func Acquire(locker Locker, key string, holder string, ttl time.Duration, timeout time.Duration) (*Held, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("cannot acquire deployment lock %s: the TTL must be positive, not %s", key, ttl)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := locker.Acquire(key, holder, ttl)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(*LockedError); !ok || !time.Now().Before(deadline) {
+			return nil, errors.Wrapf(err, "cannot acquire deployment lock %s", key)
+		}
+		log.Infof("Waiting for deployment lock: %s", err)
+		time.Sleep(minDuration(5*time.Second, time.Until(deadline)))
+	}
+	log.Debugf("Acquired deployment lock %s as %s", key, holder)
+
+	held := &Held{
+		locker: locker,
+		key:    key,
+		holder: holder,
+		stop:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	held.wg.Add(1)
+	go held.renew(ttl)
+	return held, nil
+}
+
+// renew extends the lock every third of its TTL. The lock is lost once someone else takes it, or once it
+// expired because it could not be renewed in time.
+func (h *Held) renew(ttl time.Duration) {
+	defer h.wg.Done()
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			err := h.locker.Acquire(h.key, h.holder, ttl)
+			if err == nil {
+				renewedAt = time.Now()
+				continue
+			}
+			if _, ok := err.(*LockedError); ok {
+				h.lose(err)
+				return
+			}
+			if time.Since(renewedAt) >= ttl {
+				h.lose(errors.Wrap(err, "lock expired"))
+				return
+			}
+			log.Warnf("Cannot renew deployment lock %s: %s", h.key, err)
+		}
+	}
+}
+
+func (h *Held) lose(err error) {
+	h.err = errors.Wrapf(err, "lost deployment lock %s", h.key)
+	log.Errorf("%s; aborting, since someone else may now deploy the service", h.err)
+	close(h.lost)
+}
+
+// Lost returns a channel closed once the lock is lost, after which it is no longer renewed. A nil *Held is
+// never lost.
+func (h *Held) Lost() <-chan struct{} {
+	if h == nil {
+		return nil
+	}
+	return h.lost
+}
+
+// Err returns why the lock was lost, or nil if it is still held.
+func (h *Held) Err() error {
+	select {
+	case <-h.Lost():
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Context returns a context derived from parent, canceled once any of the given locks is lost or cancel is
+// called. Nil locks are ignored.
+func Context(parent context.Context, locks ...*Held) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(parent)
+	for _, held := range locks {
+		if held == nil {
+			continue
+		}
+		go func(held *Held) {
+			select {
+			case <-held.Lost():
+				cancel()
+			case <-ctx.Done():
+			}
+		}(held)
+	}
+	return ctx, cancel
+}
+
+// Release stops renewing the lock and frees it. Calling Release on a nil *Held does nothing.
+func (h *Held) Release() error {
+	if h == nil {
+		return nil
+	}
+	close(h.stop)
+	h.wg.Wait()
+	if err := h.locker.Release(h.key, h.holder); err != nil {
+		return errors.Wrapf(err, "cannot release deployment lock %s", h.key)
+	}
+	log.Debugf("Released deployment lock %s", h.key)
+	return nil
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
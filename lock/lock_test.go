@@ -0,0 +1,362 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+// clock is a controlled clock for MemoryLocker.Now, safe to read while a held lock renews itself.
+type clock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestLocker() (*MemoryLocker, *clock) {
+	c := newClock()
+	locker := NewMemoryLocker()
+	locker.Now = c.Now
+	return locker, c
+}
+
+func TestMemoryLocker(t *testing.T) {
+	const key = "us-west-2/cluster/service"
+	tests := []struct {
+		name string
+		// run takes and releases locks, returning the error of the last step
+		run func(locker *MemoryLocker, c *clock) error
+
+		wantLocked bool
+		wantHolder string
+		wantErr    bool
+	}{
+		{
+			name: "acquire free lock",
+			run: func(locker *MemoryLocker, c *clock) error {
+				return locker.Acquire(key, "a", time.Minute)
+			},
+			wantLocked: true,
+			wantHolder: "a",
+		},
+		{
+			name: "conflict",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				return locker.Acquire(key, "b", time.Minute)
+			},
+			wantLocked: true,
+			wantHolder: "a",
+			wantErr:    true,
+		},
+		{
+			name: "extend own lock",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				c.Advance(50 * time.Second)
+				if err := locker.Acquire(key, "a", time.Minute); err != nil {
+					return err
+				}
+				c.Advance(50 * time.Second)
+				return nil
+			},
+			wantLocked: true,
+			wantHolder: "a",
+		},
+		{
+			name: "expired",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				c.Advance(time.Minute)
+				return nil
+			},
+		},
+		{
+			name: "acquire expired lock",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				c.Advance(2 * time.Minute)
+				return locker.Acquire(key, "b", time.Minute)
+			},
+			wantLocked: true,
+			wantHolder: "b",
+		},
+		{
+			name: "release",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				return locker.Release(key, "a")
+			},
+		},
+		{
+			name: "release by someone else",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				return locker.Release(key, "b")
+			},
+			wantLocked: true,
+			wantHolder: "a",
+		},
+		{
+			name: "force unlock",
+			run: func(locker *MemoryLocker, c *clock) error {
+				locker.Acquire(key, "a", time.Minute)
+				return locker.ForceUnlock(key)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locker, c := newTestLocker()
+			err := test.run(locker, c)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, test.wantErr)
+			}
+			if _, ok := err.(*LockedError); err != nil && !ok {
+				t.Errorf("expected a *LockedError, got %T", err)
+			}
+			current, err := locker.Status(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (current != nil) != test.wantLocked {
+				t.Fatalf("locked = %v, want %v", current != nil, test.wantLocked)
+			}
+			if current != nil && current.Holder != test.wantHolder {
+				t.Errorf("holder = %#v, want %#v", current.Holder, test.wantHolder)
+			}
+		})
+	}
+}
+
+func TestMemoryLockerExtendKeepsAcquiredAt(t *testing.T) {
+	locker, c := newTestLocker()
+	acquiredAt := c.Now()
+	locker.Acquire("key", "a", time.Minute)
+	c.Advance(30 * time.Second)
+	locker.Acquire("key", "a", time.Minute)
+	current, _ := locker.Status("key")
+	if !current.AcquiredAt.Equal(acquiredAt) || !current.ExpiresAt.Equal(c.Now().Add(time.Minute)) {
+		t.Errorf("lock = %s", current)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	locker, _ := newTestLocker()
+	held, err := Acquire(locker, "key", "a", time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(locker, "key", "b", time.Minute, 0); err == nil {
+		t.Error("expected a held lock to be refused without waiting")
+	}
+	if err := held.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := locker.Status("key"); current != nil {
+		t.Errorf("lock still held after release: %s", current)
+	}
+	held, err = Acquire(locker, "key", "b", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("cannot acquire a released lock: %s", err)
+	}
+	held.Release()
+}
+
+func TestAcquireRejectsNonPositiveTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if _, err := Acquire(NewMemoryLocker(), "key", "a", ttl, 0); err == nil {
+			t.Errorf("expected TTL %s to be rejected", ttl)
+		}
+	}
+}
+
+func TestHeldRenews(t *testing.T) {
+	locker, c := newTestLocker()
+	const ttl = 30 * time.Millisecond
+	held, err := Acquire(locker, "key", "a", ttl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	// Without renewal, the lock would have expired long ago
+	c.Advance(time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if current, _ := locker.Status("key"); current != nil {
+			if current.Holder != "a" {
+				t.Fatalf("holder = %#v", current.Holder)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock was not renewed")
+		}
+		time.Sleep(ttl / 3)
+	}
+}
+
+// failingLocker fails to renew locks with renewErr once it is set.
+type failingLocker struct {
+	*MemoryLocker
+	mutex    sync.Mutex
+	renewErr error
+}
+
+func (f *failingLocker) Acquire(key string, holder string, ttl time.Duration) error {
+	f.mutex.Lock()
+	err := f.renewErr
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return f.MemoryLocker.Acquire(key, holder, ttl)
+}
+
+func (f *failingLocker) fail(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.renewErr = err
+}
+
+func TestHeldLost(t *testing.T) {
+	tests := []struct {
+		name     string
+		renewErr error
+		wantErr  string
+	}{
+		{
+			name:     "taken by someone else",
+			renewErr: &LockedError{Lock: &Lock{Key: "key", Holder: "b"}},
+			wantErr:  "lost deployment lock key: lock key held by b",
+		},
+		{
+			name:     "expired",
+			renewErr: errors.New("throttled"),
+			wantErr:  "lost deployment lock key: lock expired: throttled",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locker, _ := newTestLocker()
+			failing := &failingLocker{MemoryLocker: locker}
+			const ttl = 30 * time.Millisecond
+			held, err := Acquire(failing, "key", "a", ttl, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer held.Release()
+			ctx, cancel := Context(context.Background(), nil, held)
+			defer cancel()
+			if held.Err() != nil || ctx.Err() != nil {
+				t.Fatalf("lock lost before failing to renew it: %v, %v", held.Err(), ctx.Err())
+			}
+
+			failing.fail(test.renewErr)
+			select {
+			case <-held.Lost():
+			case <-time.After(5 * time.Second):
+				t.Fatal("lock was not lost")
+			}
+			if err := held.Err(); err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("Err() = %v, want %#v", err, test.wantErr)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Error("context was not canceled")
+			}
+		})
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	locker, _ := newTestLocker()
+	held, err := Acquire(locker, "key", "a", time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+	ctx, cancel := Context(context.Background(), held)
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("context not canceled")
+	}
+	if held.Err() != nil {
+		t.Errorf("lock lost: %s", held.Err())
+	}
+}
+
+func TestReleaseNil(t *testing.T) {
+	var held *Held
+	if err := held.Release(); err != nil {
+		t.Error(err)
+	}
+	if held.Lost() != nil || held.Err() != nil {
+		t.Error("a nil lock is lost")
+	}
+}
+
+func TestTagLocker(t *testing.T) {
+	fake := ecsfake.New("test")
+	_, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Family:               aws.String("web"),
+		ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("web"), Image: aws.String("example/web")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fake.CreateService(&ecs.CreateServiceInput{Cluster: aws.String("test"), ServiceName: aws.String("web"), TaskDefinition: aws.String("web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := NewTagLocker(fake)
+	locker.SettleDelay = 0
+
+	const key = "us-west-2/test/web"
+	if err := locker.Acquire(key, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := locker.Acquire(key, "b", time.Minute); err == nil {
+		t.Error("expected the lock to be held")
+	}
+	if current, err := locker.Status(key); err != nil || current == nil || current.Holder != "a" {
+		t.Errorf("Status = %v, %v", current, err)
+	}
+	if err := locker.Release(key, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := locker.Status(key); err != nil || current != nil {
+		t.Errorf("Status after release = %v, %v", current, err)
+	}
+
+	if err := locker.Acquire("us-west-2/test/missing", "a", time.Minute); err == nil || !strings.Contains(err.Error(), "only lock existing services") {
+		t.Errorf("expected missing services to be refused, got %v", err)
+	}
+	if err := locker.Acquire("web", "a", time.Minute); err == nil {
+		t.Error("expected a key without a cluster to be refused")
+	}
+}
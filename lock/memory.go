@@ -0,0 +1,70 @@
+package lock
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLocker keeps locks in memory. It only excludes holders within the same process, and is meant for tests.
+type MemoryLocker struct {
+	// Now returns the current time; can be replaced to control lock expiry.
+	Now func() time.Time
+
+	mutex sync.Mutex
+	locks map[string]Lock
+}
+
+// NewMemoryLocker creates an empty in-memory lock backend.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		Now:   time.Now,
+		locks: map[string]Lock{},
+	}
+}
+
+// Acquire implements Locker.
+func (m *MemoryLocker) Acquire(key string, holder string, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.Now()
+	current, ok := m.locks[key]
+	switch {
+	case ok && current.Holder == holder:
+		current.ExpiresAt = now.Add(ttl)
+		m.locks[key] = current
+	case ok && now.Before(current.ExpiresAt):
+		return &LockedError{Lock: &current}
+	default:
+		m.locks[key] = Lock{Key: key, Holder: holder, AcquiredAt: now, ExpiresAt: now.Add(ttl)}
+	}
+	return nil
+}
+
+// Release implements Locker.
+func (m *MemoryLocker) Release(key string, holder string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if current, ok := m.locks[key]; ok && current.Holder == holder {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+// Status implements Locker.
+func (m *MemoryLocker) Status(key string) (*Lock, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, ok := m.locks[key]
+	if !ok || !m.Now().Before(current.ExpiresAt) {
+		return nil, nil
+	}
+	return &current, nil
+}
+
+// ForceUnlock implements Locker.
+func (m *MemoryLocker) ForceUnlock(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.locks, key)
+	return nil
+}
@@ -0,0 +1,160 @@
+package lock
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/pkg/errors"
+)
+
+const (
+	holderTag     = "czecs:lock-holder"
+	acquiredAtTag = "czecs:lock-acquired-at"
+	expiresAtTag  = "czecs:lock-expires-at"
+)
+
+// invalidTagCharacters matches characters not allowed in ECS tag values
+var invalidTagCharacters = regexp.MustCompile(`[^\pL\pZ\pN_.:/=+\-@]`)
+
+// TagLocker stores locks as tags on the ECS service being deployed, with keys of the form region/cluster/service
+// (or cluster/service); the service is looked up in the region of the ECS client.
+// Tags cannot be written conditionally, so after writing its tags a holder waits SettleDelay and
+// reads them back to check no one else overwrote them; this narrows, but does not close, the window
+// for two holders racing. Services must use the long ARN format to support tagging.
+type TagLocker struct {
+	svc ecsiface.ECSAPI
+	// Now returns the current time; can be replaced to control lock expiry.
+	Now func() time.Time
+	// SettleDelay is how long to wait before verifying a newly written lock.
+	SettleDelay time.Duration
+}
+
+// NewTagLocker creates a lock backend tagging services through the given ECS client.
+func NewTagLocker(svc ecsiface.ECSAPI) *TagLocker {
+	return &TagLocker{
+		svc:         svc,
+		Now:         time.Now,
+		SettleDelay: 2 * time.Second,
+	}
+}
+
+// Acquire implements Locker.
+func (t *TagLocker) Acquire(key string, holder string, ttl time.Duration) error {
+	holder = invalidTagCharacters.ReplaceAllString(holder, "_")
+	serviceArn, current, err := t.read(key)
+	if err != nil {
+		return err
+	}
+	now := t.Now()
+	acquiredAt := now
+	if current != nil {
+		if current.Holder != holder {
+			return &LockedError{Lock: current}
+		}
+		acquiredAt = current.AcquiredAt
+	}
+
+	_, err = t.svc.TagResource(&ecs.TagResourceInput{
+		ResourceArn: &serviceArn,
+		Tags: []*ecs.Tag{
+			{Key: aws.String(holderTag), Value: &holder},
+			{Key: aws.String(acquiredAtTag), Value: aws.String(acquiredAt.UTC().Format(time.RFC3339))},
+			{Key: aws.String(expiresAtTag), Value: aws.String(now.Add(ttl).UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot tag service %s", serviceArn)
+	}
+	if current != nil {
+		// Extending our own lock; nothing to race against
+		return nil
+	}
+
+	time.Sleep(t.SettleDelay)
+	_, current, err = t.read(key)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Holder != holder {
+		return &LockedError{Lock: current}
+	}
+	return nil
+}
+
+// Release implements Locker.
+func (t *TagLocker) Release(key string, holder string) error {
+	holder = invalidTagCharacters.ReplaceAllString(holder, "_")
+	serviceArn, current, err := t.read(key)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Holder != holder {
+		return nil
+	}
+	return t.untag(serviceArn)
+}
+
+// Status implements Locker.
+func (t *TagLocker) Status(key string) (*Lock, error) {
+	_, current, err := t.read(key)
+	return current, err
+}
+
+// ForceUnlock implements Locker.
+func (t *TagLocker) ForceUnlock(key string) error {
+	serviceArn, _, err := t.read(key)
+	if err != nil {
+		return err
+	}
+	return t.untag(serviceArn)
+}
+
+// read returns the ARN of the service named by key, and its current unexpired lock if any.
+func (t *TagLocker) read(key string) (string, *Lock, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", nil, fmt.Errorf("lock key %#v is not of the form region/cluster/service", key)
+	}
+	cluster, service := parts[len(parts)-2], parts[len(parts)-1]
+	output, err := t.svc.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  &cluster,
+		Services: []*string{&service},
+		Include:  []*string{aws.String(ecs.ServiceFieldTags)},
+	})
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "cannot describe service %s", key)
+	}
+	if len(output.Services) == 0 {
+		return "", nil, fmt.Errorf("service %s not found; the tags lock backend can only lock existing services", key)
+	}
+	ecsService := output.Services[0]
+
+	tags := map[string]string{}
+	for _, tag := range ecsService.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	holder, ok := tags[holderTag]
+	if !ok {
+		return *ecsService.ServiceArn, nil, nil
+	}
+	lock := &Lock{Key: key, Holder: holder}
+	lock.AcquiredAt, _ = time.Parse(time.RFC3339, tags[acquiredAtTag])
+	lock.ExpiresAt, _ = time.Parse(time.RFC3339, tags[expiresAtTag])
+	if !t.Now().Before(lock.ExpiresAt) {
+		return *ecsService.ServiceArn, nil, nil
+	}
+	return *ecsService.ServiceArn, lock, nil
+}
+
+func (t *TagLocker) untag(serviceArn string) error {
+	_, err := t.svc.UntagResource(&ecs.UntagResourceInput{
+		ResourceArn: &serviceArn,
+		TagKeys:     []*string{aws.String(holderTag), aws.String(acquiredAtTag), aws.String(expiresAtTag)},
+	})
+	return errors.Wrapf(err, "cannot untag service %s", serviceArn)
+}
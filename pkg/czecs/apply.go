@@ -0,0 +1,85 @@
+package czecs
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ServiceInactive is the status of a deleted service, which can be created again.
+const ServiceInactive = "INACTIVE"
+
+// Actions taken by Apply.
+const (
+	ActionInstall = "install"
+	ActionUpgrade = "upgrade"
+)
+
+// ApplyResult describes the outcome of Apply, including a failed one.
+type ApplyResult struct {
+	// Action is ActionInstall if the service was created, or ActionUpgrade if it already existed
+	Action  string         `json:"action"`
+	Install *InstallResult `json:"install,omitempty"`
+	Upgrade *UpgradeResult `json:"upgrade,omitempty"`
+	// UnmanagedSettings are the settings of an upgraded service that Apply left as they are, although installing
+	// the service would not have set them; see Apply
+	UnmanagedSettings []string `json:"unmanagedSettings,omitempty"`
+}
+
+// Apply creates the service like Install if it does not exist (or was deleted), and otherwise upgrades it
+// like Upgrade, including changes to the deployment configuration of the service such as CircuitBreaker.
+// Deregister only applies to upgrades.
+//
+// Only the settings czecs manages are applied: the task definition and the circuit breaker. The desired count
+// (see Scale), load balancers, network configuration, health check grace period, platform version and the
+// minimum and maximum percent of deployments are left as they are on an existing service, and are not set on a
+// new one. Those an existing service sets differently from a new one are warned about and reported as
+// UnmanagedSettings, other than the desired count, which is expected to change, e.g. by auto scaling.
+func (c *Client) Apply(opts UpgradeOptions) (*ApplyResult, error) {
+	existing, err := c.FindService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplyResult{}
+	if existing == nil {
+		c.Log.Infof("Service %#v does not exist in cluster %#v; installing it", opts.Service, opts.Cluster)
+		result.Action = ActionInstall
+		result.Install, err = c.Install(opts.InstallOptions)
+		return result, err
+	}
+	c.Log.Infof("Service %#v exists in cluster %#v; upgrading it", opts.Service, opts.Cluster)
+	result.Action = ActionUpgrade
+	result.UnmanagedSettings = unmanagedSettings(existing)
+	for _, setting := range result.UnmanagedSettings {
+		c.Log.Warnf("Service %#v has %s, which apply leaves as is; installing the service would not set it", opts.Service, setting)
+	}
+	result.Upgrade, err = c.Upgrade(opts)
+	return result, err
+}
+
+// unmanagedSettings returns the settings of an existing service that are not applied, but differ from those of
+// a service created by Install.
+func unmanagedSettings(service *ecs.Service) []string {
+	var settings []string
+	if len(service.LoadBalancers) > 0 {
+		settings = append(settings, "load balancers")
+	}
+	if service.NetworkConfiguration != nil {
+		settings = append(settings, "a network configuration")
+	}
+	if aws.Int64Value(service.HealthCheckGracePeriodSeconds) != 0 {
+		settings = append(settings, fmt.Sprintf("a health check grace period of %d seconds", aws.Int64Value(service.HealthCheckGracePeriodSeconds)))
+	}
+	if version := aws.StringValue(service.PlatformVersion); version != "" && version != "LATEST" {
+		settings = append(settings, fmt.Sprintf("platform version %s", version))
+	}
+	if deploymentConfiguration := service.DeploymentConfiguration; deploymentConfiguration != nil {
+		minimum, maximum := deploymentConfiguration.MinimumHealthyPercent, deploymentConfiguration.MaximumPercent
+		if (minimum != nil && *minimum != 100) || (maximum != nil && *maximum != 200) {
+			settings = append(settings, fmt.Sprintf("a deployment minimum healthy percent of %d and maximum percent of %d",
+				aws.Int64Value(minimum), aws.Int64Value(maximum)))
+		}
+	}
+	return settings
+}
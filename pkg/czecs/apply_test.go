@@ -0,0 +1,139 @@
+package czecs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the fake, e.g. creating the service
+		setup func(t *testing.T, fake *ecsfake.ECS, c *Client)
+
+		wantAction         string
+		wantTaskDefinition string
+		wantUnmanaged      []string
+	}{
+		{
+			name:               "missing service",
+			wantAction:         ActionInstall,
+			wantTaskDefinition: "test-web:1",
+		},
+		{
+			name: "existing service",
+			setup: func(t *testing.T, fake *ecsfake.ECS, c *Client) {
+				installTestService(t, c)
+			},
+			wantAction:         ActionUpgrade,
+			wantTaskDefinition: "test-web:2",
+		},
+		{
+			name: "deleted service",
+			setup: func(t *testing.T, fake *ecsfake.ECS, c *Client) {
+				installTestService(t, c)
+				if _, err := fake.DeleteService(&ecs.DeleteServiceInput{Cluster: aws.String(testCluster), Service: aws.String("web"), Force: aws.Bool(true)}); err != nil {
+					t.Fatal(err)
+				}
+				// Let the service drain
+				testService(t, fake, "web")
+			},
+			wantAction:         ActionInstall,
+			wantTaskDefinition: "test-web:2",
+		},
+		{
+			name: "existing service with unmanaged settings",
+			setup: func(t *testing.T, fake *ecsfake.ECS, c *Client) {
+				taskDefnArn, err := c.Register(RegisterOptions{Template: "testdata/web.json", Values: webValues("v1")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = fake.CreateService(&ecs.CreateServiceInput{
+					Cluster:        aws.String(testCluster),
+					ServiceName:    aws.String("web"),
+					TaskDefinition: &taskDefnArn,
+					DesiredCount:   aws.Int64(3),
+					LoadBalancers:  []*ecs.LoadBalancer{{ContainerName: aws.String("web"), ContainerPort: aws.Int64(80), TargetGroupArn: aws.String("arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/web/1")}},
+					DeploymentConfiguration: &ecs.DeploymentConfiguration{
+						MinimumHealthyPercent: aws.Int64(50),
+						MaximumPercent:        aws.Int64(200),
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantAction:         ActionUpgrade,
+			wantTaskDefinition: "test-web:2",
+			wantUnmanaged:      []string{"load balancers", "a deployment minimum healthy percent of 50 and maximum percent of 200"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			c := newTestClient(fake)
+			if test.setup != nil {
+				test.setup(t, fake, c)
+			}
+
+			opts := UpgradeOptions{}
+			opts.Cluster, opts.Service = testCluster, "web"
+			opts.Template, opts.Values = "testdata/web.json", webValues("v2")
+			opts.Timeout = 15
+			result, err := c.Apply(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Action != test.wantAction {
+				t.Errorf("Action = %#v, want %#v", result.Action, test.wantAction)
+			}
+			if (result.Install != nil) != (test.wantAction == ActionInstall) || (result.Upgrade != nil) != (test.wantAction == ActionUpgrade) {
+				t.Errorf("Install = %v, Upgrade = %v", result.Install, result.Upgrade)
+			}
+			if !reflect.DeepEqual(result.UnmanagedSettings, test.wantUnmanaged) {
+				t.Errorf("UnmanagedSettings = %#v, want %#v", result.UnmanagedSettings, test.wantUnmanaged)
+			}
+			service := testService(t, fake, "web")
+			if got := aws.StringValue(service.TaskDefinition); got != fakeTaskDefinitionArn(test.wantTaskDefinition) {
+				t.Errorf("service task definition = %#v, want %#v", got, test.wantTaskDefinition)
+			}
+		})
+	}
+}
+
+func TestApplyCircuitBreaker(t *testing.T) {
+	fake := ecsfake.New(testCluster)
+	c := newTestClient(fake)
+	installTestService(t, c)
+
+	opts := UpgradeOptions{}
+	opts.Cluster, opts.Service = testCluster, "web"
+	opts.Template, opts.Values = "testdata/web.json", webValues("v2")
+	opts.Timeout = 15
+	opts.CircuitBreaker = true
+	if _, err := c.Apply(opts); err != nil {
+		t.Fatal(err)
+	}
+	deploymentConfiguration := testService(t, fake, "web").DeploymentConfiguration
+	circuitBreaker := deploymentConfiguration.DeploymentCircuitBreaker
+	if circuitBreaker == nil || !aws.BoolValue(circuitBreaker.Enable) || !aws.BoolValue(circuitBreaker.Rollback) {
+		t.Errorf("circuit breaker = %v", circuitBreaker)
+	}
+	// The rest of the deployment configuration is kept
+	if aws.Int64Value(deploymentConfiguration.MinimumHealthyPercent) != 100 || aws.Int64Value(deploymentConfiguration.MaximumPercent) != 200 {
+		t.Errorf("deployment configuration = %v", deploymentConfiguration)
+	}
+}
+
+// installTestService installs the web service with tag v1.
+func installTestService(t *testing.T, c *Client) {
+	t.Helper()
+	installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+	if _, err := c.Install(installOpts); err != nil {
+		t.Fatal(err)
+	}
+}
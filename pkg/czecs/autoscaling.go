@@ -0,0 +1,343 @@
+package czecs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/pkg/errors"
+)
+
+// AutoScalingOptions configures ApplyAutoScaling.
+type AutoScalingOptions struct {
+	Cluster string
+	Service string
+	// Template is the file name or URI of the auto scaling template; see AutoScaling
+	Template string
+	Values   Values
+}
+
+// AutoScaling is the Application Auto Scaling configuration of a service: the input of RegisterScalableTarget
+// and of PutScalingPolicy for each scaling policy. Their service namespace, resource ID and scalable dimension
+// are those of the service, and are filled in when applying it.
+type AutoScaling struct {
+	ScalableTarget  *applicationautoscaling.RegisterScalableTargetInput `json:"scalableTarget"`
+	ScalingPolicies []*applicationautoscaling.PutScalingPolicyInput     `json:"scalingPolicies"`
+}
+
+// AutoScalingResult describes the scalable target and scaling policies of a service.
+type AutoScalingResult struct {
+	// ResourceID is the resource ID of the service in Application Auto Scaling, service/<cluster>/<service>
+	ResourceID  string `json:"resourceId"`
+	MinCapacity int64  `json:"minCapacity"`
+	MaxCapacity int64  `json:"maxCapacity"`
+	// Suspended is set if scaling in, scaling out or scheduled scaling is suspended
+	Suspended bool            `json:"suspended"`
+	Policies  []ScalingPolicy `json:"policies"`
+	// DeletedPolicies are the names of the policies deleted since they were not in the template
+	DeletedPolicies []string `json:"deletedPolicies,omitempty"`
+}
+
+// ScalingPolicy is a scaling policy of a service.
+type ScalingPolicy struct {
+	Name string `json:"name"`
+	// Type is TargetTrackingScaling or StepScaling
+	Type string `json:"type"`
+	Arn  string `json:"arn,omitempty"`
+}
+
+// AutoScalingResourceID returns the resource ID of the given service in Application Auto Scaling.
+func AutoScalingResourceID(cluster string, service string) string {
+	// Resource IDs take names, not ARNs
+	if i := strings.LastIndex(cluster, ":cluster/"); i >= 0 {
+		cluster = cluster[i+len(":cluster/"):]
+	}
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[i+1:]
+	}
+	return fmt.Sprintf("service/%s/%s", cluster, service)
+}
+
+// RenderAutoScaling renders the auto scaling template with the given values, for the given service.
+func (c *Client) RenderAutoScaling(template string, values Values, cluster string, service string) (*AutoScaling, error) {
+	template, err := tasks.Resolve(template)
+	if err != nil {
+		return nil, err
+	}
+	templateValues, err := c.templateValues(template, values)
+	if err != nil {
+		return nil, err
+	}
+	var autoScaling AutoScaling
+	if err := tasks.ParseTemplate(template, templateValues, values.Strict, values.Partials, &autoScaling); err != nil {
+		return nil, errors.Wrap(err, "cannot parse auto scaling")
+	}
+	if autoScaling.ScalableTarget == nil {
+		return nil, fmt.Errorf("the auto scaling template has no scalableTarget")
+	}
+	resourceID := AutoScalingResourceID(cluster, service)
+	autoScaling.ScalableTarget.ServiceNamespace = aws.String(applicationautoscaling.ServiceNamespaceEcs)
+	autoScaling.ScalableTarget.ScalableDimension = aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount)
+	autoScaling.ScalableTarget.ResourceId = &resourceID
+	if err := autoScaling.ScalableTarget.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid scalable target")
+	}
+	names := map[string]bool{}
+	for _, policy := range autoScaling.ScalingPolicies {
+		policy.ServiceNamespace = autoScaling.ScalableTarget.ServiceNamespace
+		policy.ScalableDimension = autoScaling.ScalableTarget.ScalableDimension
+		policy.ResourceId = &resourceID
+		if err := policy.Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid scaling policy")
+		}
+		name := aws.StringValue(policy.PolicyName)
+		if names[name] {
+			return nil, fmt.Errorf("scaling policy %#v is given more than once", name)
+		}
+		names[name] = true
+	}
+	return &autoScaling, nil
+}
+
+// ApplyAutoScaling registers the scalable target of the service and puts its scaling policies, as given by
+// the auto scaling template. Other scaling policies of the service are deleted.
+func (c *Client) ApplyAutoScaling(opts AutoScalingOptions) (*AutoScalingResult, error) {
+	if _, err := c.DescribeService(opts.Cluster, opts.Service); err != nil {
+		return nil, err
+	}
+	autoScaling, err := c.RenderAutoScaling(opts.Template, opts.Values, opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	resourceID := aws.StringValue(autoScaling.ScalableTarget.ResourceId)
+	if _, err := c.AutoScaling.RegisterScalableTarget(autoScaling.ScalableTarget); err != nil {
+		return nil, errors.Wrapf(err, "cannot register scalable target %#v", resourceID)
+	}
+	c.Log.Infof("Registered scalable target %#v with capacity %d to %d", resourceID,
+		aws.Int64Value(autoScaling.ScalableTarget.MinCapacity), aws.Int64Value(autoScaling.ScalableTarget.MaxCapacity))
+
+	wanted := map[string]bool{}
+	for _, policy := range autoScaling.ScalingPolicies {
+		wanted[aws.StringValue(policy.PolicyName)] = true
+		if _, err := c.AutoScaling.PutScalingPolicy(policy); err != nil {
+			return nil, errors.Wrapf(err, "cannot put scaling policy %#v", aws.StringValue(policy.PolicyName))
+		}
+		c.Log.Infof("Put %s policy %#v", aws.StringValue(policy.PolicyType), aws.StringValue(policy.PolicyName))
+	}
+	existing, err := c.scalingPolicies(resourceID)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, policy := range existing {
+		if wanted[aws.StringValue(policy.PolicyName)] {
+			continue
+		}
+		if err := c.deleteScalingPolicy(policy); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, aws.StringValue(policy.PolicyName))
+	}
+
+	result, err := c.DescribeAutoScaling(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("scalable target %#v not found after registering it", resourceID)
+	}
+	result.DeletedPolicies = deleted
+	return result, nil
+}
+
+// DescribeAutoScaling returns the scalable target and scaling policies of the service, or nil if the service
+// has no scalable target.
+func (c *Client) DescribeAutoScaling(cluster string, service string) (*AutoScalingResult, error) {
+	resourceID := AutoScalingResourceID(cluster, service)
+	target, err := c.scalableTarget(resourceID)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	result := &AutoScalingResult{
+		ResourceID:  resourceID,
+		MinCapacity: aws.Int64Value(target.MinCapacity),
+		MaxCapacity: aws.Int64Value(target.MaxCapacity),
+		Suspended:   isSuspended(target.SuspendedState),
+		Policies:    []ScalingPolicy{},
+	}
+	policies, err := c.scalingPolicies(resourceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		result.Policies = append(result.Policies, ScalingPolicy{
+			Name: aws.StringValue(policy.PolicyName),
+			Type: aws.StringValue(policy.PolicyType),
+			Arn:  aws.StringValue(policy.PolicyARN),
+		})
+	}
+	return result, nil
+}
+
+// DeleteAutoScaling deletes the scaling policies of the service, then deregisters its scalable target. The
+// desired count of the service is left as is.
+func (c *Client) DeleteAutoScaling(cluster string, service string) (*AutoScalingResult, error) {
+	result, err := c.DescribeAutoScaling(cluster, service)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("Service %#v in cluster %#v has no scalable target", service, cluster)
+	}
+	policies, err := c.scalingPolicies(result.ResourceID)
+	if err != nil {
+		return nil, err
+	}
+	result.Policies = []ScalingPolicy{}
+	for _, policy := range policies {
+		if err := c.deleteScalingPolicy(policy); err != nil {
+			return nil, err
+		}
+		result.DeletedPolicies = append(result.DeletedPolicies, aws.StringValue(policy.PolicyName))
+	}
+	_, err = c.AutoScaling.DeregisterScalableTarget(&applicationautoscaling.DeregisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceId:        &result.ResourceID,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot deregister scalable target %#v", result.ResourceID)
+	}
+	c.Log.Infof("Deregistered scalable target %#v", result.ResourceID)
+	return result, nil
+}
+
+// SuspendAutoScaling suspends scaling in, scaling out and scheduled scaling of the service, if it has a
+// scalable target. Returns a function restoring the previous suspended state, which does nothing if the
+// service has no scalable target.
+func (c *Client) SuspendAutoScaling(cluster string, service string) (func() error, error) {
+	resourceID := AutoScalingResourceID(cluster, service)
+	target, err := c.scalableTarget(resourceID)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		c.Log.Debugf("Service %#v has no scalable target; nothing to suspend", service)
+		return func() error { return nil }, nil
+	}
+	// Every field is set, since RegisterScalableTarget leaves those not given unchanged
+	previous := &applicationautoscaling.SuspendedState{}
+	if state := target.SuspendedState; state != nil {
+		previous.DynamicScalingInSuspended = aws.Bool(aws.BoolValue(state.DynamicScalingInSuspended))
+		previous.DynamicScalingOutSuspended = aws.Bool(aws.BoolValue(state.DynamicScalingOutSuspended))
+		previous.ScheduledScalingSuspended = aws.Bool(aws.BoolValue(state.ScheduledScalingSuspended))
+	} else {
+		previous = suspendedState(false)
+	}
+	if err := c.setSuspendedState(resourceID, suspendedState(true)); err != nil {
+		return nil, err
+	}
+	c.Log.Infof("Suspended auto scaling of %#v", resourceID)
+	return func() error {
+		if err := c.setSuspendedState(resourceID, previous); err != nil {
+			return err
+		}
+		c.Log.Infof("Restored auto scaling of %#v", resourceID)
+		return nil
+	}, nil
+}
+
+// ResumeAutoScaling resumes all scaling of the service, failing if it has no scalable target.
+func (c *Client) ResumeAutoScaling(cluster string, service string) error {
+	resourceID := AutoScalingResourceID(cluster, service)
+	target, err := c.scalableTarget(resourceID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("Service %#v in cluster %#v has no scalable target", service, cluster)
+	}
+	if err := c.setSuspendedState(resourceID, suspendedState(false)); err != nil {
+		return err
+	}
+	c.Log.Infof("Resumed auto scaling of %#v", resourceID)
+	return nil
+}
+
+func suspendedState(suspended bool) *applicationautoscaling.SuspendedState {
+	return &applicationautoscaling.SuspendedState{
+		DynamicScalingInSuspended:  aws.Bool(suspended),
+		DynamicScalingOutSuspended: aws.Bool(suspended),
+		ScheduledScalingSuspended:  aws.Bool(suspended),
+	}
+}
+
+func isSuspended(state *applicationautoscaling.SuspendedState) bool {
+	return state != nil && (aws.BoolValue(state.DynamicScalingInSuspended) ||
+		aws.BoolValue(state.DynamicScalingOutSuspended) || aws.BoolValue(state.ScheduledScalingSuspended))
+}
+
+// setSuspendedState updates the suspended state of an existing scalable target, leaving its capacity as is.
+func (c *Client) setSuspendedState(resourceID string, state *applicationautoscaling.SuspendedState) error {
+	_, err := c.AutoScaling.RegisterScalableTarget(&applicationautoscaling.RegisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceId:        &resourceID,
+		SuspendedState:    state,
+	})
+	return errors.Wrapf(err, "cannot update the suspended state of scalable target %#v", resourceID)
+}
+
+// scalableTarget returns the scalable target of the given resource ID, or nil if there is none.
+func (c *Client) scalableTarget(resourceID string) (*applicationautoscaling.ScalableTarget, error) {
+	describeScalableTargetsOutput, err := c.AutoScaling.DescribeScalableTargets(&applicationautoscaling.DescribeScalableTargetsInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceIds:       []*string{&resourceID},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot describe scalable target %#v", resourceID)
+	}
+	for _, target := range describeScalableTargetsOutput.ScalableTargets {
+		if aws.StringValue(target.ResourceId) == resourceID {
+			return target, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) scalingPolicies(resourceID string) ([]*applicationautoscaling.ScalingPolicy, error) {
+	var policies []*applicationautoscaling.ScalingPolicy
+	input := &applicationautoscaling.DescribeScalingPoliciesInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceEcs),
+		ScalableDimension: aws.String(applicationautoscaling.ScalableDimensionEcsServiceDesiredCount),
+		ResourceId:        &resourceID,
+	}
+	for {
+		describeScalingPoliciesOutput, err := c.AutoScaling.DescribeScalingPolicies(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot describe the scaling policies of %#v", resourceID)
+		}
+		policies = append(policies, describeScalingPoliciesOutput.ScalingPolicies...)
+		if describeScalingPoliciesOutput.NextToken == nil {
+			return policies, nil
+		}
+		input.NextToken = describeScalingPoliciesOutput.NextToken
+	}
+}
+
+func (c *Client) deleteScalingPolicy(policy *applicationautoscaling.ScalingPolicy) error {
+	_, err := c.AutoScaling.DeleteScalingPolicy(&applicationautoscaling.DeleteScalingPolicyInput{
+		ServiceNamespace:  policy.ServiceNamespace,
+		ScalableDimension: policy.ScalableDimension,
+		ResourceId:        policy.ResourceId,
+		PolicyName:        policy.PolicyName,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot delete scaling policy %#v", aws.StringValue(policy.PolicyName))
+	}
+	c.Log.Infof("Deleted scaling policy %#v", aws.StringValue(policy.PolicyName))
+	return nil
+}
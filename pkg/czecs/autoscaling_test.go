@@ -0,0 +1,284 @@
+package czecs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+// fakeAutoScaling is an in-memory Application Auto Scaling with the calls czecs makes.
+type fakeAutoScaling struct {
+	applicationautoscalingiface.ApplicationAutoScalingAPI
+	// targets and policies are by resource ID; policies by name within a resource ID
+	targets  map[string]*applicationautoscaling.ScalableTarget
+	policies map[string]map[string]*applicationautoscaling.ScalingPolicy
+}
+
+func newFakeAutoScaling() *fakeAutoScaling {
+	return &fakeAutoScaling{
+		targets:  map[string]*applicationautoscaling.ScalableTarget{},
+		policies: map[string]map[string]*applicationautoscaling.ScalingPolicy{},
+	}
+}
+
+func (f *fakeAutoScaling) RegisterScalableTarget(input *applicationautoscaling.RegisterScalableTargetInput) (*applicationautoscaling.RegisterScalableTargetOutput, error) {
+	resourceID := aws.StringValue(input.ResourceId)
+	target, ok := f.targets[resourceID]
+	if !ok {
+		if input.MinCapacity == nil || input.MaxCapacity == nil {
+			return nil, awserr.New(applicationautoscaling.ErrCodeValidationException, "MinCapacity and MaxCapacity are required to register a scalable target", nil)
+		}
+		target = &applicationautoscaling.ScalableTarget{
+			ServiceNamespace:  input.ServiceNamespace,
+			ScalableDimension: input.ScalableDimension,
+			ResourceId:        input.ResourceId,
+			SuspendedState:    suspendedState(false),
+		}
+		f.targets[resourceID] = target
+	}
+	// Fields not given are left unchanged
+	if input.MinCapacity != nil {
+		target.MinCapacity = input.MinCapacity
+	}
+	if input.MaxCapacity != nil {
+		target.MaxCapacity = input.MaxCapacity
+	}
+	if state := input.SuspendedState; state != nil {
+		if state.DynamicScalingInSuspended != nil {
+			target.SuspendedState.DynamicScalingInSuspended = state.DynamicScalingInSuspended
+		}
+		if state.DynamicScalingOutSuspended != nil {
+			target.SuspendedState.DynamicScalingOutSuspended = state.DynamicScalingOutSuspended
+		}
+		if state.ScheduledScalingSuspended != nil {
+			target.SuspendedState.ScheduledScalingSuspended = state.ScheduledScalingSuspended
+		}
+	}
+	return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DescribeScalableTargets(input *applicationautoscaling.DescribeScalableTargetsInput) (*applicationautoscaling.DescribeScalableTargetsOutput, error) {
+	output := &applicationautoscaling.DescribeScalableTargetsOutput{}
+	for _, resourceID := range input.ResourceIds {
+		if target, ok := f.targets[aws.StringValue(resourceID)]; ok {
+			output.ScalableTargets = append(output.ScalableTargets, target)
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeAutoScaling) DeregisterScalableTarget(input *applicationautoscaling.DeregisterScalableTargetInput) (*applicationautoscaling.DeregisterScalableTargetOutput, error) {
+	resourceID := aws.StringValue(input.ResourceId)
+	if _, ok := f.targets[resourceID]; !ok {
+		return nil, awserr.New(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target found", nil)
+	}
+	delete(f.targets, resourceID)
+	delete(f.policies, resourceID)
+	return &applicationautoscaling.DeregisterScalableTargetOutput{}, nil
+}
+
+func (f *fakeAutoScaling) PutScalingPolicy(input *applicationautoscaling.PutScalingPolicyInput) (*applicationautoscaling.PutScalingPolicyOutput, error) {
+	resourceID := aws.StringValue(input.ResourceId)
+	if _, ok := f.targets[resourceID]; !ok {
+		return nil, awserr.New(applicationautoscaling.ErrCodeObjectNotFoundException, "No scalable target registered", nil)
+	}
+	if f.policies[resourceID] == nil {
+		f.policies[resourceID] = map[string]*applicationautoscaling.ScalingPolicy{}
+	}
+	name := aws.StringValue(input.PolicyName)
+	arn := fmt.Sprintf("arn:aws:autoscaling:%s:%s:scalingPolicy:%s:policyName/%s", ecsfake.Region, ecsfake.Account, resourceID, name)
+	f.policies[resourceID][name] = &applicationautoscaling.ScalingPolicy{
+		ServiceNamespace:  input.ServiceNamespace,
+		ScalableDimension: input.ScalableDimension,
+		ResourceId:        input.ResourceId,
+		PolicyName:        input.PolicyName,
+		PolicyType:        input.PolicyType,
+		PolicyARN:         &arn,
+	}
+	return &applicationautoscaling.PutScalingPolicyOutput{PolicyARN: &arn}, nil
+}
+
+func (f *fakeAutoScaling) DescribeScalingPolicies(input *applicationautoscaling.DescribeScalingPoliciesInput) (*applicationautoscaling.DescribeScalingPoliciesOutput, error) {
+	output := &applicationautoscaling.DescribeScalingPoliciesOutput{}
+	for _, policy := range f.policies[aws.StringValue(input.ResourceId)] {
+		output.ScalingPolicies = append(output.ScalingPolicies, policy)
+	}
+	return output, nil
+}
+
+func (f *fakeAutoScaling) DeleteScalingPolicy(input *applicationautoscaling.DeleteScalingPolicyInput) (*applicationautoscaling.DeleteScalingPolicyOutput, error) {
+	resourceID, name := aws.StringValue(input.ResourceId), aws.StringValue(input.PolicyName)
+	if _, ok := f.policies[resourceID][name]; !ok {
+		return nil, awserr.New(applicationautoscaling.ErrCodeObjectNotFoundException, "No scaling policy found", nil)
+	}
+	delete(f.policies[resourceID], name)
+	return &applicationautoscaling.DeleteScalingPolicyOutput{}, nil
+}
+
+// newAutoScalingTestClient returns a client of the fakes with the web service installed.
+func newAutoScalingTestClient(t *testing.T) (*ecsfake.ECS, *fakeAutoScaling, *Client) {
+	t.Helper()
+	fake := ecsfake.New(testCluster)
+	autoScaling := newFakeAutoScaling()
+	c := newTestClient(fake)
+	c.AutoScaling = autoScaling
+	installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+	if _, err := c.Install(installOpts); err != nil {
+		t.Fatal(err)
+	}
+	return fake, autoScaling, c
+}
+
+func applyTestAutoScaling(t *testing.T, c *Client, maxCapacity string) *AutoScalingResult {
+	t.Helper()
+	result, err := c.ApplyAutoScaling(AutoScalingOptions{
+		Cluster:  testCluster,
+		Service:  "web",
+		Template: "testdata/autoscaling.json",
+		Values:   Values{Set: []string{"maxCapacity=" + maxCapacity}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestApplyAutoScaling(t *testing.T) {
+	_, autoScaling, c := newAutoScalingTestClient(t)
+	result := applyTestAutoScaling(t, c, "4")
+	if result.ResourceID != "service/test/web" || result.MinCapacity != 1 || result.MaxCapacity != 4 || result.Suspended {
+		t.Errorf("result = %+v", result)
+	}
+	if len(result.Policies) != 1 || result.Policies[0].Name != "cpu" || result.Policies[0].Type != applicationautoscaling.PolicyTypeTargetTrackingScaling {
+		t.Errorf("policies = %+v", result.Policies)
+	}
+
+	// Applying again updates the capacity, and deletes the policies not in the template
+	if _, err := autoScaling.PutScalingPolicy(&applicationautoscaling.PutScalingPolicyInput{
+		ResourceId: aws.String("service/test/web"),
+		PolicyName: aws.String("manual"),
+		PolicyType: aws.String(applicationautoscaling.PolicyTypeStepScaling),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	result = applyTestAutoScaling(t, c, "8")
+	if result.MaxCapacity != 8 || len(result.Policies) != 1 {
+		t.Errorf("result = %+v", result)
+	}
+	if !reflect.DeepEqual(result.DeletedPolicies, []string{"manual"}) {
+		t.Errorf("DeletedPolicies = %v", result.DeletedPolicies)
+	}
+}
+
+func TestApplyAutoScalingMissingService(t *testing.T) {
+	_, autoScaling, c := newAutoScalingTestClient(t)
+	_, err := c.ApplyAutoScaling(AutoScalingOptions{Cluster: testCluster, Service: "api", Template: "testdata/autoscaling.json"})
+	checkError(t, err, "does not exist")
+	if len(autoScaling.targets) != 0 {
+		t.Errorf("registered %v", autoScaling.targets)
+	}
+}
+
+func TestSuspendResumeAutoScaling(t *testing.T) {
+	_, _, c := newAutoScalingTestClient(t)
+	checkError(t, c.ResumeAutoScaling(testCluster, "web"), "has no scalable target")
+	// Suspending a service without a scalable target does nothing
+	restore, err := c.SuspendAutoScaling(testCluster, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	applyTestAutoScaling(t, c, "4")
+	if _, err := c.SuspendAutoScaling(testCluster, "web"); err != nil {
+		t.Fatal(err)
+	}
+	checkSuspended(t, c, true)
+	if err := c.ResumeAutoScaling(testCluster, "web"); err != nil {
+		t.Fatal(err)
+	}
+	checkSuspended(t, c, false)
+}
+
+func TestSuspendAutoScalingRestores(t *testing.T) {
+	_, autoScaling, c := newAutoScalingTestClient(t)
+	applyTestAutoScaling(t, c, "4")
+	// Only scheduled scaling is suspended beforehand
+	target := autoScaling.targets["service/test/web"]
+	target.SuspendedState.ScheduledScalingSuspended = aws.Bool(true)
+	previous := *target.SuspendedState
+
+	restore, err := c.SuspendAutoScaling(testCluster, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(target.SuspendedState, suspendedState(true)) {
+		t.Errorf("suspended state = %v", target.SuspendedState)
+	}
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*target.SuspendedState, previous) {
+		t.Errorf("restored state = %v, want %v", target.SuspendedState, previous)
+	}
+	// The capacity is left as is
+	if aws.Int64Value(target.MinCapacity) != 1 || aws.Int64Value(target.MaxCapacity) != 4 {
+		t.Errorf("capacity = %d to %d", aws.Int64Value(target.MinCapacity), aws.Int64Value(target.MaxCapacity))
+	}
+}
+
+func TestUpgradeSuspendsAutoScaling(t *testing.T) {
+	fake, autoScaling, c := newAutoScalingTestClient(t)
+	applyTestAutoScaling(t, c, "4")
+	// Scaling stays suspended during the upgrade, and is restored even though it failed
+	fake.Behave("test-web:2", ecsfake.Behavior{Unable: true})
+	opts := UpgradeOptions{SuspendAutoScaling: true}
+	opts.Cluster, opts.Service = testCluster, "web"
+	opts.Template, opts.Values = "testdata/web.json", webValues("v2")
+	opts.Timeout = 15
+	_, err := c.Upgrade(opts)
+	checkError(t, err, "unable to place a task")
+	if state := autoScaling.targets["service/test/web"].SuspendedState; isSuspended(state) {
+		t.Errorf("suspended state after the upgrade = %v", state)
+	}
+}
+
+func TestDeleteAutoScaling(t *testing.T) {
+	_, autoScaling, c := newAutoScalingTestClient(t)
+	_, err := c.DeleteAutoScaling(testCluster, "web")
+	checkError(t, err, "has no scalable target")
+
+	applyTestAutoScaling(t, c, "4")
+	result, err := c.DeleteAutoScaling(testCluster, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.DeletedPolicies, []string{"cpu"}) {
+		t.Errorf("DeletedPolicies = %v", result.DeletedPolicies)
+	}
+	if len(autoScaling.targets) != 0 || len(autoScaling.policies) != 0 {
+		t.Errorf("left %v and %v", autoScaling.targets, autoScaling.policies)
+	}
+	if described, err := c.DescribeAutoScaling(testCluster, "web"); err != nil || described != nil {
+		t.Errorf("DescribeAutoScaling = %v, %v", described, err)
+	}
+}
+
+func checkSuspended(t *testing.T, c *Client, want bool) {
+	t.Helper()
+	result, err := c.DescribeAutoScaling(testCluster, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Suspended != want {
+		t.Errorf("Suspended = %v, want %v", result.Suspended, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Package czecs registers task definitions, installs and upgrades services and runs one-off tasks in
+// Amazon ECS. It is the library behind the czecs command, for programs that deploy services without
+// shelling out to the czecs binary.
+package czecs
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Client performs czecs operations using an ECS client.
+type Client struct {
+	// ECS is used for all ECS API calls
+	ECS ecsiface.ECSAPI
+	// EventBridge is used for the rules of scheduled tasks; only schedule operations need it
+	EventBridge eventbridgeiface.EventBridgeAPI
+	// AutoScaling is used for the scalable targets and scaling policies of services; only auto scaling
+	// operations, and upgrades suspending auto scaling, need it
+	AutoScaling applicationautoscalingiface.ApplicationAutoScalingAPI
+	// Region is the region of the ECS client, used in links to the AWS console
+	Region string
+	// Log receives all log messages
+	Log logrus.FieldLogger
+	// Progress receives the progress of deployments and tasks being waited for; nil disables progress output
+	Progress io.Writer
+	// ProgressPrefix is prepended to every line of deployment progress, to tell apart concurrent deployments
+	ProgressPrefix string
+	// WaiterOptions are added to every waiter, e.g. util.DebugSleepProgressWithContext
+	WaiterOptions []request.WaiterOption
+	// Context, if set, aborts all waits once canceled, e.g. when the deployment lock of the service is lost.
+	// Rollbacks are skipped once it is canceled.
+	Context context.Context
+}
+
+// New creates a client using the given ECS client of the given region, logging to the logrus standard logger.
+func New(svc ecsiface.ECSAPI, region string) *Client {
+	return &Client{
+		ECS:    svc,
+		Region: region,
+		Log:    logrus.StandardLogger(),
+	}
+}
+
+// templateValues returns the values available to the given template, after validating them against its values schema.
+func (c *Client) templateValues(template string, v Values) (map[string]interface{}, error) {
+	explanation, err := v.Explain()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Validate(template, explanation.Values); err != nil {
+		return nil, err
+	}
+	for _, change := range explanation.TypeChanges {
+		c.Log.Warnf("Value %s", change)
+	}
+	values := map[string]interface{}{
+		"Values": explanation.Values,
+	}
+	c.Log.Debugf("Values used for template: %#v", values)
+	return values, nil
+}
+
+// context returns the context of waits.
+func (c *Client) context() aws.Context {
+	if c.Context == nil {
+		return aws.BackgroundContext()
+	}
+	return c.Context
+}
+
+// aborted returns why waits are aborted, or nil if they are not.
+func (c *Client) aborted() error {
+	return c.context().Err()
+}
+
+// checkAborted returns an error if waits were aborted, or the error of the wait otherwise. The SDK waiters can
+// mistake a request failing because of a canceled context for success, so their result cannot be trusted then.
+func (c *Client) checkAborted(waitErr error) error {
+	if err := c.aborted(); err != nil {
+		return errors.Wrap(err, "wait aborted")
+	}
+	return waitErr
+}
+
+// waiterOptions returns the given waiter options followed by those of the client.
+func (c *Client) waiterOptions(opts ...request.WaiterOption) []request.WaiterOption {
+	return append(opts, c.WaiterOptions...)
+}
@@ -0,0 +1,205 @@
+package czecs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/pkg/errors"
+)
+
+// InstallOptions configures Install.
+type InstallOptions struct {
+	Cluster string
+	Service string
+	// Template is the file name or URI of the task definition template to register; exactly one of
+	// Template and TaskDefinitionArn must be set
+	Template string
+	// TaskDefinitionArn is an existing task definition to deploy
+	TaskDefinitionArn string
+	Values            Values
+	// Timeout is the number of seconds to wait for the service (and each task) to become stable; 0 waits forever
+	Timeout int
+	// Rollback deletes the service (or returns it to its previous task definition on upgrade) if the deployment fails
+	Rollback bool
+	// CircuitBreaker enables the ECS deployment circuit breaker on the service
+	CircuitBreaker bool
+	// MaxFailedTasks fails the deployment once more than this many tasks failed to start; 0 disables the check
+	MaxFailedTasks int
+	// PreTask is a task template run to completion before deploying the service; the service is not deployed if it fails
+	PreTask string
+	// PostTask is a task template run to completion after the service is stable; the deployment fails if it fails
+	PostTask string
+}
+
+// InstallResult describes the outcome of an install, including a failed one.
+type InstallResult struct {
+	TaskDefinitionArn string `json:"taskDefinitionArn,omitempty"`
+	// DeploymentID is the ID of the deployment creating the service
+	DeploymentID string         `json:"deploymentId,omitempty"`
+	PreTask      *RunTaskResult `json:"preTask,omitempty"`
+	PostTask     *RunTaskResult `json:"postTask,omitempty"`
+	// RolledBack is set if the service was deleted again after the deployment failed
+	RolledBack bool `json:"rolledBack"`
+}
+
+// Install creates a service running the given task definition and waits for it to become stable.
+func (c *Client) Install(opts InstallOptions) (*InstallResult, error) {
+	result := &InstallResult{}
+	existing, err := c.FindService(opts.Cluster, opts.Service)
+	if err != nil {
+		return result, err
+	}
+	if existing != nil {
+		return result, fmt.Errorf("Service %#v already exists in cluster %#v. Use czecs upgrade command to upgrade existing service", opts.Service, opts.Cluster)
+	}
+
+	taskDefnArn, err := c.NewTaskDefinition(opts.Template, opts.Values, opts.TaskDefinitionArn)
+	if err != nil {
+		return result, err
+	}
+	result.TaskDefinitionArn = taskDefnArn
+
+	if opts.PreTask != "" {
+		if result.PreTask, err = c.runDeploymentTask(opts, opts.PreTask, taskDefnArn); err != nil {
+			if opts.Rollback && opts.Template != "" {
+				c.DeregisterTaskDefinition(taskDefnArn, "new")
+			}
+			return result, errors.Wrap(err, "pre-deploy task failed; service not created")
+		}
+	}
+
+	result.DeploymentID, err = c.deployInstall(opts, taskDefnArn)
+	if err == nil && opts.PostTask != "" {
+		if result.PostTask, err = c.runDeploymentTask(opts, opts.PostTask, taskDefnArn); err != nil {
+			err = errors.Wrap(err, "post-deploy task failed")
+		}
+	}
+	if err != nil && opts.Rollback {
+		if abortErr := c.aborted(); abortErr != nil {
+			c.Log.Warnf("Not rolling back service creation of %#v, since the install was aborted: %s", opts.Service, abortErr)
+			return result, err
+		}
+		c.Log.Warnf("Rolling back service creation of %#v by deleting it", opts.Service)
+		rollbackErr := c.rollbackInstall(opts)
+		if rollbackErr != nil {
+			return result, errors.Wrap(rollbackErr, "cannot rollback install")
+		}
+		result.RolledBack = true
+		c.DeregisterTaskDefinition(taskDefnArn, "new")
+	}
+	return result, err
+}
+
+// runDeploymentTask runs a one-off task from the given task template as part of deploying the service,
+// using the same values as the service's task definition. Unless the template sets them, the task runs in
+// the service's cluster using the task definition being deployed.
+func (c *Client) runDeploymentTask(opts InstallOptions, taskTemplate string, taskDefnArn string) (*RunTaskResult, error) {
+	runTaskInput, err := c.RenderTask(taskTemplate, opts.Values)
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(runTaskInput.Cluster) == "" {
+		runTaskInput.Cluster = &opts.Cluster
+	}
+	if aws.StringValue(runTaskInput.TaskDefinition) == "" {
+		runTaskInput.TaskDefinition = &taskDefnArn
+	}
+	return c.runTaskDefinition(runTaskInput, opts.Timeout)
+}
+
+// deploymentWaiterOptions returns the options of a waiter for the given deployment of a service to become stable.
+func (c *Client) deploymentWaiterOptions(opts InstallOptions, deploymentID string, createdAt time.Time) []request.WaiterOption {
+	return c.stableWaiterOptions(opts.Timeout, createdAt, util.GetFailOnRolloutContext(deploymentID, createdAt, opts.MaxFailedTasks))
+}
+
+// stableWaiterOptions returns the options of waiting up to timeout seconds for a service changed at changedAt to
+// become stable, failing early with failOn, and reporting progress if enabled.
+func (c *Client) stableWaiterOptions(timeout int, changedAt time.Time, failOn request.WaiterOption) []request.WaiterOption {
+	waiterOptions := append(util.WaiterDelay(timeout, 15), failOn)
+	if c.Progress != nil {
+		progress := util.NewDeploymentProgress(c.Progress, changedAt)
+		if c.ProgressPrefix != "" {
+			progress.WithPrefix(c.ProgressPrefix)
+		}
+		waiterOptions = append(waiterOptions, progress.WaiterOption)
+	}
+	return c.waiterOptions(waiterOptions...)
+}
+
+// CreateServiceInput returns the input of the CreateService call creating the service of Install with the given task definition.
+func CreateServiceInput(opts InstallOptions, taskDefinition string) *ecs.CreateServiceInput {
+	createServiceInput := &ecs.CreateServiceInput{
+		Cluster:        &opts.Cluster,
+		ServiceName:    &opts.Service,
+		TaskDefinition: &taskDefinition,
+	}
+	if opts.CircuitBreaker {
+		createServiceInput.DeploymentConfiguration = &ecs.DeploymentConfiguration{
+			DeploymentCircuitBreaker: &ecs.DeploymentCircuitBreaker{
+				Enable:   aws.Bool(true),
+				Rollback: aws.Bool(true),
+			},
+		}
+	}
+	return createServiceInput
+}
+
+// deployInstall creates the service and waits for it to become stable, returning the ID of its deployment.
+func (c *Client) deployInstall(opts InstallOptions, taskDefnArn string) (string, error) {
+	c.Log.Infof("Creating service %#v in cluster %#v with task definition %#v", opts.Service, opts.Cluster, taskDefnArn)
+	c.Log.Infof("Service info location: https://%s.console.aws.amazon.com/ecs/home?region=%s#/clusters/%s/services/%s/details", c.Region, c.Region, opts.Cluster, opts.Service)
+
+	createServiceInput := CreateServiceInput(opts, taskDefnArn)
+
+	// Get the primary deployment's updated date, default to now if missing
+	createdAt := time.Now()
+	var deploymentID string
+	createServiceOutput, err := c.ECS.CreateService(createServiceInput)
+	if err != nil {
+		// TODO(mbarrien) Avoid rollback?
+		return "", err
+	}
+	for _, deployment := range createServiceOutput.Service.Deployments {
+		if *deployment.Status == "PRIMARY" {
+			createdAt = *deployment.CreatedAt
+			deploymentID = aws.StringValue(deployment.Id)
+			break
+		}
+	}
+
+	c.Log.Infof("Waiting for service %#v in cluster %#v with task definition %#v to be stable", opts.Service, opts.Cluster, taskDefnArn)
+
+	return deploymentID, c.checkAborted(c.ECS.WaitUntilServicesStableWithContext(
+		c.context(),
+		&ecs.DescribeServicesInput{
+			Cluster:  &opts.Cluster,
+			Services: []*string{createServiceOutput.Service.ServiceArn}},
+		c.deploymentWaiterOptions(opts, deploymentID, createdAt)...))
+}
+
+func (c *Client) rollbackInstall(opts InstallOptions) error {
+	// Forced, since a service cannot be deleted while it is scaled above 0 otherwise
+	deleteServiceOutput, err := c.ECS.DeleteService(&ecs.DeleteServiceInput{
+		Cluster: &opts.Cluster,
+		Service: &opts.Service,
+		Force:   aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	waiterOptions := util.WaiterDelay(opts.Timeout, 15)
+	if c.Progress != nil {
+		waiterOptions = append(waiterOptions, util.SleepProgress(c.Progress))
+	}
+	return c.checkAborted(c.ECS.WaitUntilServicesInactiveWithContext(
+		c.context(),
+		&ecs.DescribeServicesInput{
+			Cluster:  &opts.Cluster,
+			Services: []*string{deleteServiceOutput.Service.ServiceArn}},
+		c.waiterOptions(waiterOptions...)...))
+}
@@ -0,0 +1,164 @@
+package czecs
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+	"github.com/sirupsen/logrus"
+)
+
+const testCluster = "test"
+
+// newTestClient returns a client of the fake that does not log or wait between attempts of waiters.
+func newTestClient(fake *ecsfake.ECS) *Client {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	c := New(fake, ecsfake.Region)
+	c.Log = log
+	c.WaiterOptions = fake.WaiterOptions()
+	return c
+}
+
+// webValues returns the values of testdata/web.json with the given image tag.
+func webValues(tag string) Values {
+	return Values{Set: []string{"tag=" + tag}}
+}
+
+// testService describes a service of the fake, or returns nil if it does not exist.
+func testService(t *testing.T, fake *ecsfake.ECS, name string) *ecs.Service {
+	t.Helper()
+	output, err := fake.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  aws.String(testCluster),
+		Services: []*string{&name},
+	})
+	if err != nil {
+		t.Fatalf("DescribeServices: %s", err)
+	}
+	if len(output.Services) == 0 {
+		return nil
+	}
+	return output.Services[0]
+}
+
+// taskDefinitionStatus returns the status of a task definition of the fake, e.g. ACTIVE.
+func taskDefinitionStatus(t *testing.T, fake *ecsfake.ECS, taskDefinition string) string {
+	t.Helper()
+	output, err := fake.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &taskDefinition})
+	if err != nil {
+		t.Fatalf("DescribeTaskDefinition %s: %s", taskDefinition, err)
+	}
+	return aws.StringValue(output.TaskDefinition.Status)
+}
+
+// checkError fails the test unless err contains want, or is nil if want is empty.
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %s", err)
+	case want != "" && err == nil:
+		t.Fatalf("expected an error containing %#v", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("expected an error containing %#v, got %#v", want, err.Error())
+	}
+}
+
+func TestInstall(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior ecsfake.Behavior
+		opts     InstallOptions
+
+		wantErr        string
+		wantRolledBack bool
+		// wantStatus is the status of the service afterwards, or empty if it should not exist
+		wantStatus string
+		// wantTaskDefinition is the status of the new task definition afterwards
+		wantTaskDefinition string
+	}{
+		{
+			name:               "success",
+			wantStatus:         "ACTIVE",
+			wantTaskDefinition: ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "unable to place tasks, rolled back",
+			behavior:           ecsfake.Behavior{Unable: true},
+			opts:               InstallOptions{Rollback: true},
+			wantErr:            "unable to place a task",
+			wantRolledBack:     true,
+			wantTaskDefinition: ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:               "circuit breaker failed",
+			behavior:           ecsfake.Behavior{Unable: true},
+			opts:               InstallOptions{CircuitBreaker: true},
+			wantErr:            "ECS deployment circuit breaker",
+			wantStatus:         "ACTIVE",
+			wantTaskDefinition: ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "timeout",
+			behavior:           ecsfake.Behavior{Steps: 10},
+			wantErr:            "exceeded wait attempts",
+			wantStatus:         "ACTIVE",
+			wantTaskDefinition: ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "timeout, rolled back",
+			behavior:           ecsfake.Behavior{Steps: 10},
+			opts:               InstallOptions{Rollback: true},
+			wantErr:            "exceeded wait attempts",
+			wantRolledBack:     true,
+			wantTaskDefinition: ecs.TaskDefinitionStatusInactive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			fake.Behave("test-web", test.behavior)
+			opts := test.opts
+			opts.Cluster, opts.Service = testCluster, "web"
+			opts.Template, opts.Values = "testdata/web.json", webValues("v1")
+			opts.Timeout = 15
+
+			result, err := newTestClient(fake).Install(opts)
+			checkError(t, err, test.wantErr)
+			if result.RolledBack != test.wantRolledBack {
+				t.Errorf("RolledBack = %v, want %v", result.RolledBack, test.wantRolledBack)
+			}
+			if result.TaskDefinitionArn != fakeTaskDefinitionArn("test-web:1") {
+				t.Errorf("TaskDefinitionArn = %#v", result.TaskDefinitionArn)
+			}
+			status := ""
+			if service := testService(t, fake, "web"); service != nil && aws.StringValue(service.Status) != ServiceInactive {
+				status = aws.StringValue(service.Status)
+			}
+			if status != test.wantStatus {
+				t.Errorf("service status = %#v, want %#v", status, test.wantStatus)
+			}
+			if status := taskDefinitionStatus(t, fake, "test-web:1"); status != test.wantTaskDefinition {
+				t.Errorf("task definition status = %#v, want %#v", status, test.wantTaskDefinition)
+			}
+		})
+	}
+}
+
+func TestInstallExistingService(t *testing.T) {
+	fake := ecsfake.New(testCluster)
+	c := newTestClient(fake)
+	opts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+	if _, err := c.Install(opts); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Install(opts)
+	checkError(t, err, "already exists")
+}
+
+func fakeTaskDefinitionArn(familyRevision string) string {
+	return "arn:aws:ecs:" + ecsfake.Region + ":" + ecsfake.Account + ":task-definition/" + familyRevision
+}
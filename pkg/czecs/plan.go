@@ -0,0 +1,337 @@
+package czecs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/pkg/errors"
+)
+
+// Plan actions, in the order they are taken.
+const (
+	PlanRegisterTaskDefinition   = "register-task-definition"
+	PlanReuseTaskDefinition      = "reuse-task-definition"
+	PlanSuspendAutoScaling       = "suspend-auto-scaling"
+	PlanRunTask                  = "run-task"
+	PlanCreateService            = "create-service"
+	PlanUpdateService            = "update-service"
+	PlanDeregisterTaskDefinition = "deregister-task-definition"
+	PlanRestoreAutoScaling       = "restore-auto-scaling"
+)
+
+// Plan lists the API actions an install or upgrade would take, found out using only reads.
+type Plan struct {
+	Cluster string `json:"cluster"`
+	Service string `json:"service"`
+	// Action is ActionInstall or ActionUpgrade
+	Action  string       `json:"action"`
+	Actions []PlanAction `json:"actions"`
+}
+
+// PlanAction is one API action of a plan.
+type PlanAction struct {
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	// Changes are the fields changed by the action, compared to what is currently deployed
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Change is a changed field, e.g. "containerDefinitions[0].image". Old and New are JSON; an empty Old means
+// the field is added, and an empty New that it is removed.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("%s: added %s", c.Field, c.New)
+	case c.New == "":
+		return fmt.Sprintf("%s: removed %s", c.Field, c.Old)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+func (p *Plan) add(action string, description string, changes ...Change) {
+	p.Actions = append(p.Actions, PlanAction{Action: action, Description: description, Changes: changes})
+}
+
+// PlanInstall returns the actions Install would take, without changing anything.
+func (c *Client) PlanInstall(opts InstallOptions) (*Plan, error) {
+	existing, err := c.FindService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Service %#v already exists in cluster %#v. Use czecs upgrade command to upgrade existing service", opts.Service, opts.Cluster)
+	}
+	return c.planInstall(opts)
+}
+
+func (c *Client) planInstall(opts InstallOptions) (*Plan, error) {
+	plan := &Plan{Cluster: opts.Cluster, Service: opts.Service, Action: ActionInstall}
+	taskDefinition, err := c.planTaskDefinition(plan, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.planTask(plan, opts, opts.PreTask, "pre-deploy"); err != nil {
+		return nil, err
+	}
+	createServiceInput, err := util.MarshalAPIJSON(CreateServiceInput(opts, taskDefinition))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot format service")
+	}
+	plan.add(PlanCreateService, fmt.Sprintf("create service %#v in cluster %#v and wait for it to become stable", opts.Service, opts.Cluster),
+		diff(nil, createServiceInput)...)
+	if err := c.planTask(plan, opts, opts.PostTask, "post-deploy"); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanUpgrade returns the actions Upgrade would take, without changing anything.
+func (c *Client) PlanUpgrade(opts UpgradeOptions) (*Plan, error) {
+	service, err := c.DescribeService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	return c.planUpgrade(opts, service)
+}
+
+func (c *Client) planUpgrade(opts UpgradeOptions, service *ecs.Service) (*Plan, error) {
+	plan := &Plan{Cluster: opts.Cluster, Service: opts.Service, Action: ActionUpgrade}
+	if opts.SuspendAutoScaling {
+		plan.add(PlanSuspendAutoScaling, fmt.Sprintf("suspend the auto scaling of service %#v, if it has a scalable target", opts.Service))
+	}
+	oldTaskDefinition := aws.StringValue(service.TaskDefinition)
+	taskDefinition, err := c.planTaskDefinition(plan, opts.InstallOptions, &oldTaskDefinition)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.planTask(plan, opts.InstallOptions, opts.PreTask, "pre-deploy"); err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	if taskDefinition != oldTaskDefinition {
+		changes = append(changes, Change{Field: "taskDefinition", Old: jsonString(oldTaskDefinition), New: jsonString(taskDefinition)})
+	}
+	if opts.CircuitBreaker {
+		circuitBreaker := &ecs.DeploymentCircuitBreaker{}
+		if service.DeploymentConfiguration != nil && service.DeploymentConfiguration.DeploymentCircuitBreaker != nil {
+			circuitBreaker = service.DeploymentConfiguration.DeploymentCircuitBreaker
+		}
+		if !aws.BoolValue(circuitBreaker.Enable) || !aws.BoolValue(circuitBreaker.Rollback) {
+			changes = append(changes, Change{
+				Field: "deploymentConfiguration.deploymentCircuitBreaker",
+				Old:   fmt.Sprintf(`{"enable":%t,"rollback":%t}`, aws.BoolValue(circuitBreaker.Enable), aws.BoolValue(circuitBreaker.Rollback)),
+				New:   `{"enable":true,"rollback":true}`,
+			})
+		}
+	}
+	plan.add(PlanUpdateService, fmt.Sprintf("update service %#v in cluster %#v and wait for it to become stable", opts.Service, opts.Cluster), changes...)
+
+	if err := c.planTask(plan, opts.InstallOptions, opts.PostTask, "post-deploy"); err != nil {
+		return nil, err
+	}
+	if opts.Deregister && taskDefinition != oldTaskDefinition {
+		plan.add(PlanDeregisterTaskDefinition, fmt.Sprintf("deregister old task definition %#v", oldTaskDefinition))
+	}
+	if opts.SuspendAutoScaling {
+		plan.add(PlanRestoreAutoScaling, fmt.Sprintf("restore the auto scaling of service %#v", opts.Service))
+	}
+	return plan, nil
+}
+
+// PlanApply returns the actions Apply would take, without changing anything.
+func (c *Client) PlanApply(opts UpgradeOptions) (*Plan, error) {
+	existing, err := c.FindService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return c.planInstall(opts.InstallOptions)
+	}
+	return c.planUpgrade(opts, existing)
+}
+
+// planTaskDefinition adds the registration of the task definition (or the reuse of an existing one) to the plan,
+// with the changes from the old task definition, if any. Returns the task definition the service would use:
+// the given ARN, or for a new registration, its family followed by ":<new revision>".
+func (c *Client) planTaskDefinition(plan *Plan, opts InstallOptions, oldTaskDefinition *string) (string, error) {
+	if (opts.Template != "") == (opts.TaskDefinitionArn != "") {
+		return "", fmt.Errorf("exactly one of a task definition template or a task definition ARN must be provided")
+	}
+
+	var old json.RawMessage
+	if oldTaskDefinition != nil {
+		describeTaskDefinitionOutput, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: oldTaskDefinition})
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot retrieve task definition %#v", *oldTaskDefinition)
+		}
+		if old, err = registrationJSON(describeTaskDefinitionOutput.TaskDefinition); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.Template == "" {
+		describeTaskDefinitionOutput, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &opts.TaskDefinitionArn})
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot retrieve task definition %#v", opts.TaskDefinitionArn)
+		}
+		reused, err := registrationJSON(describeTaskDefinitionOutput.TaskDefinition)
+		if err != nil {
+			return "", err
+		}
+		var changes []Change
+		if old != nil {
+			changes = diff(old, reused)
+		}
+		plan.add(PlanReuseTaskDefinition, fmt.Sprintf("use existing task definition %#v", opts.TaskDefinitionArn), changes...)
+		return opts.TaskDefinitionArn, nil
+	}
+
+	registerTaskDefinitionInput, err := c.RenderTaskDefinition(opts.Template, opts.Values)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := util.MarshalAPIJSON(registerTaskDefinitionInput)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot format task definition")
+	}
+	family := aws.StringValue(registerTaskDefinitionInput.Family)
+	newRevision := family + ":<new revision>"
+	description := fmt.Sprintf("register a new revision of task definition family %#v", family)
+	if old == nil {
+		// Nothing to compare a new service's task definition with
+		plan.add(PlanRegisterTaskDefinition, description)
+		return newRevision, nil
+	}
+	changes := diff(old, rendered)
+	if len(changes) == 0 {
+		description += ", identical to the current one"
+	}
+	plan.add(PlanRegisterTaskDefinition, description, changes...)
+	return newRevision, nil
+}
+
+// planTask adds running a pre or post deploy task to the plan, rendering its template to check it.
+func (c *Client) planTask(plan *Plan, opts InstallOptions, taskTemplate string, which string) error {
+	if taskTemplate == "" {
+		return nil
+	}
+	if _, err := c.RenderTask(taskTemplate, opts.Values); err != nil {
+		return errors.Wrapf(err, "%s task", which)
+	}
+	plan.add(PlanRunTask, fmt.Sprintf("run %s task %#v to completion", which, taskTemplate))
+	return nil
+}
+
+// registrationJSON returns the fields of a registered task definition that can be given when registering it.
+func registrationJSON(taskDefinition *ecs.TaskDefinition) (json.RawMessage, error) {
+	raw, err := json.Marshal(taskDefinition)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot format task definition")
+	}
+	var registerTaskDefinitionInput ecs.RegisterTaskDefinitionInput
+	if err := json.Unmarshal(raw, &registerTaskDefinitionInput); err != nil {
+		return nil, errors.Wrap(err, "cannot format task definition")
+	}
+	return util.MarshalAPIJSON(&registerTaskDefinitionInput)
+}
+
+// diff returns the changed fields between two JSON documents, sorted by field.
+func diff(old json.RawMessage, new json.RawMessage) []Change {
+	oldFields, newFields := map[string]interface{}{}, map[string]interface{}{}
+	flattenJSON(old, oldFields)
+	flattenJSON(new, newFields)
+
+	var changes []Change
+	for field, newValue := range newFields {
+		oldValue, ok := oldFields[field]
+		if !ok {
+			changes = append(changes, Change{Field: field, New: jsonString(newValue)})
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, Change{Field: field, Old: jsonString(oldValue), New: jsonString(newValue)})
+		}
+	}
+	for field, oldValue := range oldFields {
+		if _, ok := newFields[field]; !ok && !registrationDefault(field, oldValue, oldFields) {
+			changes = append(changes, Change{Field: field, Old: jsonString(oldValue)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// registrationDefaults are the values ECS fills in when registering a task definition that leaves these fields
+// out, by field with the indexes of arrays left out.
+var registrationDefaults = map[string]interface{}{
+	"networkMode":                                    ecs.NetworkModeBridge,
+	"containerDefinitions[].cpu":                     float64(0),
+	"containerDefinitions[].essential":               true,
+	"containerDefinitions[].portMappings[].protocol": ecs.TransportProtocolTcp,
+}
+
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// registrationDefault returns whether a field of a registered task definition was filled in by ECS, so that it
+// is not a change if the new task definition leaves it out. fields are the fields of the registered one.
+func registrationDefault(field string, value interface{}, fields map[string]interface{}) bool {
+	unindexed := arrayIndex.ReplaceAllString(field, "[]")
+	if defaultValue, ok := registrationDefaults[unindexed]; ok {
+		return reflect.DeepEqual(value, defaultValue)
+	}
+	// The host port is 0 (dynamic) in bridge mode, and the container port in other network modes
+	if unindexed == "containerDefinitions[].portMappings[].hostPort" {
+		return reflect.DeepEqual(value, float64(0)) || reflect.DeepEqual(value, fields[strings.TrimSuffix(field, "hostPort")+"containerPort"])
+	}
+	return false
+}
+
+// flattenJSON adds every value of a JSON document that is not an object or array to fields, by path.
+func flattenJSON(raw json.RawMessage, fields map[string]interface{}) {
+	var document interface{}
+	if raw == nil {
+		return
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return
+	}
+	flatten(document, "", fields)
+}
+
+func flatten(value interface{}, path string, fields map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			flatten(nested, strings.TrimPrefix(path+"."+key, "."), fields)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			flatten(nested, fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	default:
+		fields[path] = value
+	}
+}
+
+func jsonString(value interface{}) string {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
@@ -0,0 +1,133 @@
+package czecs
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []Change
+	}{
+		{
+			name: "identical",
+			old:  `{"family": "web", "containerDefinitions": [{"name": "web", "image": "web:v1"}]}`,
+			new:  `{"family": "web", "containerDefinitions": [{"name": "web", "image": "web:v1"}]}`,
+		},
+		{
+			name: "changed, added and removed",
+			old:  `{"containerDefinitions": [{"image": "web:v1", "memory": 128}]}`,
+			new:  `{"containerDefinitions": [{"image": "web:v2", "cpu": 256}]}`,
+			want: []Change{
+				{Field: "containerDefinitions[0].cpu", New: "256"},
+				{Field: "containerDefinitions[0].image", Old: `"web:v1"`, New: `"web:v2"`},
+				{Field: "containerDefinitions[0].memory", Old: "128"},
+			},
+		},
+		{
+			name: "defaults filled in by ECS",
+			old: `{"networkMode": "bridge", "containerDefinitions": [
+				{"image": "web:v1", "cpu": 0, "essential": true, "mountPoints": [], "volumesFrom": [],
+				 "portMappings": [{"containerPort": 80, "hostPort": 0, "protocol": "tcp"}]},
+				{"image": "proxy:v1", "portMappings": [{"containerPort": 443, "hostPort": 443, "protocol": "tcp"}]}]}`,
+			new: `{"containerDefinitions": [
+				{"image": "web:v1", "portMappings": [{"containerPort": 80}]},
+				{"image": "proxy:v1", "portMappings": [{"containerPort": 443}]}]}`,
+		},
+		{
+			name: "values other than the defaults removed",
+			old: `{"networkMode": "awsvpc", "containerDefinitions": [
+				{"cpu": 128, "essential": false, "portMappings": [{"containerPort": 80, "hostPort": 8080, "protocol": "udp"}]}]}`,
+			new: `{"containerDefinitions": [{"portMappings": [{"containerPort": 80}]}]}`,
+			want: []Change{
+				{Field: "containerDefinitions[0].cpu", Old: "128"},
+				{Field: "containerDefinitions[0].essential", Old: "false"},
+				{Field: "containerDefinitions[0].portMappings[0].hostPort", Old: "8080"},
+				{Field: "containerDefinitions[0].portMappings[0].protocol", Old: `"udp"`},
+				{Field: "networkMode", Old: `"awsvpc"`},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := diff(json.RawMessage(test.old), json.RawMessage(test.new))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("diff = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		opts UpgradeOptions
+
+		wantActions []string
+		// wantDescription is part of the description of registering the task definition
+		wantDescription string
+		wantChanges     []Change
+	}{
+		{
+			name:            "identical",
+			tag:             "v1",
+			wantActions:     []string{PlanRegisterTaskDefinition, PlanUpdateService},
+			wantDescription: "identical to the current one",
+		},
+		{
+			name:        "new image",
+			tag:         "v2",
+			opts:        UpgradeOptions{Deregister: true, SuspendAutoScaling: true},
+			wantActions: []string{PlanSuspendAutoScaling, PlanRegisterTaskDefinition, PlanUpdateService, PlanDeregisterTaskDefinition, PlanRestoreAutoScaling},
+			wantChanges: []Change{{Field: "containerDefinitions[0].image", Old: `"example/web:v1"`, New: `"example/web:v2"`}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			c := newTestClient(fake)
+			installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+			if _, err := c.Install(installOpts); err != nil {
+				t.Fatal(err)
+			}
+			installCalls := len(fake.Calls())
+
+			opts := test.opts
+			opts.Cluster, opts.Service = testCluster, "web"
+			opts.Template, opts.Values = "testdata/web.json", webValues(test.tag)
+			plan, err := c.PlanUpgrade(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, action := range plan.Actions {
+				actions = append(actions, action.Action)
+				if action.Action != PlanRegisterTaskDefinition {
+					continue
+				}
+				if !strings.Contains(action.Description, test.wantDescription) {
+					t.Errorf("description = %#v, want it to contain %#v", action.Description, test.wantDescription)
+				}
+				if !reflect.DeepEqual(action.Changes, test.wantChanges) {
+					t.Errorf("changes = %v, want %v", action.Changes, test.wantChanges)
+				}
+			}
+			if !reflect.DeepEqual(actions, test.wantActions) {
+				t.Errorf("actions = %v, want %v", actions, test.wantActions)
+			}
+			for _, call := range fake.Calls()[installCalls:] {
+				if !strings.HasPrefix(call, "Describe") {
+					t.Errorf("planning called %s", call)
+				}
+			}
+		})
+	}
+}
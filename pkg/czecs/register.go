@@ -0,0 +1,87 @@
+package czecs
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/pkg/errors"
+)
+
+// RegisterOptions configures Register.
+type RegisterOptions struct {
+	// Template is the file name or URI of the task definition template
+	Template string
+	Values   Values
+}
+
+// RenderTaskDefinition renders the task definition template with the given values, adding their sidecars.
+func (c *Client) RenderTaskDefinition(template string, values Values) (*ecs.RegisterTaskDefinitionInput, error) {
+	// A template from git is rendered from a checkout, so that its values schema and sidecars are found next to it
+	template, err := tasks.Resolve(template)
+	if err != nil {
+		return nil, err
+	}
+	templateValues, err := c.templateValues(template, values)
+	if err != nil {
+		return nil, err
+	}
+	registerTaskDefinitionInput, err := tasks.ParseTaskDefinition(template, templateValues, values.Strict, values.Partials)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse task definition")
+	}
+	if err := c.addSidecars(registerTaskDefinitionInput, template, values, templateValues); err != nil {
+		return nil, err
+	}
+	return registerTaskDefinitionInput, nil
+}
+
+// Register renders the task definition template and registers it. Returns the ARN of the new task definition.
+func (c *Client) Register(opts RegisterOptions) (string, error) {
+	registerTaskDefinitionInput, err := c.RenderTaskDefinition(opts.Template, opts.Values)
+	if err != nil {
+		return "", err
+	}
+
+	c.Log.Debugf("Task definition: %+v", registerTaskDefinitionInput)
+	registerTaskDefinitionOutput, err := c.ECS.RegisterTaskDefinition(registerTaskDefinitionInput)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot register task definition")
+	}
+	taskDefn := registerTaskDefinitionOutput.TaskDefinition
+	c.Log.Infof("Successfully registered task definition %#v", *taskDefn.TaskDefinitionArn)
+	return *taskDefn.TaskDefinitionArn, nil
+}
+
+// NewTaskDefinition registers the task definition from the given template, or if the template is empty,
+// verifies the given task definition exists. Returns the ARN of the task definition.
+func (c *Client) NewTaskDefinition(template string, values Values, taskDefinitionArn string) (string, error) {
+	if (template != "") == (taskDefinitionArn != "") {
+		return "", fmt.Errorf("exactly one of a task definition template or a task definition ARN must be provided")
+	}
+	if template != "" {
+		return c.Register(RegisterOptions{Template: template, Values: values})
+	}
+	// Verify task definition exists
+	_, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: &taskDefinitionArn,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot retrieve task definition %#v", taskDefinitionArn)
+	}
+	return taskDefinitionArn, nil
+}
+
+// DeregisterTaskDefinition deregisters a task definition no longer in use. Errors are only logged, since
+// they are not fatal; which describes the task definition ("old" or "new") in the log message.
+func (c *Client) DeregisterTaskDefinition(taskDefnArn string, which string) {
+	c.Log.Debugf("Deregistering %s task definition %#v", which, taskDefnArn)
+	_, err := c.ECS.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
+		TaskDefinition: &taskDefnArn,
+	})
+	if err != nil {
+		c.Log.Warnf("Error deregistering task definition: %#v", err.Error())
+		c.Log.Warnf("You will have to manually deregister the %s task. Using AWS CLI you can run 'aws ecs deregister-task-definition --task-definition %s'", which, taskDefnArn)
+		// Intentionally swallow error; this isn't fatal
+	}
+}
@@ -0,0 +1,102 @@
+package czecs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// RestartOptions configures Restart.
+type RestartOptions struct {
+	Cluster string
+	Service string
+	// Timeout is the number of seconds to wait for the service to become stable; 0 waits forever
+	Timeout int
+	// MaxFailedTasks fails the restart once more than this many tasks failed to start; 0 disables the check
+	MaxFailedTasks int
+}
+
+// RestartResult describes the outcome of restarting a service, including a failed one.
+type RestartResult struct {
+	Cluster      string `json:"cluster"`
+	Service      string `json:"service"`
+	DeploymentID string `json:"deploymentId,omitempty"`
+	// OldTaskIDs are the IDs of the tasks of the service running before the restart
+	OldTaskIDs []string `json:"oldTaskIds"`
+	// NewTaskIDs are the IDs of the tasks of the service running once it was stable, or when it failed to
+	// become stable, that were not running before the restart
+	NewTaskIDs []string `json:"newTaskIds"`
+}
+
+// Restart replaces every task of a service by starting a new deployment of its current task definition, e.g.
+// to pick up rotated secrets, and waits for the service to become stable. The result is returned even if
+// the restart failed.
+func (c *Client) Restart(opts RestartOptions) (*RestartResult, error) {
+	result := &RestartResult{Cluster: opts.Cluster, Service: opts.Service, OldTaskIDs: []string{}, NewTaskIDs: []string{}}
+	if _, err := c.DescribeService(opts.Cluster, opts.Service); err != nil {
+		return result, err
+	}
+	oldTaskIDs, err := c.serviceTaskIDs(opts.Cluster, opts.Service)
+	if err != nil {
+		return result, err
+	}
+	result.OldTaskIDs = oldTaskIDs
+
+	c.Log.Infof("Restarting the %d tasks of service %#v in cluster %#v", len(oldTaskIDs), opts.Service, opts.Cluster)
+	c.Log.Infof("Service info location: https://%s.console.aws.amazon.com/ecs/home?region=%s#/clusters/%s/services/%s/details", c.Region, c.Region, opts.Cluster, opts.Service)
+	result.DeploymentID, err = c.deployUpdate(
+		InstallOptions{Cluster: opts.Cluster, Service: opts.Service, Timeout: opts.Timeout, MaxFailedTasks: opts.MaxFailedTasks},
+		&ecs.UpdateServiceInput{
+			Cluster:            &opts.Cluster,
+			Service:            &opts.Service,
+			ForceNewDeployment: aws.Bool(true),
+		})
+	if err != nil && result.DeploymentID == "" {
+		return result, errors.Wrapf(err, "cannot restart service %#v", opts.Service)
+	}
+
+	// Report the new tasks even if the restart failed, since some old tasks may already be replaced
+	taskIDs, listErr := c.serviceTaskIDs(opts.Cluster, opts.Service)
+	if listErr != nil {
+		c.Log.Warnf("Cannot list the new tasks of service %#v: %s", opts.Service, listErr)
+	}
+	old := map[string]bool{}
+	for _, id := range oldTaskIDs {
+		old[id] = true
+	}
+	for _, id := range taskIDs {
+		if !old[id] {
+			result.NewTaskIDs = append(result.NewTaskIDs, id)
+		}
+	}
+	return result, err
+}
+
+// serviceTaskIDs returns the sorted IDs of the running tasks of a service.
+func (c *Client) serviceTaskIDs(cluster string, service string) ([]string, error) {
+	ids := []string{}
+	input := &ecs.ListTasksInput{
+		Cluster:       &cluster,
+		ServiceName:   &service,
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}
+	for {
+		output, err := c.ECS.ListTasks(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list the tasks of service %#v", service)
+		}
+		for _, taskArn := range output.TaskArns {
+			slashSplit := strings.Split(aws.StringValue(taskArn), "/")
+			ids = append(ids, slashSplit[len(slashSplit)-1])
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
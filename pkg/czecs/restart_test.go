@@ -0,0 +1,48 @@
+package czecs
+
+import (
+	"testing"
+
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestRestart(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		behavior ecsfake.Behavior
+
+		wantErr string
+		// wantReplaced is whether the old tasks were replaced by new ones
+		wantReplaced bool
+	}{
+		{name: "success", service: "web", wantReplaced: true},
+		{name: "missing service", service: "api", wantErr: "does not exist"},
+		{name: "timeout", service: "web", behavior: ecsfake.Behavior{Steps: 10}, wantErr: "exceeded wait attempts"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			c := newTestClient(fake)
+			installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+			if _, err := c.Install(installOpts); err != nil {
+				t.Fatal(err)
+			}
+			fake.Behave("test-web:1", test.behavior)
+
+			result, err := c.Restart(RestartOptions{Cluster: testCluster, Service: test.service, Timeout: 15})
+			checkError(t, err, test.wantErr)
+			// The result is returned even if the restart failed
+			if result == nil {
+				t.Fatal("expected a result")
+			}
+			if result.Cluster != testCluster || result.Service != test.service {
+				t.Errorf("Cluster = %#v, Service = %#v", result.Cluster, result.Service)
+			}
+			replaced := len(result.OldTaskIDs) > 0 && len(result.NewTaskIDs) == len(result.OldTaskIDs)
+			if replaced != test.wantReplaced {
+				t.Errorf("OldTaskIDs = %v, NewTaskIDs = %v, want replaced %v", result.OldTaskIDs, result.NewTaskIDs, test.wantReplaced)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package czecs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/pkg/errors"
+)
+
+// ScaleOptions configures Scale.
+type ScaleOptions struct {
+	Cluster string
+	Service string
+	// Count is the new desired count of the service
+	Count int64
+	// Timeout is the number of seconds to wait for the service to become stable; 0 waits forever
+	Timeout int
+}
+
+// ScaleResult describes the outcome of scaling a service, including a failed one.
+type ScaleResult struct {
+	// OldCount is the desired count of the service before scaling it
+	OldCount     int64 `json:"oldCount"`
+	DesiredCount int64 `json:"desiredCount"`
+	// RunningCount is the number of running tasks once the service was stable, or when it failed to become stable
+	RunningCount int64 `json:"runningCount"`
+}
+
+// Scale updates the desired count of a service and waits for it to become stable. If the service has a scalable
+// target in Application Auto Scaling that the client can read, a count outside its capacity is warned about,
+// since auto scaling brings the count back within it.
+func (c *Client) Scale(opts ScaleOptions) (*ScaleResult, error) {
+	service, err := c.DescribeService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	result := &ScaleResult{OldCount: aws.Int64Value(service.DesiredCount), DesiredCount: opts.Count}
+	if c.AutoScaling != nil {
+		// The check is only a warning, so it is skipped if auto scaling cannot be read, e.g. for lack of permissions
+		target, err := c.scalableTarget(AutoScalingResourceID(opts.Cluster, opts.Service))
+		if err != nil {
+			c.Log.Debugf("Not checking the auto scaling capacity: %s", err)
+		}
+		if target != nil && (opts.Count < aws.Int64Value(target.MinCapacity) || opts.Count > aws.Int64Value(target.MaxCapacity)) {
+			c.Log.Warnf("Desired count %d is outside the auto scaling capacity of %d to %d of service %#v; auto scaling will change it",
+				opts.Count, aws.Int64Value(target.MinCapacity), aws.Int64Value(target.MaxCapacity), opts.Service)
+		}
+	}
+
+	c.Log.Infof("Scaling service %#v in cluster %#v from %d to %d tasks", opts.Service, opts.Cluster, result.OldCount, opts.Count)
+	updatedAt := time.Now()
+	updateServiceOutput, err := c.ECS.UpdateService(&ecs.UpdateServiceInput{
+		Cluster:      &opts.Cluster,
+		Service:      &opts.Service,
+		DesiredCount: &opts.Count,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot scale service %#v", opts.Service)
+	}
+
+	c.Log.Infof("Waiting for service %#v in cluster %#v to be stable", opts.Service, opts.Cluster)
+	// Scaling starts no deployment, so only the events of the service tell whether it failed
+	err = c.checkAborted(c.ECS.WaitUntilServicesStableWithContext(
+		c.context(),
+		&ecs.DescribeServicesInput{
+			Cluster:  &opts.Cluster,
+			Services: []*string{updateServiceOutput.Service.ServiceArn}},
+		c.stableWaiterOptions(opts.Timeout, updatedAt, util.GetFailOnAbortContext(updatedAt))...))
+
+	if service, describeErr := c.FindService(opts.Cluster, opts.Service); describeErr == nil && service != nil {
+		result.RunningCount = aws.Int64Value(service.RunningCount)
+	}
+	return result, err
+}
@@ -0,0 +1,51 @@
+package czecs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestScale(t *testing.T) {
+	fake, _, c := newAutoScalingTestClient(t)
+	applyTestAutoScaling(t, c, "4")
+	// A count outside the auto scaling capacity is only warned about
+	for _, count := range []int64{3, 6} {
+		result, err := c.Scale(ScaleOptions{Cluster: testCluster, Service: "web", Count: count, Timeout: 15})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.DesiredCount != count || result.RunningCount != count {
+			t.Errorf("result = %+v, want %d tasks", result, count)
+		}
+		if service := testService(t, fake, "web"); aws.Int64Value(service.DesiredCount) != count {
+			t.Errorf("desired count = %d, want %d", aws.Int64Value(service.DesiredCount), count)
+		}
+	}
+}
+
+func TestScaleWithoutAutoScaling(t *testing.T) {
+	fake, _, c := newAutoScalingTestClient(t)
+	// Auto scaling is optional, and failing to read it only skips the check
+	c.AutoScaling = failingAutoScaling{}
+	result, err := c.Scale(ScaleOptions{Cluster: testCluster, Service: "web", Count: 2, Timeout: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OldCount != 1 || result.RunningCount != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	c.AutoScaling = nil
+	if _, err := c.Scale(ScaleOptions{Cluster: testCluster, Service: "web", Count: 0, Timeout: 15}); err != nil {
+		t.Fatal(err)
+	}
+	if service := testService(t, fake, "web"); aws.Int64Value(service.RunningCount) != 0 {
+		t.Errorf("running count = %d", aws.Int64Value(service.RunningCount))
+	}
+}
+
+func TestScaleMissingService(t *testing.T) {
+	_, _, c := newAutoScalingTestClient(t)
+	_, err := c.Scale(ScaleOptions{Cluster: testCluster, Service: "api", Count: 2})
+	checkError(t, err, "does not exist")
+}
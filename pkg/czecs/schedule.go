@@ -0,0 +1,558 @@
+package czecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/pkg/errors"
+)
+
+// ScheduleTargetID is the ID of the ECS target of the EventBridge rules created by CreateSchedule.
+const ScheduleTargetID = "czecs"
+
+// ScheduleOptions configures CreateSchedule, UpdateSchedule and PlanSchedule.
+type ScheduleOptions struct {
+	// Name is the name of the EventBridge rule
+	Name string
+	// Template is the file name or URI of the task template, a RunTask input
+	Template string
+	Values   Values
+	// TaskDefinitionTemplate is the file name or URI of a task definition template registered for the task, if set
+	TaskDefinitionTemplate string
+	// TaskDefinitionArn overrides the task definition of the task template, if set
+	TaskDefinitionArn string
+	// Cluster overrides the cluster of the task template, if set
+	Cluster string
+	// ScheduleExpression is a cron(...) or rate(...) expression; on update, defaults to that of the schedule
+	ScheduleExpression string
+	// RoleArn is the IAM role EventBridge runs the task with; on update, defaults to that of the schedule
+	RoleArn string
+	// Description of the rule; on update, defaults to that of the schedule
+	Description string
+	// State is ENABLED or DISABLED; defaults to ENABLED on create, and to that of the schedule on update
+	State string
+}
+
+// ScheduleResult describes a schedule created, updated or deleted, or the update planned by PlanSchedule.
+type ScheduleResult struct {
+	Name              string `json:"name"`
+	RuleArn           string `json:"ruleArn,omitempty"`
+	TaskDefinitionArn string `json:"taskDefinitionArn,omitempty"`
+	// Changes are the fields changed by an update, compared to the existing schedule. Fields of the rule start
+	// with "rule.", of the ECS target with "target.", of its container overrides with "overrides.", and of the
+	// task definition with "taskDefinition."
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Schedule is a task scheduled by an EventBridge rule with an ECS target.
+type Schedule struct {
+	Name               string `json:"name"`
+	RuleArn            string `json:"ruleArn"`
+	ScheduleExpression string `json:"scheduleExpression"`
+	State              string `json:"state"`
+	Description        string `json:"description,omitempty"`
+	ClusterArn         string `json:"clusterArn"`
+	TaskDefinitionArn  string `json:"taskDefinitionArn"`
+	TaskCount          int64  `json:"taskCount"`
+	RoleArn            string `json:"roleArn,omitempty"`
+}
+
+// existingSchedule is an EventBridge rule and its ECS target, if any.
+type existingSchedule struct {
+	rule   *eventbridge.DescribeRuleOutput
+	target *eventbridge.Target
+}
+
+// desiredSchedule is the rule and target a schedule is created or updated with.
+type desiredSchedule struct {
+	rule   *eventbridge.PutRuleInput
+	target *eventbridge.Target
+	// register is the task definition to register before putting the target, if any; the task definition ARN
+	// of the target is then a placeholder
+	register *ecs.RegisterTaskDefinitionInput
+	// taskDefinitionChanges are the changes of the task definition to register from that of the existing schedule
+	taskDefinitionChanges []Change
+}
+
+// CreateSchedule creates an EventBridge rule running the task of the task template on a schedule, registering
+// the task definition first if a task definition template is given.
+func (c *Client) CreateSchedule(opts ScheduleOptions) (*ScheduleResult, error) {
+	if opts.ScheduleExpression == "" {
+		return nil, fmt.Errorf("a schedule expression must be provided")
+	}
+	if opts.RoleArn == "" {
+		return nil, fmt.Errorf("an IAM role for EventBridge to run the task with must be provided")
+	}
+	existing, err := c.findSchedule(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Schedule %#v already exists. Use czecs schedule update command to update it", opts.Name)
+	}
+	desired, err := c.desiredSchedule(opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.putSchedule(desired)
+}
+
+// UpdateSchedule updates an existing schedule, comparing it with the rendered one first; nothing is changed if
+// they are identical. A task definition template is only registered if it differs from the task definition of
+// the schedule.
+func (c *Client) UpdateSchedule(opts ScheduleOptions) (*ScheduleResult, error) {
+	existing, desired, changes, err := c.planSchedule(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		c.Log.Infof("Schedule %#v is up to date", opts.Name)
+		return &ScheduleResult{
+			Name:              opts.Name,
+			RuleArn:           aws.StringValue(existing.rule.Arn),
+			TaskDefinitionArn: aws.StringValue(desired.target.EcsParameters.TaskDefinitionArn),
+		}, nil
+	}
+	for _, change := range changes {
+		c.Log.Infof("Schedule %#v: %s", opts.Name, change)
+	}
+	result, err := c.putSchedule(desired)
+	if result != nil {
+		result.Changes = changes
+	}
+	return result, err
+}
+
+// PlanSchedule returns the changes UpdateSchedule would make to an existing schedule, without changing anything.
+func (c *Client) PlanSchedule(opts ScheduleOptions) (*ScheduleResult, error) {
+	existing, desired, changes, err := c.planSchedule(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleResult{
+		Name:              opts.Name,
+		RuleArn:           aws.StringValue(existing.rule.Arn),
+		TaskDefinitionArn: aws.StringValue(desired.target.EcsParameters.TaskDefinitionArn),
+		Changes:           changes,
+	}, nil
+}
+
+// planSchedule returns the existing schedule, the schedule to update it to, and the changes between them.
+func (c *Client) planSchedule(opts ScheduleOptions) (*existingSchedule, *desiredSchedule, []Change, error) {
+	existing, err := c.findSchedule(opts.Name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if existing == nil {
+		return nil, nil, nil, fmt.Errorf("Schedule %#v does not exist. Use czecs schedule create command to create it", opts.Name)
+	}
+	desired, err := c.desiredSchedule(opts, existing)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	old, err := scheduleJSON(existingRule(existing.rule), existing.target)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	new, err := scheduleJSON(desired.rule, desired.target)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	changes := diff(old, new)
+	for _, change := range desired.taskDefinitionChanges {
+		change.Field = "taskDefinition." + change.Field
+		changes = append(changes, change)
+	}
+	return existing, desired, changes, nil
+}
+
+// DeleteSchedule removes the targets of a schedule, then deletes its rule.
+func (c *Client) DeleteSchedule(name string) (*ScheduleResult, error) {
+	existing, err := c.findSchedule(name)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("Schedule %#v does not exist", name)
+	}
+	targets, err := c.ruleTargets(name)
+	if err != nil {
+		return nil, err
+	}
+	result := &ScheduleResult{Name: name, RuleArn: aws.StringValue(existing.rule.Arn)}
+	if existing.target != nil {
+		result.TaskDefinitionArn = aws.StringValue(existing.target.EcsParameters.TaskDefinitionArn)
+	}
+	if len(targets) > 0 {
+		var ids []*string
+		for _, target := range targets {
+			ids = append(ids, target.Id)
+		}
+		removeTargetsOutput, err := c.EventBridge.RemoveTargets(&eventbridge.RemoveTargetsInput{Rule: &name, Ids: ids})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot remove the targets of schedule %#v", name)
+		}
+		if aws.Int64Value(removeTargetsOutput.FailedEntryCount) > 0 {
+			return nil, fmt.Errorf("cannot remove the targets of schedule %#v: %v", name, removeTargetsOutput.FailedEntries)
+		}
+	}
+	if _, err := c.EventBridge.DeleteRule(&eventbridge.DeleteRuleInput{Name: &name}); err != nil {
+		return nil, errors.Wrapf(err, "cannot delete schedule %#v", name)
+	}
+	c.Log.Infof("Successfully deleted schedule %#v", name)
+	return result, nil
+}
+
+// ListSchedules lists the scheduled rules whose name starts with the given prefix that run tasks in the given
+// cluster, by name or ARN, or in any cluster if empty. Rules without an ECS target are left out.
+func (c *Client) ListSchedules(prefix string, cluster string) ([]Schedule, error) {
+	var rules []*eventbridge.Rule
+	input := &eventbridge.ListRulesInput{}
+	if prefix != "" {
+		input.NamePrefix = &prefix
+	}
+	for {
+		listRulesOutput, err := c.EventBridge.ListRules(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot list EventBridge rules")
+		}
+		rules = append(rules, listRulesOutput.Rules...)
+		if listRulesOutput.NextToken == nil {
+			break
+		}
+		input.NextToken = listRulesOutput.NextToken
+	}
+
+	schedules := []Schedule{}
+	for _, rule := range rules {
+		if aws.StringValue(rule.ScheduleExpression) == "" {
+			continue
+		}
+		targets, err := c.ruleTargets(aws.StringValue(rule.Name))
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			if target.EcsParameters == nil || !isCluster(aws.StringValue(target.Arn), cluster) {
+				continue
+			}
+			schedules = append(schedules, Schedule{
+				Name:               aws.StringValue(rule.Name),
+				RuleArn:            aws.StringValue(rule.Arn),
+				ScheduleExpression: aws.StringValue(rule.ScheduleExpression),
+				State:              aws.StringValue(rule.State),
+				Description:        aws.StringValue(rule.Description),
+				ClusterArn:         aws.StringValue(target.Arn),
+				TaskDefinitionArn:  aws.StringValue(target.EcsParameters.TaskDefinitionArn),
+				TaskCount:          aws.Int64Value(target.EcsParameters.TaskCount),
+				RoleArn:            aws.StringValue(target.RoleArn),
+			})
+		}
+	}
+	return schedules, nil
+}
+
+// isCluster returns whether the given cluster ARN is that of the given cluster name or ARN; any cluster matches "".
+func isCluster(clusterArn string, cluster string) bool {
+	return cluster == "" || clusterArn == cluster || strings.HasSuffix(clusterArn, ":cluster/"+cluster)
+}
+
+// findSchedule returns the rule of the given name and its ECS target, or nil if there is no such rule.
+func (c *Client) findSchedule(name string) (*existingSchedule, error) {
+	describeRuleOutput, err := c.EventBridge.DescribeRule(&eventbridge.DescribeRuleInput{Name: &name})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == eventbridge.ErrCodeResourceNotFoundException {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot describe schedule %#v", name)
+	}
+	targets, err := c.ruleTargets(name)
+	if err != nil {
+		return nil, err
+	}
+	existing := &existingSchedule{rule: describeRuleOutput}
+	for _, target := range targets {
+		if target.EcsParameters == nil {
+			continue
+		}
+		// Prefer the target created by czecs, if the rule has several ECS targets
+		if existing.target == nil || aws.StringValue(target.Id) == ScheduleTargetID {
+			existing.target = target
+		}
+	}
+	return existing, nil
+}
+
+func (c *Client) ruleTargets(name string) ([]*eventbridge.Target, error) {
+	var targets []*eventbridge.Target
+	input := &eventbridge.ListTargetsByRuleInput{Rule: &name}
+	for {
+		listTargetsOutput, err := c.EventBridge.ListTargetsByRule(input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list the targets of schedule %#v", name)
+		}
+		targets = append(targets, listTargetsOutput.Targets...)
+		if listTargetsOutput.NextToken == nil {
+			return targets, nil
+		}
+		input.NextToken = listTargetsOutput.NextToken
+	}
+}
+
+// desiredSchedule renders the task template into the rule and ECS target of the schedule, with the settings of
+// the existing schedule, if any, as defaults.
+func (c *Client) desiredSchedule(opts ScheduleOptions, existing *existingSchedule) (*desiredSchedule, error) {
+	runTaskInput, err := c.RenderTask(opts.Template, opts.Values)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cluster != "" {
+		runTaskInput.Cluster = &opts.Cluster
+	}
+	if opts.TaskDefinitionArn != "" {
+		runTaskInput.TaskDefinition = &opts.TaskDefinitionArn
+	}
+
+	rule := &eventbridge.PutRuleInput{
+		Name:               &opts.Name,
+		ScheduleExpression: &opts.ScheduleExpression,
+		State:              aws.String(eventbridge.RuleStateEnabled),
+		Description:        &opts.Description,
+	}
+	target := &eventbridge.Target{Id: aws.String(ScheduleTargetID), RoleArn: &opts.RoleArn}
+	var existingTaskDefinition string
+	if existing != nil {
+		if opts.ScheduleExpression == "" {
+			rule.ScheduleExpression = existing.rule.ScheduleExpression
+		}
+		rule.State = existing.rule.State
+		if opts.Description == "" {
+			rule.Description = existing.rule.Description
+		}
+		if existing.target != nil {
+			target.Id = existing.target.Id
+			if opts.RoleArn == "" {
+				target.RoleArn = existing.target.RoleArn
+			}
+			existingTaskDefinition = aws.StringValue(existing.target.EcsParameters.TaskDefinitionArn)
+		}
+	}
+	if opts.State != "" {
+		rule.State = &opts.State
+	}
+	if aws.StringValue(rule.Description) == "" {
+		rule.Description = nil
+	}
+	if aws.StringValue(target.RoleArn) == "" {
+		target.RoleArn = nil
+	}
+
+	desired := &desiredSchedule{rule: rule, target: target}
+	taskDefinitionArn, err := c.scheduleTaskDefinition(desired, opts, runTaskInput, existingTaskDefinition)
+	if err != nil {
+		return nil, err
+	}
+	if target.Arn, err = c.clusterArn(aws.StringValue(runTaskInput.Cluster)); err != nil {
+		return nil, err
+	}
+	if target.EcsParameters, err = ecsParameters(runTaskInput, taskDefinitionArn); err != nil {
+		return nil, err
+	}
+	for _, unsupported := range unsupportedScheduleFields(runTaskInput) {
+		c.Log.Warnf("Field %#v of the task template is not supported by EventBridge and is ignored", unsupported)
+	}
+	if runTaskInput.Overrides != nil {
+		overrides, err := util.MarshalAPIJSON(runTaskInput.Overrides)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot format task overrides")
+		}
+		target.Input = aws.String(string(overrides))
+	}
+	return desired, nil
+}
+
+// scheduleTaskDefinition returns the ARN of the task definition the schedule runs. A task definition template
+// is rendered to be registered by putSchedule, unless it is identical to the existing task definition, which
+// is then reused; the returned ARN is then its family followed by ":<new revision>".
+func (c *Client) scheduleTaskDefinition(desired *desiredSchedule, opts ScheduleOptions, runTaskInput *ecs.RunTaskInput, existingTaskDefinition string) (string, error) {
+	if opts.TaskDefinitionTemplate == "" {
+		if aws.StringValue(runTaskInput.TaskDefinition) == "" {
+			return "", fmt.Errorf("the task template has no task definition; provide a task definition template or ARN")
+		}
+		// EventBridge only takes full task definition ARNs
+		describeTaskDefinitionOutput, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: runTaskInput.TaskDefinition})
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot retrieve task definition %#v", aws.StringValue(runTaskInput.TaskDefinition))
+		}
+		return aws.StringValue(describeTaskDefinitionOutput.TaskDefinition.TaskDefinitionArn), nil
+	}
+
+	registerTaskDefinitionInput, err := c.RenderTaskDefinition(opts.TaskDefinitionTemplate, opts.Values)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := util.MarshalAPIJSON(registerTaskDefinitionInput)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot format task definition")
+	}
+	if existingTaskDefinition != "" {
+		describeTaskDefinitionOutput, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{TaskDefinition: &existingTaskDefinition})
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot retrieve task definition %#v", existingTaskDefinition)
+		}
+		old, err := registrationJSON(describeTaskDefinitionOutput.TaskDefinition)
+		if err != nil {
+			return "", err
+		}
+		desired.taskDefinitionChanges = diff(old, rendered)
+		if len(desired.taskDefinitionChanges) == 0 {
+			c.Log.Debugf("Task definition %#v is identical to the rendered one; reusing it", existingTaskDefinition)
+			return existingTaskDefinition, nil
+		}
+	}
+	desired.register = registerTaskDefinitionInput
+	return aws.StringValue(registerTaskDefinitionInput.Family) + ":<new revision>", nil
+}
+
+// clusterArn returns the ARN of the given cluster, which EventBridge targets take.
+func (c *Client) clusterArn(cluster string) (*string, error) {
+	if cluster == "" {
+		cluster = "default"
+	}
+	describeClustersOutput, err := c.ECS.DescribeClusters(&ecs.DescribeClustersInput{Clusters: []*string{&cluster}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot describe cluster %#v", cluster)
+	}
+	for _, existing := range describeClustersOutput.Clusters {
+		if aws.StringValue(existing.Status) != "INACTIVE" {
+			return existing.ClusterArn, nil
+		}
+	}
+	return nil, fmt.Errorf("cluster %#v does not exist", cluster)
+}
+
+// ecsParameters returns the parameters of the ECS target running the given task.
+func ecsParameters(runTaskInput *ecs.RunTaskInput, taskDefinitionArn string) (*eventbridge.EcsParameters, error) {
+	params := &eventbridge.EcsParameters{
+		TaskDefinitionArn: &taskDefinitionArn,
+		TaskCount:         aws.Int64(1),
+		LaunchType:        runTaskInput.LaunchType,
+		PlatformVersion:   runTaskInput.PlatformVersion,
+		Group:             runTaskInput.Group,
+	}
+	if runTaskInput.Count != nil {
+		params.TaskCount = runTaskInput.Count
+	}
+	if network := runTaskInput.NetworkConfiguration; network != nil && network.AwsvpcConfiguration != nil {
+		params.NetworkConfiguration = &eventbridge.NetworkConfiguration{
+			AwsvpcConfiguration: &eventbridge.AwsVpcConfiguration{
+				Subnets:        network.AwsvpcConfiguration.Subnets,
+				SecurityGroups: network.AwsvpcConfiguration.SecurityGroups,
+				AssignPublicIp: network.AwsvpcConfiguration.AssignPublicIp,
+			},
+		}
+	}
+	if err := params.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid scheduled task")
+	}
+	return params, nil
+}
+
+// unsupportedScheduleFields returns the fields of the task that EventBridge ECS targets cannot set.
+func unsupportedScheduleFields(runTaskInput *ecs.RunTaskInput) []string {
+	var fields []string
+	for field, set := range map[string]bool{
+		"capacityProviderStrategy": len(runTaskInput.CapacityProviderStrategy) > 0,
+		"enableECSManagedTags":     runTaskInput.EnableECSManagedTags != nil,
+		"enableExecuteCommand":     runTaskInput.EnableExecuteCommand != nil,
+		"placementConstraints":     len(runTaskInput.PlacementConstraints) > 0,
+		"placementStrategy":        len(runTaskInput.PlacementStrategy) > 0,
+		"propagateTags":            runTaskInput.PropagateTags != nil,
+		"referenceId":              runTaskInput.ReferenceId != nil,
+		"startedBy":                runTaskInput.StartedBy != nil,
+		"tags":                     len(runTaskInput.Tags) > 0,
+	} {
+		if set {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// putSchedule registers the task definition of the schedule if needed, then puts its rule and target.
+func (c *Client) putSchedule(desired *desiredSchedule) (*ScheduleResult, error) {
+	name := aws.StringValue(desired.rule.Name)
+	result := &ScheduleResult{Name: name}
+	if desired.register != nil {
+		c.Log.Debugf("Task definition: %+v", desired.register)
+		registerTaskDefinitionOutput, err := c.ECS.RegisterTaskDefinition(desired.register)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot register task definition")
+		}
+		desired.target.EcsParameters.TaskDefinitionArn = registerTaskDefinitionOutput.TaskDefinition.TaskDefinitionArn
+		c.Log.Infof("Successfully registered task definition %#v", *desired.target.EcsParameters.TaskDefinitionArn)
+	}
+	result.TaskDefinitionArn = aws.StringValue(desired.target.EcsParameters.TaskDefinitionArn)
+
+	putRuleOutput, err := c.EventBridge.PutRule(desired.rule)
+	if err != nil {
+		return result, errors.Wrapf(err, "cannot put schedule %#v", name)
+	}
+	result.RuleArn = aws.StringValue(putRuleOutput.RuleArn)
+	putTargetsOutput, err := c.EventBridge.PutTargets(&eventbridge.PutTargetsInput{
+		Rule:    &name,
+		Targets: []*eventbridge.Target{desired.target},
+	})
+	if err != nil {
+		return result, errors.Wrapf(err, "cannot put the target of schedule %#v", name)
+	}
+	if aws.Int64Value(putTargetsOutput.FailedEntryCount) > 0 {
+		return result, fmt.Errorf("cannot put the target of schedule %#v: %v", name, putTargetsOutput.FailedEntries)
+	}
+	c.Log.Infof("Successfully scheduled task definition %#v with %#v as %#v", result.TaskDefinitionArn, aws.StringValue(desired.rule.ScheduleExpression), name)
+	return result, nil
+}
+
+// existingRule returns the fields of an existing rule that can be given when putting it.
+func existingRule(rule *eventbridge.DescribeRuleOutput) *eventbridge.PutRuleInput {
+	return &eventbridge.PutRuleInput{
+		Name:               rule.Name,
+		ScheduleExpression: rule.ScheduleExpression,
+		State:              rule.State,
+		Description:        rule.Description,
+	}
+}
+
+// scheduleJSON returns a JSON document of the rule and target of a schedule to compare schedules with. The input
+// of the target, the container overrides, is included as a JSON document of its own.
+func scheduleJSON(rule *eventbridge.PutRuleInput, target *eventbridge.Target) (json.RawMessage, error) {
+	document := map[string]json.RawMessage{}
+	var err error
+	if document["rule"], err = util.MarshalAPIJSON(rule); err != nil {
+		return nil, errors.Wrap(err, "cannot format schedule")
+	}
+	if target == nil {
+		target = &eventbridge.Target{}
+	}
+	withoutInput := *target
+	withoutInput.Input = nil
+	if document["target"], err = util.MarshalAPIJSON(&withoutInput); err != nil {
+		return nil, errors.Wrap(err, "cannot format schedule")
+	}
+	if input := aws.StringValue(target.Input); input != "" {
+		if json.Valid([]byte(input)) {
+			document["overrides"] = json.RawMessage(input)
+		} else {
+			document["overrides"], _ = json.Marshal(input)
+		}
+	}
+	return json.Marshal(document)
+}
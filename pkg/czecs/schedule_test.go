@@ -0,0 +1,314 @@
+package czecs
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+// fakeEventBridge is an in-memory EventBridge with the calls czecs makes.
+type fakeEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	// rules are by name, and targets by rule name and target ID
+	rules   map[string]*eventbridge.DescribeRuleOutput
+	targets map[string]map[string]*eventbridge.Target
+	// calls are the names of the calls changing rules or targets
+	calls []string
+}
+
+func newFakeEventBridge() *fakeEventBridge {
+	return &fakeEventBridge{
+		rules:   map[string]*eventbridge.DescribeRuleOutput{},
+		targets: map[string]map[string]*eventbridge.Target{},
+	}
+}
+
+func ruleNotFound(name string) error {
+	return awserr.New(eventbridge.ErrCodeResourceNotFoundException, "Rule "+name+" does not exist.", nil)
+}
+
+func (f *fakeEventBridge) DescribeRule(input *eventbridge.DescribeRuleInput) (*eventbridge.DescribeRuleOutput, error) {
+	rule, ok := f.rules[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, ruleNotFound(aws.StringValue(input.Name))
+	}
+	return rule, nil
+}
+
+func (f *fakeEventBridge) ListRules(input *eventbridge.ListRulesInput) (*eventbridge.ListRulesOutput, error) {
+	output := &eventbridge.ListRulesOutput{}
+	for name, rule := range f.rules {
+		if strings.HasPrefix(name, aws.StringValue(input.NamePrefix)) {
+			output.Rules = append(output.Rules, &eventbridge.Rule{
+				Name:               rule.Name,
+				Arn:                rule.Arn,
+				ScheduleExpression: rule.ScheduleExpression,
+				State:              rule.State,
+				Description:        rule.Description,
+			})
+		}
+	}
+	sort.Slice(output.Rules, func(i, j int) bool {
+		return aws.StringValue(output.Rules[i].Name) < aws.StringValue(output.Rules[j].Name)
+	})
+	return output, nil
+}
+
+func (f *fakeEventBridge) PutRule(input *eventbridge.PutRuleInput) (*eventbridge.PutRuleOutput, error) {
+	f.calls = append(f.calls, "PutRule")
+	name := aws.StringValue(input.Name)
+	arn := aws.String("arn:aws:events:" + ecsfake.Region + ":123456789012:rule/" + name)
+	f.rules[name] = &eventbridge.DescribeRuleOutput{
+		Name:               input.Name,
+		Arn:                arn,
+		ScheduleExpression: input.ScheduleExpression,
+		State:              input.State,
+		Description:        input.Description,
+	}
+	return &eventbridge.PutRuleOutput{RuleArn: arn}, nil
+}
+
+func (f *fakeEventBridge) DeleteRule(input *eventbridge.DeleteRuleInput) (*eventbridge.DeleteRuleOutput, error) {
+	f.calls = append(f.calls, "DeleteRule")
+	name := aws.StringValue(input.Name)
+	if len(f.targets[name]) > 0 {
+		return nil, awserr.New("ValidationException", "Rule can't be deleted since it has targets.", nil)
+	}
+	delete(f.rules, name)
+	return &eventbridge.DeleteRuleOutput{}, nil
+}
+
+func (f *fakeEventBridge) ListTargetsByRule(input *eventbridge.ListTargetsByRuleInput) (*eventbridge.ListTargetsByRuleOutput, error) {
+	name := aws.StringValue(input.Rule)
+	if _, ok := f.rules[name]; !ok {
+		return nil, ruleNotFound(name)
+	}
+	output := &eventbridge.ListTargetsByRuleOutput{}
+	for _, target := range f.targets[name] {
+		output.Targets = append(output.Targets, target)
+	}
+	return output, nil
+}
+
+func (f *fakeEventBridge) PutTargets(input *eventbridge.PutTargetsInput) (*eventbridge.PutTargetsOutput, error) {
+	f.calls = append(f.calls, "PutTargets")
+	name := aws.StringValue(input.Rule)
+	if _, ok := f.rules[name]; !ok {
+		return nil, ruleNotFound(name)
+	}
+	if f.targets[name] == nil {
+		f.targets[name] = map[string]*eventbridge.Target{}
+	}
+	for _, target := range input.Targets {
+		f.targets[name][aws.StringValue(target.Id)] = target
+	}
+	return &eventbridge.PutTargetsOutput{FailedEntryCount: aws.Int64(0)}, nil
+}
+
+func (f *fakeEventBridge) RemoveTargets(input *eventbridge.RemoveTargetsInput) (*eventbridge.RemoveTargetsOutput, error) {
+	f.calls = append(f.calls, "RemoveTargets")
+	for _, id := range input.Ids {
+		delete(f.targets[aws.StringValue(input.Rule)], aws.StringValue(id))
+	}
+	return &eventbridge.RemoveTargetsOutput{FailedEntryCount: aws.Int64(0)}, nil
+}
+
+// scheduleOptions returns the options of the migrate schedule, running testdata/migrate.json with the given tag.
+func scheduleOptions(tag string) ScheduleOptions {
+	return ScheduleOptions{
+		Name:                   "migrate",
+		Template:               "testdata/migrate-task.json",
+		Values:                 webValues(tag),
+		TaskDefinitionTemplate: "testdata/migrate.json",
+		Cluster:                testCluster,
+		ScheduleExpression:     "rate(1 hour)",
+		RoleArn:                "arn:aws:iam::123456789012:role/events",
+	}
+}
+
+// newScheduleTestClient returns a client with fake ECS and EventBridge, and the migrate schedule created with tag v1.
+func newScheduleTestClient(t *testing.T) (*fakeEventBridge, *Client) {
+	t.Helper()
+	eventBridge := newFakeEventBridge()
+	c := newTestClient(ecsfake.New(testCluster))
+	c.EventBridge = eventBridge
+	result, err := c.CreateSchedule(scheduleOptions("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TaskDefinitionArn != fakeTaskDefinitionArn("test-migrate:1") {
+		t.Fatalf("TaskDefinitionArn = %#v", result.TaskDefinitionArn)
+	}
+	eventBridge.calls = nil
+	return eventBridge, c
+}
+
+func TestCreateScheduleExists(t *testing.T) {
+	eventBridge, c := newScheduleTestClient(t)
+	_, err := c.CreateSchedule(scheduleOptions("v1"))
+	checkError(t, err, "already exists")
+	if len(eventBridge.calls) != 0 {
+		t.Errorf("calls = %v", eventBridge.calls)
+	}
+}
+
+func TestUpdateSchedule(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the options of the schedule created with tag v1
+		change func(opts *ScheduleOptions)
+
+		wantChanges        []string
+		wantTaskDefinition string
+		wantExpression     string
+		wantState          string
+	}{
+		{
+			name:               "no change",
+			change:             func(opts *ScheduleOptions) {},
+			wantTaskDefinition: "test-migrate:1",
+			wantExpression:     "rate(1 hour)",
+			wantState:          eventbridge.RuleStateEnabled,
+		},
+		{
+			name: "settings of the schedule kept",
+			change: func(opts *ScheduleOptions) {
+				opts.ScheduleExpression, opts.RoleArn = "", ""
+			},
+			wantTaskDefinition: "test-migrate:1",
+			wantExpression:     "rate(1 hour)",
+			wantState:          eventbridge.RuleStateEnabled,
+		},
+		{
+			name: "rule changed",
+			change: func(opts *ScheduleOptions) {
+				opts.ScheduleExpression, opts.State = "cron(0 12 * * ? *)", eventbridge.RuleStateDisabled
+			},
+			wantChanges:        []string{"rule.ScheduleExpression", "rule.State"},
+			wantTaskDefinition: "test-migrate:1",
+			wantExpression:     "cron(0 12 * * ? *)",
+			wantState:          eventbridge.RuleStateDisabled,
+		},
+		{
+			name: "task definition changed",
+			change: func(opts *ScheduleOptions) {
+				opts.Values = webValues("v2")
+			},
+			wantChanges:        []string{"target.EcsParameters.TaskDefinitionArn", "taskDefinition.containerDefinitions[0].image"},
+			wantTaskDefinition: "test-migrate:2",
+			wantExpression:     "rate(1 hour)",
+			wantState:          eventbridge.RuleStateEnabled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			eventBridge, c := newScheduleTestClient(t)
+			opts := scheduleOptions("v1")
+			test.change(&opts)
+
+			plan, err := c.PlanSchedule(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(eventBridge.calls) != 0 {
+				t.Errorf("plan changed the schedule: %v", eventBridge.calls)
+			}
+			result, err := c.UpdateSchedule(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var changes []string
+			for _, change := range result.Changes {
+				changes = append(changes, change.Field)
+			}
+			if !reflect.DeepEqual(changes, test.wantChanges) {
+				t.Errorf("changes = %v, want %v", changes, test.wantChanges)
+			}
+			if len(plan.Changes) != len(result.Changes) {
+				t.Errorf("planned changes = %v, changes = %v", plan.Changes, result.Changes)
+			}
+			// Nothing is put if nothing changed
+			if wantCalls := len(test.wantChanges) > 0; (len(eventBridge.calls) > 0) != wantCalls {
+				t.Errorf("calls = %v", eventBridge.calls)
+			}
+			if result.TaskDefinitionArn != fakeTaskDefinitionArn(test.wantTaskDefinition) {
+				t.Errorf("TaskDefinitionArn = %#v, want %#v", result.TaskDefinitionArn, test.wantTaskDefinition)
+			}
+
+			schedules, err := c.ListSchedules("", testCluster)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schedules) != 1 {
+				t.Fatalf("schedules = %v", schedules)
+			}
+			schedule := schedules[0]
+			if schedule.ScheduleExpression != test.wantExpression || schedule.State != test.wantState {
+				t.Errorf("schedule = %+v", schedule)
+			}
+			if schedule.TaskDefinitionArn != fakeTaskDefinitionArn(test.wantTaskDefinition) || schedule.RoleArn != "arn:aws:iam::123456789012:role/events" {
+				t.Errorf("schedule = %+v", schedule)
+			}
+		})
+	}
+}
+
+func TestUpdateScheduleMissing(t *testing.T) {
+	c := newTestClient(ecsfake.New(testCluster))
+	c.EventBridge = newFakeEventBridge()
+	_, err := c.UpdateSchedule(scheduleOptions("v1"))
+	checkError(t, err, "does not exist")
+}
+
+func TestDeleteSchedule(t *testing.T) {
+	eventBridge, c := newScheduleTestClient(t)
+	result, err := c.DeleteSchedule("migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TaskDefinitionArn != fakeTaskDefinitionArn("test-migrate:1") {
+		t.Errorf("TaskDefinitionArn = %#v", result.TaskDefinitionArn)
+	}
+	// Targets are removed first, since EventBridge cannot delete rules with targets
+	if want := []string{"RemoveTargets", "DeleteRule"}; !reflect.DeepEqual(eventBridge.calls, want) {
+		t.Errorf("calls = %v, want %v", eventBridge.calls, want)
+	}
+	if len(eventBridge.rules) != 0 {
+		t.Errorf("rules left: %v", eventBridge.rules)
+	}
+
+	_, err = c.DeleteSchedule("migrate")
+	checkError(t, err, "does not exist")
+}
+
+func TestListSchedules(t *testing.T) {
+	eventBridge, c := newScheduleTestClient(t)
+	// Rules without a schedule or an ECS target are not schedules
+	eventBridge.PutRule(&eventbridge.PutRuleInput{Name: aws.String("events"), EventPattern: aws.String(`{"source": ["aws.ecs"]}`)})
+	eventBridge.PutRule(&eventbridge.PutRuleInput{Name: aws.String("lambda"), ScheduleExpression: aws.String("rate(1 day)")})
+	eventBridge.PutTargets(&eventbridge.PutTargetsInput{
+		Rule:    aws.String("lambda"),
+		Targets: []*eventbridge.Target{{Id: aws.String("lambda"), Arn: aws.String("arn:aws:lambda:us-west-2:123456789012:function:cleanup")}},
+	})
+
+	for cluster, want := range map[string]int{"": 1, testCluster: 1, "other": 0} {
+		schedules, err := c.ListSchedules("", cluster)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(schedules) != want {
+			t.Errorf("schedules in cluster %#v = %v, want %d", cluster, schedules, want)
+		}
+	}
+	if schedules, _ := c.ListSchedules("lam", ""); len(schedules) != 0 {
+		t.Errorf("schedules with prefix lam = %v", schedules)
+	}
+}
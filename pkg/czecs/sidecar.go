@@ -0,0 +1,159 @@
+package czecs
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/pkg/errors"
+)
+
+// SidecarsDir is the directory next to a task definition template holding the sidecars referred to by name.
+const SidecarsDir = "sidecars"
+
+// Sidecar is a set of containers added to task definitions, e.g. a metrics agent, a proxy or a log router.
+// Sidecar files are templates, rendered with the same values as the task definition.
+type Sidecar struct {
+	ContainerDefinitions []*ecs.ContainerDefinition `json:"containerDefinitions"`
+	// Volumes are added to the task definition; a volume already in the task definition must be identical
+	Volumes []*ecs.Volume `json:"volumes"`
+	// DependsOn is added to every container of the task definition itself (not those of sidecars), e.g. so
+	// that they start once a proxy is healthy
+	DependsOn []*ecs.ContainerDependency `json:"dependsOn"`
+	// LogConfiguration replaces the log configuration of every container of the task definition itself, e.g.
+	// to route their logs through a FireLens log router
+	LogConfiguration *ecs.LogConfiguration `json:"logConfiguration"`
+}
+
+// sidecarFile returns the file of a sidecar: the sidecars/<name>.json file next to the template (a local file
+// or URI) if given a name, otherwise the given file name or URI.
+func sidecarFile(template string, sidecar string) (string, error) {
+	if tasks.IsURI(sidecar) || strings.ContainsAny(sidecar, `/\`) || filepath.Ext(sidecar) != "" {
+		return sidecar, nil
+	}
+	file, err := tasks.ResolveRelative(template, SidecarsDir+"/"+sidecar+".json")
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot find sidecar %s next to template %v", sidecar, template)
+	}
+	return file, nil
+}
+
+// addSidecars renders the sidecars of values and adds them to the task definition.
+func (c *Client) addSidecars(taskDefn *ecs.RegisterTaskDefinitionInput, template string, values Values, templateValues map[string]interface{}) error {
+	// The containers of the task definition itself, before any sidecar is added
+	appContainers := append([]*ecs.ContainerDefinition{}, taskDefn.ContainerDefinitions...)
+	// Sidecars can include the partials of the template
+	partials := values.Partials
+	if !tasks.IsURI(template) {
+		partials = append([]string{filepath.Dir(template)}, partials...)
+	}
+	logConfigurationFrom := ""
+	for _, name := range values.Sidecars {
+		file, err := sidecarFile(template, name)
+		if err != nil {
+			return err
+		}
+		var sidecar Sidecar
+		if err := tasks.ParseTemplate(file, templateValues, values.Strict, partials, &sidecar); err != nil {
+			return errors.Wrapf(err, "cannot parse sidecar %s", name)
+		}
+		if sidecar.LogConfiguration != nil {
+			if logConfigurationFrom != "" {
+				return fmt.Errorf("sidecars %s and %s both set the log configuration of the task definition's containers", logConfigurationFrom, name)
+			}
+			logConfigurationFrom = name
+		}
+		if err := addSidecar(taskDefn, appContainers, &sidecar); err != nil {
+			return errors.Wrapf(err, "sidecar %s", name)
+		}
+		c.Log.Infof("Added sidecar %s from %#v", name, file)
+	}
+	return nil
+}
+
+func addSidecar(taskDefn *ecs.RegisterTaskDefinitionInput, appContainers []*ecs.ContainerDefinition, sidecar *Sidecar) error {
+	// In awsvpc and host network mode, all containers share the ports of the task
+	sharedPorts := aws.StringValue(taskDefn.NetworkMode) == ecs.NetworkModeAwsvpc || aws.StringValue(taskDefn.NetworkMode) == ecs.NetworkModeHost
+	for _, container := range sidecar.ContainerDefinitions {
+		for _, existing := range taskDefn.ContainerDefinitions {
+			if aws.StringValue(existing.Name) == aws.StringValue(container.Name) {
+				return fmt.Errorf("container %#v already exists in the task definition", aws.StringValue(container.Name))
+			}
+			if port := conflictingPort(existing, container, sharedPorts); port != "" {
+				return fmt.Errorf("port %s of container %#v is already used by container %#v", port, aws.StringValue(container.Name), aws.StringValue(existing.Name))
+			}
+		}
+		taskDefn.ContainerDefinitions = append(taskDefn.ContainerDefinitions, container)
+	}
+
+	for _, volume := range sidecar.Volumes {
+		if err := addVolume(taskDefn, volume); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range appContainers {
+		for _, dependency := range sidecar.DependsOn {
+			if !dependsOn(container, aws.StringValue(dependency.ContainerName)) {
+				container.DependsOn = append(container.DependsOn, dependency)
+			}
+		}
+		if sidecar.LogConfiguration != nil {
+			container.LogConfiguration = sidecar.LogConfiguration
+		}
+	}
+	return nil
+}
+
+// conflictingPort returns the port both containers use, formatted as port/protocol, or "" if there is none.
+// Container ports only conflict if the containers share the ports of the task.
+func conflictingPort(existing *ecs.ContainerDefinition, container *ecs.ContainerDefinition, sharedPorts bool) string {
+	for _, mapping := range container.PortMappings {
+		for _, existingMapping := range existing.PortMappings {
+			if protocol(mapping) != protocol(existingMapping) {
+				continue
+			}
+			if sharedPorts && aws.Int64Value(mapping.ContainerPort) == aws.Int64Value(existingMapping.ContainerPort) {
+				return fmt.Sprintf("%d/%s", aws.Int64Value(mapping.ContainerPort), protocol(mapping))
+			}
+			if aws.Int64Value(mapping.HostPort) != 0 && aws.Int64Value(mapping.HostPort) == aws.Int64Value(existingMapping.HostPort) {
+				return fmt.Sprintf("%d/%s", aws.Int64Value(mapping.HostPort), protocol(mapping))
+			}
+		}
+	}
+	return ""
+}
+
+func protocol(mapping *ecs.PortMapping) string {
+	if mapping.Protocol == nil {
+		return ecs.TransportProtocolTcp
+	}
+	return strings.ToLower(*mapping.Protocol)
+}
+
+func addVolume(taskDefn *ecs.RegisterTaskDefinitionInput, volume *ecs.Volume) error {
+	for _, existing := range taskDefn.Volumes {
+		if aws.StringValue(existing.Name) != aws.StringValue(volume.Name) {
+			continue
+		}
+		if !reflect.DeepEqual(existing, volume) {
+			return fmt.Errorf("volume %#v is already defined differently in the task definition", aws.StringValue(volume.Name))
+		}
+		return nil
+	}
+	taskDefn.Volumes = append(taskDefn.Volumes, volume)
+	return nil
+}
+
+func dependsOn(container *ecs.ContainerDefinition, name string) bool {
+	for _, dependency := range container.DependsOn {
+		if aws.StringValue(dependency.ContainerName) == name {
+			return true
+		}
+	}
+	return false
+}
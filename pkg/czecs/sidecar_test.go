@@ -0,0 +1,147 @@
+package czecs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestSidecarFile(t *testing.T) {
+	tests := []struct {
+		template string
+		sidecar  string
+		want     string
+	}{
+		{template: "deploy/web.json", sidecar: "proxy", want: "deploy/sidecars/proxy.json"},
+		{template: "deploy/web.json", sidecar: "other/proxy.json", want: "other/proxy.json"},
+		{template: "deploy/web.json", sidecar: "s3://bucket/proxy.json", want: "s3://bucket/proxy.json"},
+		{template: "s3://bucket/web.json", sidecar: "proxy", want: "s3://bucket/sidecars/proxy.json"},
+		{template: "s3://bucket/deploy/web.json?versionId=3", sidecar: "proxy", want: "s3://bucket/deploy/sidecars/proxy.json"},
+		{template: "https://example.com/deploy/web.json#sha256=abc", sidecar: "proxy", want: "https://example.com/deploy/sidecars/proxy.json"},
+	}
+	for _, test := range tests {
+		got, err := sidecarFile(test.template, test.sidecar)
+		if err != nil {
+			t.Errorf("sidecarFile(%#v, %#v): %s", test.template, test.sidecar, err)
+		} else if got != test.want {
+			t.Errorf("sidecarFile(%#v, %#v) = %#v, want %#v", test.template, test.sidecar, got, test.want)
+		}
+	}
+}
+
+func TestRenderTaskDefinitionSidecars(t *testing.T) {
+	c := newTestClient(ecsfake.New(testCluster))
+	values := webValues("v1")
+	values.Sidecars = []string{"proxy", "log-router"}
+	taskDefn, err := c.RenderTaskDefinition("testdata/web.json", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, container := range taskDefn.ContainerDefinitions {
+		names = append(names, aws.StringValue(container.Name))
+	}
+	if strings.Join(names, ",") != "web,proxy,log-router" {
+		t.Fatalf("containers = %v", names)
+	}
+	web, proxy, logRouter := taskDefn.ContainerDefinitions[0], taskDefn.ContainerDefinitions[1], taskDefn.ContainerDefinitions[2]
+	// Sidecars are rendered with the values of the template
+	if image := aws.StringValue(proxy.Image); image != "example/proxy:v1" {
+		t.Errorf("proxy image = %#v", image)
+	}
+	if len(taskDefn.Volumes) != 1 || aws.StringValue(taskDefn.Volumes[0].Name) != "proxy-config" {
+		t.Errorf("volumes = %v", taskDefn.Volumes)
+	}
+	// Dependencies and the log configuration only apply to the containers of the template
+	if len(web.DependsOn) != 1 || aws.StringValue(web.DependsOn[0].ContainerName) != "proxy" {
+		t.Errorf("web depends on %v", web.DependsOn)
+	}
+	if web.LogConfiguration == nil || aws.StringValue(web.LogConfiguration.LogDriver) != "awsfirelens" {
+		t.Errorf("web log configuration = %v", web.LogConfiguration)
+	}
+	if len(proxy.DependsOn) != 0 || proxy.LogConfiguration != nil || logRouter.LogConfiguration != nil {
+		t.Errorf("sidecars changed: %v, %v", proxy, logRouter)
+	}
+}
+
+func TestAddSidecarConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		networkMode string
+		// existing and sidecar are the containers of the task definition and of the sidecar
+		existing *ecs.ContainerDefinition
+		sidecar  *ecs.ContainerDefinition
+		volume   *ecs.Volume
+		wantErr  string
+	}{
+		{
+			name:     "container name",
+			existing: &ecs.ContainerDefinition{Name: aws.String("proxy")},
+			sidecar:  &ecs.ContainerDefinition{Name: aws.String("proxy")},
+			wantErr:  `container "proxy" already exists`,
+		},
+		{
+			name:        "container port in awsvpc mode",
+			networkMode: ecs.NetworkModeAwsvpc,
+			existing:    containerWithPort("web", 8080, 0, ""),
+			sidecar:     containerWithPort("proxy", 8080, 0, ""),
+			wantErr:     "port 8080/tcp",
+		},
+		{
+			name:     "container port in bridge mode",
+			existing: containerWithPort("web", 8080, 0, ""),
+			sidecar:  containerWithPort("proxy", 8080, 0, ""),
+		},
+		{
+			name:     "host port in bridge mode",
+			existing: containerWithPort("web", 80, 8080, ""),
+			sidecar:  containerWithPort("proxy", 9901, 8080, "TCP"),
+			wantErr:  "port 8080/tcp",
+		},
+		{
+			name:        "another protocol",
+			networkMode: ecs.NetworkModeHost,
+			existing:    containerWithPort("web", 8125, 0, ""),
+			sidecar:     containerWithPort("statsd", 8125, 0, ecs.TransportProtocolUdp),
+		},
+		{
+			name:     "volume defined differently",
+			existing: &ecs.ContainerDefinition{Name: aws.String("web")},
+			sidecar:  &ecs.ContainerDefinition{Name: aws.String("proxy")},
+			volume:   &ecs.Volume{Name: aws.String("config"), Host: &ecs.HostVolumeProperties{SourcePath: aws.String("/etc/proxy")}},
+			wantErr:  `volume "config" is already defined differently`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taskDefn := &ecs.RegisterTaskDefinitionInput{
+				ContainerDefinitions: []*ecs.ContainerDefinition{test.existing},
+				Volumes:              []*ecs.Volume{{Name: aws.String("config")}},
+			}
+			if test.networkMode != "" {
+				taskDefn.NetworkMode = aws.String(test.networkMode)
+			}
+			sidecar := &Sidecar{ContainerDefinitions: []*ecs.ContainerDefinition{test.sidecar}}
+			if test.volume != nil {
+				sidecar.Volumes = []*ecs.Volume{test.volume}
+			}
+			err := addSidecar(taskDefn, taskDefn.ContainerDefinitions, sidecar)
+			checkError(t, err, test.wantErr)
+		})
+	}
+}
+
+func containerWithPort(name string, containerPort int64, hostPort int64, protocol string) *ecs.ContainerDefinition {
+	mapping := &ecs.PortMapping{ContainerPort: aws.Int64(containerPort)}
+	if hostPort != 0 {
+		mapping.HostPort = aws.Int64(hostPort)
+	}
+	if protocol != "" {
+		mapping.Protocol = aws.String(protocol)
+	}
+	return &ecs.ContainerDefinition{Name: aws.String(name), PortMappings: []*ecs.PortMapping{mapping}}
+}
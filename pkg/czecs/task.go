@@ -0,0 +1,237 @@
+package czecs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/chanzuckerberg/czecs/util"
+	"github.com/pkg/errors"
+)
+
+// RunTaskOptions configures RunTask.
+type RunTaskOptions struct {
+	// Template is the file name or URI of the task template, a RunTask input
+	Template string
+	Values   Values
+	// Cluster overrides the cluster of the task template, if set
+	Cluster string
+	// TaskDefinitionArn overrides the task definition of the task template, if set
+	TaskDefinitionArn string
+	// Timeout is the number of seconds to wait for the task to complete; 0 waits forever
+	Timeout int
+}
+
+// RunTaskResult describes the tasks started by RunTask, including failed ones.
+type RunTaskResult struct {
+	TaskDefinitionArn string       `json:"taskDefinitionArn"`
+	Tasks             []TaskResult `json:"tasks"`
+	// Failures are the tasks ECS failed to start or describe
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+// TaskResult describes one instance of a task.
+type TaskResult struct {
+	TaskArn       string            `json:"taskArn"`
+	LastStatus    string            `json:"lastStatus,omitempty"`
+	StoppedReason string            `json:"stoppedReason,omitempty"`
+	Containers    []ContainerResult `json:"containers,omitempty"`
+}
+
+// ContainerResult describes one container of a task. ExitCode is nil if the container did not run to completion.
+type ContainerResult struct {
+	Name     string `json:"name"`
+	ExitCode *int64 `json:"exitCode"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Failure is a failure reported by the ECS API for a single resource.
+type Failure struct {
+	Arn    string `json:"arn,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func failures(ecsFailures []*ecs.Failure) []Failure {
+	var result []Failure
+	for _, failure := range ecsFailures {
+		result = append(result, Failure{
+			Arn:    aws.StringValue(failure.Arn),
+			Reason: aws.StringValue(failure.Reason),
+			Detail: aws.StringValue(failure.Detail),
+		})
+	}
+	return result
+}
+
+// RenderTask renders the task template with the given values.
+func (c *Client) RenderTask(template string, values Values) (*ecs.RunTaskInput, error) {
+	template, err := tasks.Resolve(template)
+	if err != nil {
+		return nil, err
+	}
+	templateValues, err := c.templateValues(template, values)
+	if err != nil {
+		return nil, err
+	}
+	runTaskInput, err := tasks.ParseTask(template, templateValues, values.Strict, values.Partials)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse task")
+	}
+	return runTaskInput, nil
+}
+
+// RunTask runs a one-off task to completion. The task is successful if all containers of all instances
+// of the task exit with exit code 0. The result is returned even if the task failed, once it was started.
+func (c *Client) RunTask(opts RunTaskOptions) (*RunTaskResult, error) {
+	runTaskInput, err := c.RenderTask(opts.Template, opts.Values)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Cluster != "" {
+		runTaskInput.Cluster = &opts.Cluster
+	}
+	if opts.TaskDefinitionArn != "" {
+		runTaskInput.TaskDefinition = &opts.TaskDefinitionArn
+	}
+	return c.runTaskDefinition(runTaskInput, opts.Timeout)
+}
+
+// runTaskDefinition looks up the task definition of the task, then runs the task to completion.
+func (c *Client) runTaskDefinition(runTaskInput *ecs.RunTaskInput, timeout int) (*RunTaskResult, error) {
+	describeTaskDefinitionOutput, err := c.ECS.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: runTaskInput.TaskDefinition,
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error retrieving task definition ARN %#v; may not exist", aws.StringValue(runTaskInput.TaskDefinition))
+	}
+
+	return c.runTask(runTaskInput, describeTaskDefinitionOutput.TaskDefinition, timeout)
+}
+
+func (c *Client) runTask(task *ecs.RunTaskInput, taskDefinition *ecs.TaskDefinition, timeout int) (*RunTaskResult, error) {
+	c.Log.Infof("Running task %#v", *task)
+	runTaskOutput, err := c.ECS.RunTask(task)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RunTaskResult{
+		TaskDefinitionArn: aws.StringValue(taskDefinition.TaskDefinitionArn),
+		Failures:          failures(runTaskOutput.Failures),
+	}
+	taskArns := make([]*string, len(runTaskOutput.Tasks))
+	for i, task := range runTaskOutput.Tasks {
+		taskArns[i] = task.TaskArn
+		result.Tasks = append(result.Tasks, TaskResult{TaskArn: aws.StringValue(task.TaskArn)})
+	}
+	c.Log.Debugf("Run tasks output: Task ARNs: %#v, Failures %#v", taskArns, runTaskOutput.Failures)
+
+	for _, taskArn := range taskArns {
+		// Extract the task ID to derive the URL; have to parse it out of the ARN
+		taskArnParts := strings.Split(*taskArn, ":")
+		lastTaskArnPart := taskArnParts[len(taskArnParts)-1]
+		slashSplit := strings.Split(lastTaskArnPart, "/")
+		taskID := slashSplit[len(slashSplit)-1]
+
+		// Go through all container definitions, for any with awslogs fully configured, log URL to find task information.
+		// Since the run task can have multiple instances of the task, show all potential logs.
+		for _, containerDefn := range taskDefinition.ContainerDefinitions {
+			logConfiguration := containerDefn.LogConfiguration
+			if logConfiguration != nil && aws.StringValue(logConfiguration.LogDriver) == "awslogs" {
+				containerName := *containerDefn.Name
+				// awslogs-stream-prefix is optional if on EC2 ECS, and would default to the instance ID it is scheduled on.
+				// Since in such cases we would not be able to extract/predict the instance ID, we don't log the task log location
+				// unless awslogs-stream-prefix is explicitly provided.
+				if streamPrefix, ok := logConfiguration.Options["awslogs-stream-prefix"]; ok {
+					// awslogs-group and awslogs-region are required container definition arguments; if we got here, we can assume
+					// they are in the options without explicitly checking for existence.
+					logGroup := logConfiguration.Options["awslogs-group"]
+					region := logConfiguration.Options["awslogs-region"]
+					c.Log.Infof("Task log location: https://%s.console.aws.amazon.com/cloudwatch/home?region=%s#logEventViewer:group=%s;stream=%s/%s/%s", *region, *region, *logGroup, *streamPrefix, containerName, taskID)
+				}
+			}
+		}
+	}
+
+	// Intentionally do the failure check after logging the task locations of those that were sucessful
+	if len(runTaskOutput.Failures) != 0 {
+		return result, fmt.Errorf("failed to start all instances of task %s; failures %#v", *task.TaskDefinition, runTaskOutput.Failures)
+	}
+
+	opts := c.waiterOptions(util.WaiterDelay(timeout, 6)...)
+	if c.Progress != nil {
+		opts = append(opts, util.SleepProgress(c.Progress))
+
+		// Intentionally writing directly, since we want this to be on the same line as the
+		// progress dots.
+		taskArnStrings := make([]string, len(taskArns))
+		for i, taskArn := range taskArns {
+			taskArnStrings[i] = *taskArn
+		}
+		fmt.Fprintf(c.Progress, "Waiting for tasks %v to finish", taskArnStrings)
+	}
+
+	// Note: Default is 10 minutes; is this enough?
+	// If not can add WithWaiterMaxAttempts to opts above to adjust
+	err = c.checkAborted(c.ECS.WaitUntilTasksStoppedWithContext(
+		c.context(),
+		&ecs.DescribeTasksInput{
+			Cluster: task.Cluster,
+			Tasks:   taskArns},
+		opts...))
+	if err != nil {
+		return result, errors.Wrap(err, "error while waiting for task instances to complete")
+	}
+
+	// Check that all exit codes of all containers in all tasks had exit code zero.
+	describeTasksOutput, err := c.ECS.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: task.Cluster,
+		Tasks:   taskArns,
+	})
+	if err != nil {
+		return result, errors.Wrap(err, "unable to retrive task statuses during verification")
+	}
+	result.Failures = append(result.Failures, failures(describeTasksOutput.Failures)...)
+	result.Tasks = nil
+	for _, task := range describeTasksOutput.Tasks {
+		taskResult := TaskResult{
+			TaskArn:       aws.StringValue(task.TaskArn),
+			LastStatus:    aws.StringValue(task.LastStatus),
+			StoppedReason: aws.StringValue(task.StoppedReason),
+		}
+		for _, container := range task.Containers {
+			taskResult.Containers = append(taskResult.Containers, ContainerResult{
+				Name:     aws.StringValue(container.Name),
+				ExitCode: container.ExitCode,
+				Reason:   aws.StringValue(container.Reason),
+			})
+		}
+		result.Tasks = append(result.Tasks, taskResult)
+	}
+
+	if len(describeTasksOutput.Failures) != 0 {
+		return result, fmt.Errorf("failures occured while running tasks: %#v", describeTasksOutput.Failures)
+	}
+	if len(describeTasksOutput.Tasks) != len(taskArns) {
+		// Somehow have no failures, but also missing tasks; really unexpected error
+		return result, fmt.Errorf("tried to retrieve %d task ARN(s) %#v, but only retrieved info about %d tasks", len(taskArns), taskArns, len(describeTasksOutput.Tasks))
+	}
+	for _, task := range describeTasksOutput.Tasks {
+		if *task.LastStatus != "STOPPED" {
+			return result, fmt.Errorf("expected all tasks to be stopped, but task ARN %s was in state %#v", *task.TaskArn, task.LastStatus)
+		}
+		for _, container := range task.Containers {
+			if container.ExitCode == nil {
+				return result, fmt.Errorf("container %s in task %s has no exit code; task may have failed before container started", *container.Name, *task.TaskArn)
+			}
+			if *container.ExitCode != 0 {
+				return result, fmt.Errorf("container %s in task %s exited with non-zero exit code %d; see logs for details", *container.Name, *task.TaskArn, *container.ExitCode)
+			}
+		}
+	}
+	return result, nil
+}
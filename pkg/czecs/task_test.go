@@ -0,0 +1,72 @@
+package czecs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestRunTask(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior ecsfake.Behavior
+
+		wantErr string
+		// wantExitCode is the exit code of the migrate container, or nil if it should not have one
+		wantExitCode *int64
+		wantFailures int
+	}{
+		{
+			name:         "success",
+			wantExitCode: aws.Int64(0),
+		},
+		{
+			name:         "non-zero exit code",
+			behavior:     ecsfake.Behavior{ExitCodes: map[string]int64{"migrate": 3}},
+			wantErr:      "exited with non-zero exit code 3",
+			wantExitCode: aws.Int64(3),
+		},
+		{
+			name:         "failure to start",
+			behavior:     ecsfake.Behavior{StartFailure: "RESOURCE:MEMORY"},
+			wantErr:      "failed to start all instances",
+			wantFailures: 1,
+		},
+		{
+			name:     "timeout",
+			behavior: ecsfake.Behavior{Steps: 10},
+			wantErr:  "exceeded wait attempts",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			fake.Behave("test-migrate", test.behavior)
+			c := newTestClient(fake)
+			if _, err := c.Register(RegisterOptions{Template: "testdata/migrate.json", Values: webValues("v1")}); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := c.RunTask(RunTaskOptions{
+				Template: "testdata/migrate-task.json",
+				Cluster:  testCluster,
+				Timeout:  6,
+			})
+			checkError(t, err, test.wantErr)
+			if result.TaskDefinitionArn != fakeTaskDefinitionArn("test-migrate:1") {
+				t.Errorf("TaskDefinitionArn = %#v", result.TaskDefinitionArn)
+			}
+			if len(result.Failures) != test.wantFailures {
+				t.Errorf("Failures = %#v, want %d", result.Failures, test.wantFailures)
+			}
+			var exitCode *int64
+			if len(result.Tasks) == 1 && len(result.Tasks[0].Containers) == 1 {
+				exitCode = result.Tasks[0].Containers[0].ExitCode
+			}
+			if (exitCode == nil) != (test.wantExitCode == nil) || (exitCode != nil && *exitCode != *test.wantExitCode) {
+				t.Errorf("exit code = %v, want %v", aws.Int64Value(exitCode), aws.Int64Value(test.wantExitCode))
+			}
+		})
+	}
+}
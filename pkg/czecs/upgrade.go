@@ -0,0 +1,202 @@
+package czecs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// UpgradeOptions configures Upgrade.
+type UpgradeOptions struct {
+	InstallOptions
+	// Deregister removes the old task definition on success; if the deployment failed and Template is set,
+	// the new task definition is removed instead
+	Deregister bool
+	// SuspendAutoScaling suspends the auto scaling of the service during the upgrade, including pre and post
+	// deploy tasks and any rollback, restoring it afterwards
+	SuspendAutoScaling bool
+}
+
+// UpgradeResult describes the outcome of an upgrade, including a failed one.
+type UpgradeResult struct {
+	// OldTaskDefinition is the task definition the service ran before the upgrade
+	OldTaskDefinition string `json:"oldTaskDefinition"`
+	// TaskDefinitionArn is the new task definition
+	TaskDefinitionArn string `json:"taskDefinitionArn,omitempty"`
+	// DeploymentID is the ID of the deployment to the new task definition
+	DeploymentID string         `json:"deploymentId,omitempty"`
+	PreTask      *RunTaskResult `json:"preTask,omitempty"`
+	PostTask     *RunTaskResult `json:"postTask,omitempty"`
+	// RolledBack is set if the service was returned to the old task definition after the deployment failed
+	RolledBack bool `json:"rolledBack"`
+	// OnNewTaskDefinition is set if the service was updated to the new task definition and not rolled back
+	OnNewTaskDefinition bool `json:"onNewTaskDefinition"`
+}
+
+// FindService returns the service, or nil if it does not exist or was deleted (i.e. is INACTIVE).
+func (c *Client) FindService(cluster string, service string) (*ecs.Service, error) {
+	describeServicesOutput, err := c.ECS.DescribeServices(&ecs.DescribeServicesInput{
+		Cluster:  &cluster,
+		Services: []*string{&service},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot describe services")
+	}
+	for _, failure := range describeServicesOutput.Failures {
+		if aws.StringValue(failure.Reason) != "MISSING" {
+			return nil, fmt.Errorf("Error retrieving information about existing service %#v: %#v", service, describeServicesOutput.Failures)
+		}
+	}
+	for _, existingService := range describeServicesOutput.Services {
+		if *existingService.ServiceName == service || *existingService.ServiceArn == service {
+			if aws.StringValue(existingService.Status) == ServiceInactive {
+				return nil, nil
+			}
+			return existingService, nil
+		}
+	}
+	return nil, nil
+}
+
+// DescribeService returns the existing service, failing if it does not exist.
+func (c *Client) DescribeService(cluster string, service string) (*ecs.Service, error) {
+	existingService, err := c.FindService(cluster, service)
+	if err != nil {
+		return nil, err
+	}
+	if existingService == nil {
+		return nil, fmt.Errorf("Service %#v does not exist in cluster %#v. Use outside tool or czecs install to create service", service, cluster)
+	}
+	return existingService, nil
+}
+
+// Upgrade updates an existing service to a new task definition and waits for it to become stable,
+// rolling it back on failure if enabled. The result is returned even if the upgrade failed, unless
+// the service could not be found.
+func (c *Client) Upgrade(opts UpgradeOptions) (*UpgradeResult, error) {
+	service, err := c.DescribeService(opts.Cluster, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	result := &UpgradeResult{OldTaskDefinition: aws.StringValue(service.TaskDefinition)}
+	c.Log.Infof("Existing task definition %#v", result.OldTaskDefinition)
+
+	// Suspended before registering, so that failing to suspend leaves no new task definition behind
+	restoreAutoScaling := func() error { return nil }
+	if opts.SuspendAutoScaling {
+		if restoreAutoScaling, err = c.SuspendAutoScaling(opts.Cluster, opts.Service); err != nil {
+			return result, err
+		}
+	}
+	result.TaskDefinitionArn, err = c.NewTaskDefinition(opts.Template, opts.Values, opts.TaskDefinitionArn)
+	if err == nil {
+		err = c.upgrade(opts, service.DeploymentConfiguration, result)
+		// Only remove a new task definition registered here, once the service no longer uses it
+		if err != nil && opts.Template != "" && (opts.Deregister || opts.Rollback) && !result.OnNewTaskDefinition {
+			c.DeregisterTaskDefinition(result.TaskDefinitionArn, "new")
+		}
+	}
+	if restoreErr := restoreAutoScaling(); restoreErr != nil {
+		if err != nil {
+			c.Log.Errorf("Cannot restore auto scaling of service %#v: %s", opts.Service, restoreErr)
+			return result, err
+		}
+		return result, restoreErr
+	}
+	return result, err
+}
+
+func (c *Client) upgrade(opts UpgradeOptions, deploymentConfiguration *ecs.DeploymentConfiguration, result *UpgradeResult) error {
+	if opts.PreTask != "" {
+		var err error
+		if result.PreTask, err = c.runDeploymentTask(opts.InstallOptions, opts.PreTask, result.TaskDefinitionArn); err != nil {
+			return errors.Wrap(err, "pre-deploy task failed; service not upgraded")
+		}
+	}
+
+	result.OnNewTaskDefinition = true
+	deploymentID, err := c.deployUpgrade(opts, deploymentConfiguration, result.TaskDefinitionArn)
+	result.DeploymentID = deploymentID
+	if err == nil && opts.PostTask != "" {
+		if result.PostTask, err = c.runDeploymentTask(opts.InstallOptions, opts.PostTask, result.TaskDefinitionArn); err != nil {
+			err = errors.Wrap(err, "post-deploy task failed")
+		}
+	}
+	if err != nil {
+		if abortErr := c.aborted(); opts.Rollback && abortErr != nil {
+			c.Log.Warnf("Not rolling back service %#v, since the upgrade was aborted: %s", opts.Service, abortErr)
+		} else if opts.Rollback {
+			c.Log.Warnf("Rolling back service %#v to old task definition %#v", opts.Service, result.OldTaskDefinition)
+			if _, rollbackErr := c.deployUpgrade(opts, deploymentConfiguration, result.OldTaskDefinition); rollbackErr != nil {
+				// TODO(mbarrien): Report original
+				return errors.Wrap(rollbackErr, "cannot rollback")
+			}
+			result.RolledBack = true
+			result.OnNewTaskDefinition = false
+		}
+		return err
+	}
+
+	if opts.Deregister && result.OldTaskDefinition != result.TaskDefinitionArn {
+		c.DeregisterTaskDefinition(result.OldTaskDefinition, "old")
+	}
+	return nil
+}
+
+// deployUpgrade updates the service and waits for it to become stable, returning the ID of the new deployment.
+func (c *Client) deployUpgrade(opts UpgradeOptions, deploymentConfiguration *ecs.DeploymentConfiguration, taskDefnArn string) (string, error) {
+	c.Log.Infof("Updating service %#v in cluster %#v to task definition %#v", opts.Service, opts.Cluster, taskDefnArn)
+	c.Log.Infof("Service info location: https://%s.console.aws.amazon.com/ecs/home?region=%s#/clusters/%s/services/%s/details", c.Region, c.Region, opts.Cluster, opts.Service)
+
+	updateServiceInput := &ecs.UpdateServiceInput{
+		Cluster:        &opts.Cluster,
+		Service:        &opts.Service,
+		TaskDefinition: &taskDefnArn,
+	}
+	if opts.CircuitBreaker {
+		// Keep the rest of the existing deployment configuration as is
+		newDeploymentConfiguration := &ecs.DeploymentConfiguration{}
+		if deploymentConfiguration != nil {
+			*newDeploymentConfiguration = *deploymentConfiguration
+		}
+		newDeploymentConfiguration.DeploymentCircuitBreaker = &ecs.DeploymentCircuitBreaker{
+			Enable:   aws.Bool(true),
+			Rollback: aws.Bool(true),
+		}
+		updateServiceInput.DeploymentConfiguration = newDeploymentConfiguration
+	}
+
+	return c.deployUpdate(opts.InstallOptions, updateServiceInput)
+}
+
+// deployUpdate updates the service and waits for its new primary deployment to become stable, returning
+// the ID of the deployment.
+func (c *Client) deployUpdate(opts InstallOptions, updateServiceInput *ecs.UpdateServiceInput) (string, error) {
+	// Get the primary deployment's updated date, default to now if missing
+	updatedAt := time.Now()
+	var deploymentID string
+	updateServiceOutput, err := c.ECS.UpdateService(updateServiceInput)
+	if err != nil {
+		// TODO(mbarrien) Avoid rollback?
+		return "", err
+	}
+	for _, deployment := range updateServiceOutput.Service.Deployments {
+		if *deployment.Status == "PRIMARY" {
+			updatedAt = *deployment.UpdatedAt
+			deploymentID = aws.StringValue(deployment.Id)
+			break
+		}
+	}
+
+	c.Log.Infof("Waiting for service %#v in cluster %#v to be stable", opts.Service, opts.Cluster)
+
+	return deploymentID, c.checkAborted(c.ECS.WaitUntilServicesStableWithContext(
+		c.context(),
+		&ecs.DescribeServicesInput{
+			Cluster:  &opts.Cluster,
+			Services: []*string{updateServiceOutput.Service.ServiceArn}},
+		c.deploymentWaiterOptions(opts, deploymentID, updatedAt)...))
+}
@@ -0,0 +1,203 @@
+package czecs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+)
+
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior ecsfake.Behavior
+		opts     UpgradeOptions
+		// aborted cancels the context of the client before upgrading, as losing the deployment lock does
+		aborted bool
+
+		wantErr                 string
+		wantRolledBack          bool
+		wantOnNewTaskDefinition bool
+		// wantTaskDefinition is the task definition of the service afterwards
+		wantTaskDefinition string
+		// wantOld and wantNew are the statuses of the old and new task definitions afterwards
+		wantOld string
+		wantNew string
+	}{
+		{
+			name:                    "success",
+			opts:                    UpgradeOptions{Deregister: true},
+			wantOnNewTaskDefinition: true,
+			wantTaskDefinition:      "test-web:2",
+			wantOld:                 ecs.TaskDefinitionStatusInactive,
+			wantNew:                 ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "unable to place tasks, rolled back",
+			behavior:           ecsfake.Behavior{Unable: true},
+			opts:               UpgradeOptions{InstallOptions: InstallOptions{Rollback: true}, Deregister: true},
+			wantErr:            "unable to place a task",
+			wantRolledBack:     true,
+			wantTaskDefinition: "test-web:1",
+			wantOld:            ecs.TaskDefinitionStatusActive,
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:     "circuit breaker failed",
+			behavior: ecsfake.Behavior{Unable: true},
+			opts:     UpgradeOptions{InstallOptions: InstallOptions{CircuitBreaker: true}},
+			wantErr:  "ECS deployment circuit breaker",
+			// The circuit breaker rolls the service back by itself
+			wantOnNewTaskDefinition: true,
+			wantTaskDefinition:      "test-web:1",
+			wantOld:                 ecs.TaskDefinitionStatusActive,
+			wantNew:                 ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:                    "timeout",
+			behavior:                ecsfake.Behavior{Steps: 10},
+			wantErr:                 "exceeded wait attempts",
+			wantOnNewTaskDefinition: true,
+			wantTaskDefinition:      "test-web:2",
+			wantOld:                 ecs.TaskDefinitionStatusActive,
+			wantNew:                 ecs.TaskDefinitionStatusActive,
+		},
+		{
+			name:               "timeout, rolled back",
+			behavior:           ecsfake.Behavior{Steps: 10},
+			opts:               UpgradeOptions{InstallOptions: InstallOptions{Rollback: true}, Deregister: true},
+			wantErr:            "exceeded wait attempts",
+			wantRolledBack:     true,
+			wantTaskDefinition: "test-web:1",
+			wantOld:            ecs.TaskDefinitionStatusActive,
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:     "pre-deploy task failed",
+			behavior: ecsfake.Behavior{ExitCodes: map[string]int64{"web": 1}},
+			opts:     UpgradeOptions{InstallOptions: InstallOptions{PreTask: "testdata/deploy-task.json"}, Deregister: true},
+			wantErr:  "pre-deploy task failed; service not upgraded",
+			// The service never used the new task definition, so it is removed
+			wantTaskDefinition: "test-web:1",
+			wantOld:            ecs.TaskDefinitionStatusActive,
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:               "post-deploy task failed, rolled back",
+			behavior:           ecsfake.Behavior{ExitCodes: map[string]int64{"web": 1}},
+			opts:               UpgradeOptions{InstallOptions: InstallOptions{Rollback: true, PostTask: "testdata/deploy-task.json"}, Deregister: true},
+			wantErr:            "post-deploy task failed",
+			wantRolledBack:     true,
+			wantTaskDefinition: "test-web:1",
+			wantOld:            ecs.TaskDefinitionStatusActive,
+			wantNew:            ecs.TaskDefinitionStatusInactive,
+		},
+		{
+			name:                    "aborted, not rolled back",
+			opts:                    UpgradeOptions{InstallOptions: InstallOptions{Rollback: true}, Deregister: true},
+			aborted:                 true,
+			wantErr:                 "wait aborted: context canceled",
+			wantOnNewTaskDefinition: true,
+			wantTaskDefinition:      "test-web:2",
+			wantOld:                 ecs.TaskDefinitionStatusActive,
+			wantNew:                 ecs.TaskDefinitionStatusActive,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New(testCluster)
+			fake.Behave("test-web:2", test.behavior)
+			c := newTestClient(fake)
+			installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+			if _, err := c.Install(installOpts); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.aborted {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				c.Context = ctx
+			}
+
+			opts := test.opts
+			opts.Cluster, opts.Service = testCluster, "web"
+			opts.Template, opts.Values = "testdata/web.json", webValues("v2")
+			opts.Timeout = 15
+			result, err := c.Upgrade(opts)
+			checkError(t, err, test.wantErr)
+			if result.OldTaskDefinition != fakeTaskDefinitionArn("test-web:1") || result.TaskDefinitionArn != fakeTaskDefinitionArn("test-web:2") {
+				t.Errorf("OldTaskDefinition = %#v, TaskDefinitionArn = %#v", result.OldTaskDefinition, result.TaskDefinitionArn)
+			}
+			if result.RolledBack != test.wantRolledBack {
+				t.Errorf("RolledBack = %v, want %v", result.RolledBack, test.wantRolledBack)
+			}
+			if result.OnNewTaskDefinition != test.wantOnNewTaskDefinition {
+				t.Errorf("OnNewTaskDefinition = %v, want %v", result.OnNewTaskDefinition, test.wantOnNewTaskDefinition)
+			}
+
+			// Let the service settle, e.g. a circuit breaker rollback
+			var service *ecs.Service
+			for i := 0; i < 20; i++ {
+				service = testService(t, fake, "web")
+			}
+			if got := aws.StringValue(service.TaskDefinition); got != fakeTaskDefinitionArn(test.wantTaskDefinition) {
+				t.Errorf("service task definition = %#v, want %#v", got, test.wantTaskDefinition)
+			}
+			if status := taskDefinitionStatus(t, fake, "test-web:1"); status != test.wantOld {
+				t.Errorf("old task definition status = %#v, want %#v", status, test.wantOld)
+			}
+			if status := taskDefinitionStatus(t, fake, "test-web:2"); status != test.wantNew {
+				t.Errorf("new task definition status = %#v, want %#v", status, test.wantNew)
+			}
+		})
+	}
+}
+
+func TestUpgradeMissingService(t *testing.T) {
+	fake := ecsfake.New(testCluster)
+	_, err := newTestClient(fake).Upgrade(UpgradeOptions{InstallOptions: InstallOptions{
+		Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"),
+	}})
+	checkError(t, err, "does not exist")
+	if calls := fake.Calls(); len(calls) != 1 {
+		t.Errorf("expected only DescribeServices to be called, got %v", calls)
+	}
+}
+
+// failingAutoScaling is an Application Auto Scaling client whose calls fail.
+type failingAutoScaling struct {
+	applicationautoscalingiface.ApplicationAutoScalingAPI
+}
+
+func (failingAutoScaling) DescribeScalableTargets(*applicationautoscaling.DescribeScalableTargetsInput) (*applicationautoscaling.DescribeScalableTargetsOutput, error) {
+	return nil, errors.New("AccessDeniedException")
+}
+
+func TestUpgradeSuspendAutoScalingFails(t *testing.T) {
+	fake := ecsfake.New(testCluster)
+	c := newTestClient(fake)
+	c.AutoScaling = failingAutoScaling{}
+	installOpts := InstallOptions{Cluster: testCluster, Service: "web", Template: "testdata/web.json", Values: webValues("v1"), Timeout: 15}
+	if _, err := c.Install(installOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := UpgradeOptions{InstallOptions: installOpts, Deregister: true, SuspendAutoScaling: true}
+	opts.Values = webValues("v2")
+	_, err := c.Upgrade(opts)
+	checkError(t, err, "AccessDeniedException")
+	registered := 0
+	for _, call := range fake.Calls() {
+		if call == "RegisterTaskDefinition" {
+			registered++
+		}
+	}
+	if registered != 1 {
+		t.Errorf("expected no task definition to be registered by the upgrade, got %d registrations", registered-1)
+	}
+}
@@ -0,0 +1,278 @@
+package czecs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/chanzuckerberg/czecs/schema"
+	"github.com/chanzuckerberg/czecs/tasks"
+	"github.com/imdario/mergo"
+	"github.com/pkg/errors"
+	"k8s.io/helm/pkg/strvals"
+)
+
+// Values are the values filling in a task definition or task template, available as .Values in the template.
+type Values struct {
+	// BalanceFiles are JSON files or URIs of values; later files override earlier ones
+	BalanceFiles []string
+	// Set are values of the form key=value as accepted by --set, overriding the balance files
+	Set []string
+	// SetString are values like Set, but always parsed as strings
+	SetString []string
+	// Schema is a JSON Schema file or URI the merged values must match; if empty, the values.schema.json
+	// next to a local template is used if it exists
+	Schema string
+	// Partials are directories of _*.tpl partials, or files or URIs of partials, whose named templates can be
+	// included by templates, in addition to the partials next to the template
+	Partials []string
+	// Sidecars are added to task definitions (not tasks); each is the name of a sidecar in the sidecars
+	// directory next to the template, or a file name or URI of a sidecar (see Sidecar)
+	Sidecars []string
+	// Strict fails rendering templates on lint warnings
+	Strict bool
+}
+
+// DefaultSchemaFile is the file name of the values schema looked for next to templates.
+const DefaultSchemaFile = "values.schema.json"
+
+// schemaFor returns the values schema of the given template, or "" if there is none.
+func (v Values) schemaFor(template string) string {
+	if v.Schema != "" {
+		return v.Schema
+	}
+	if template == "" || tasks.IsURI(template) {
+		return ""
+	}
+	path := filepath.Join(filepath.Dir(template), DefaultSchemaFile)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Validate validates the merged values against the values schema of the given template, if any.
+func (v Values) Validate(template string, values map[string]interface{}) error {
+	schemaFile := v.schemaFor(template)
+	if schemaFile == "" {
+		return nil
+	}
+	rawSchema, err := tasks.ReadFileOrURI(schemaFile)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading values schema %v", schemaFile)
+	}
+	valuesSchema, err := schema.Parse(rawSchema)
+	if err != nil {
+		return errors.Wrapf(err, "invalid values schema %v", schemaFile)
+	}
+	if err := valuesSchema.Validate(values, ".Values"); err != nil {
+		return errors.Wrapf(err, "values do not match schema %v", schemaFile)
+	}
+	return nil
+}
+
+// Explanation describes how the values were merged.
+type Explanation struct {
+	// Values are the merged values
+	Values map[string]interface{} `json:"-"`
+	// Explained has every value that is not a map, sorted by path
+	Explained []ExplainedValue `json:"values"`
+	// TypeChanges are the values whose type was changed by a later source
+	TypeChanges []TypeChange `json:"typeChanges,omitempty"`
+}
+
+// ExplainedValue is a value of the merged values, along with every source that set it.
+type ExplainedValue struct {
+	// Path is the dotted path of the value, e.g. "image.tag"; lists are a single value
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// Sources are the balance files, or --set or --set-string values, that set the value in
+	// increasing precedence; the last one provided the final value
+	Sources []string `json:"sources"`
+}
+
+// TypeChange is a value replaced by a value of another type by a later source, e.g. a map by a string.
+type TypeChange struct {
+	Path      string `json:"path"`
+	OldType   string `json:"oldType"`
+	OldSource string `json:"oldSource"`
+	NewType   string `json:"newType"`
+	NewSource string `json:"newSource"`
+}
+
+func (t TypeChange) String() string {
+	return fmt.Sprintf("%s of type %s from %s is replaced by a value of type %s from %s", t.Path, t.OldType, t.OldSource, t.NewType, t.NewSource)
+}
+
+// Merge merges all values, in increasing precedence.
+func (v Values) Merge() (map[string]interface{}, error) {
+	explanation, err := v.Explain()
+	if err != nil {
+		return nil, err
+	}
+	return explanation.Values, nil
+}
+
+// Explain merges all values like Merge, recording the sources that set each value.
+//
+// Maps are merged key by key, and any other value, including lists, replaces the previous one as a whole. A
+// value of another type replaces the previous value entirely too: a string set where a map was drops the whole
+// map, and --set a.b=1 where a was a string makes a the map {b: 1}. Such replacements are listed in TypeChanges,
+// since they are usually mistakes.
+func (v Values) Explain() (*Explanation, error) {
+	e := &explainer{
+		values:  map[string]interface{}{},
+		sources: map[string][]string{},
+		nodes:   map[string]valueNode{},
+	}
+	for _, filePath := range v.BalanceFiles {
+		balances, err := tasks.ParseBalances(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergo.Merge(&e.values, balances, mergo.WithOverride); err != nil {
+			return nil, err
+		}
+		e.add(balances, filePath, "")
+	}
+	for _, value := range v.Set {
+		set, err := strvals.Parse(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set data")
+		}
+		clearReplaced(e.values, set)
+		if err := strvals.ParseInto(value, e.values); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set data")
+		}
+		e.add(set, "--set "+value, "")
+	}
+	for _, value := range v.SetString {
+		set, err := strvals.ParseString(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-string data")
+		}
+		clearReplaced(e.values, set)
+		if err := strvals.ParseIntoString(value, e.values); err != nil {
+			return nil, errors.Wrap(err, "failed parsing --set-string data")
+		}
+		e.add(set, "--set-string "+value, "")
+	}
+	return e.explanation(), nil
+}
+
+// clearReplaced removes the values that set replaces by a map or a list of another type, so that set replaces
+// them as described by Explain; strvals panics when a path of --set goes through a value of another type. Values
+// of the same type are left for strvals to merge into, and values that are not maps or lists are overwritten by it.
+func clearReplaced(values map[string]interface{}, set map[string]interface{}) {
+	for key, value := range set {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if existing, ok := values[key].(map[string]interface{}); ok {
+				clearReplaced(existing, value)
+			} else {
+				delete(values, key)
+			}
+		case []interface{}:
+			if _, ok := values[key].([]interface{}); !ok {
+				delete(values, key)
+			}
+		}
+	}
+}
+
+// valueNode is the type of a value (including maps) and the source that last set it.
+type valueNode struct {
+	typeName string
+	source   string
+}
+
+type explainer struct {
+	values map[string]interface{}
+	// sources are the sources of every value that is not a map, by path
+	sources map[string][]string
+	// nodes are all values, including maps, by path
+	nodes       map[string]valueNode
+	typeChanges []TypeChange
+}
+
+// add records source as a source of every value of values.
+func (e *explainer) add(values map[string]interface{}, source string, prefix string) {
+	for key, value := range values {
+		path := prefix + key
+		typeName := valueType(value)
+		if old, ok := e.nodes[path]; ok && old.typeName != typeName {
+			e.typeChanges = append(e.typeChanges, TypeChange{
+				Path:      path,
+				OldType:   old.typeName,
+				OldSource: old.source,
+				NewType:   typeName,
+				NewSource: source,
+			})
+		}
+		e.nodes[path] = valueNode{typeName: typeName, source: source}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			e.add(nested, source, path+".")
+			continue
+		}
+		e.sources[path] = append(e.sources[path], source)
+	}
+}
+
+func (e *explainer) explanation() *Explanation {
+	explanation := &Explanation{Values: e.values}
+	explainValues(explanation, e.values, e.sources, "")
+	sort.Slice(explanation.Explained, func(i, j int) bool {
+		return explanation.Explained[i].Path < explanation.Explained[j].Path
+	})
+	sort.SliceStable(e.typeChanges, func(i, j int) bool {
+		return e.typeChanges[i].Path < e.typeChanges[j].Path
+	})
+	explanation.TypeChanges = e.typeChanges
+	return explanation
+}
+
+func explainValues(explanation *Explanation, values map[string]interface{}, sources map[string][]string, prefix string) {
+	for key, value := range values {
+		path := prefix + key
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			explainValues(explanation, nested, sources, path+".")
+			continue
+		}
+		explanation.Explained = append(explanation.Explained, ExplainedValue{
+			Path:    path,
+			Value:   value,
+			Sources: sources[path],
+		})
+	}
+}
+
+// Sources returns the source of the final value of every value that is not a map, by path.
+func (e *Explanation) Sources() map[string]string {
+	sources := map[string]string{}
+	for _, value := range e.Explained {
+		if len(value.Sources) > 0 {
+			sources[value.Path] = value.Sources[len(value.Sources)-1]
+		}
+	}
+	return sources
+}
+
+// valueType returns the JSON type of a value parsed from balances or --set.
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64, float32, int, int64, int32:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,179 @@
+package czecs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+	"github.com/sirupsen/logrus"
+)
+
+// writeBalances writes balance files with the given contents to a temporary directory, returning their paths.
+func writeBalances(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var files []string
+	for i, content := range contents {
+		file := filepath.Join(dir, string(rune('a'+i))+".json")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name      string
+		balances  []string
+		set       []string
+		setString []string
+
+		want map[string]interface{}
+		// wantSources are the sources of every value that is not a map, by path; balance files are named
+		// by their index
+		wantSources map[string][]string
+		// wantTypeChanges are the paths of the values whose type changed
+		wantTypeChanges []string
+	}{
+		{
+			name:     "maps are merged",
+			balances: []string{`{"image": {"name": "web", "tag": "v1"}, "cpu": 256}`, `{"image": {"tag": "v2"}}`},
+			set:      []string{"image.registry=example.com"},
+			want: map[string]interface{}{
+				"image": map[string]interface{}{"name": "web", "tag": "v2", "registry": "example.com"},
+				"cpu":   float64(256),
+			},
+			wantSources: map[string][]string{
+				"cpu":            {"0"},
+				"image.name":     {"0"},
+				"image.tag":      {"0", "1"},
+				"image.registry": {"--set image.registry=example.com"},
+			},
+		},
+		{
+			name:      "overrides in increasing precedence",
+			balances:  []string{`{"tag": "v1", "count": 1}`},
+			set:       []string{"tag=v2", "count=2"},
+			setString: []string{"count=3"},
+			want:      map[string]interface{}{"tag": "v2", "count": "3"},
+			wantSources: map[string][]string{
+				"tag":   {"0", "--set tag=v2"},
+				"count": {"0", "--set count=2", "--set-string count=3"},
+			},
+			wantTypeChanges: []string{"count"},
+		},
+		{
+			name:     "lists are replaced",
+			balances: []string{`{"ports": [80, 443]}`, `{"ports": [8080]}`},
+			want:     map[string]interface{}{"ports": []interface{}{float64(8080)}},
+			wantSources: map[string][]string{
+				"ports": {"0", "1"},
+			},
+		},
+		{
+			name:     "map replaced by a string",
+			balances: []string{`{"image": {"name": "web", "tag": "v1"}}`},
+			set:      []string{"image=web:v2"},
+			want:     map[string]interface{}{"image": "web:v2"},
+			wantSources: map[string][]string{
+				"image": {"--set image=web:v2"},
+			},
+			wantTypeChanges: []string{"image"},
+		},
+		{
+			name:     "string replaced by a map",
+			balances: []string{`{"image": "web:v1", "cpu": 256}`},
+			set:      []string{"image.tag=v2"},
+			want: map[string]interface{}{
+				"image": map[string]interface{}{"tag": "v2"},
+				"cpu":   float64(256),
+			},
+			wantSources: map[string][]string{
+				"cpu":       {"0"},
+				"image.tag": {"--set image.tag=v2"},
+			},
+			wantTypeChanges: []string{"image"},
+		},
+		{
+			name:     "string replaced by a list",
+			balances: []string{`{"hosts": "example.com"}`},
+			set:      []string{"hosts[0]=example.org"},
+			want:     map[string]interface{}{"hosts": []interface{}{"example.org"}},
+			wantSources: map[string][]string{
+				"hosts": {"0", "--set hosts[0]=example.org"},
+			},
+			wantTypeChanges: []string{"hosts"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := writeBalances(t, test.balances...)
+			explanation, err := Values{BalanceFiles: files, Set: test.set, SetString: test.setString}.Explain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(explanation.Values, test.want) {
+				t.Errorf("Values = %#v, want %#v", explanation.Values, test.want)
+			}
+
+			sources := map[string][]string{}
+			for _, value := range explanation.Explained {
+				for _, source := range value.Sources {
+					for i, file := range files {
+						if source == file {
+							source = string(rune('0' + i))
+						}
+					}
+					sources[value.Path] = append(sources[value.Path], source)
+				}
+			}
+			if !reflect.DeepEqual(sources, test.wantSources) {
+				t.Errorf("sources = %v, want %v", sources, test.wantSources)
+			}
+
+			var typeChanges []string
+			for _, change := range explanation.TypeChanges {
+				typeChanges = append(typeChanges, change.Path)
+			}
+			if !reflect.DeepEqual(typeChanges, test.wantTypeChanges) {
+				t.Errorf("TypeChanges = %v, want %v", typeChanges, test.wantTypeChanges)
+			}
+		})
+	}
+}
+
+func TestExplainSources(t *testing.T) {
+	files := writeBalances(t, `{"image": {"tag": "v1"}}`)
+	explanation, err := Values{BalanceFiles: files, Set: []string{"image.tag=v2"}}.Explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := explanation.Sources(), map[string]string{"image.tag": "--set image.tag=v2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sources() = %v, want %v", got, want)
+	}
+}
+
+func TestTemplateValuesWarnsAboutTypeChanges(t *testing.T) {
+	// Type changes are warned about whether strict or not
+	for _, strict := range []bool{false, true} {
+		var out bytes.Buffer
+		log := logrus.New()
+		log.Out = &out
+		c := newTestClient(ecsfake.New(testCluster))
+		c.Log = log
+
+		values := Values{Set: []string{"tag=v1", "tag.name=v2"}, Strict: strict}
+		if _, err := c.templateValues("testdata/web.json", values); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "Value tag of type string from --set tag=v1 is replaced by a value of type object from --set tag.name=v2") {
+			t.Errorf("strict %v: no warning in %q", strict, out.String())
+		}
+	}
+}
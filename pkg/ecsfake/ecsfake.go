@@ -0,0 +1,241 @@
+// Package ecsfake is an in-memory ECS, for testing code using the ECS API without an AWS account.
+//
+// The fake is a real *ecs.ECS client whose requests are answered from memory, so everything built on the
+// client works unchanged, including the SDK's waiters and request options such as the deployment checks
+// of the util package. It models clusters, services and their deployments, task definition families and
+// revisions, and tasks.
+//
+// Time advances in steps: every DescribeServices call moves each pending deployment of the described
+// services one step closer to finishing, and every DescribeTasks call does the same for tasks. How
+// deployments and tasks of a task definition end is configured with Behave. For example, to make the
+// second revision of the web family fail to deploy, and the migrate task exit with 1:
+//
+//	fake := ecsfake.New("example-cluster")
+//	fake.Behave("web:2", ecsfake.Behavior{Steps: 2, Unable: true})
+//	fake.Behave("migrate", ecsfake.Behavior{ExitCodes: map[string]int64{"migrate": 1}})
+//	client := czecs.New(fake, "us-west-2")
+//	client.WaiterOptions = fake.WaiterOptions()
+//
+// Waiters should not sleep between steps; WaiterOptions removes their delays.
+//
+// The fake is also an http.Handler serving the ECS API, to stand in for the ECS endpoint of another process.
+package ecsfake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Region is the region of the fake, used in ARNs.
+const Region = "us-west-2"
+
+// Account is the AWS account ID of the fake, used in ARNs.
+const Account = "123456789012"
+
+// Behavior configures how deployments and tasks of a task definition end.
+type Behavior struct {
+	// Steps is the number of steps a deployment or task takes to finish; 0 finishes on the first step
+	Steps int `json:"steps"`
+	// Unable makes deployments fail to place tasks: an "unable to place a task" event is added to the service,
+	// and if the deployment circuit breaker of the service is enabled, the deployment's rollout state becomes FAILED
+	// (and the service is rolled back to its previous deployment, if the circuit breaker rolls back)
+	Unable bool `json:"unable"`
+	// ExitCodes are the exit codes of the containers of tasks, by container name; other containers exit with 0
+	ExitCodes map[string]int64 `json:"exitCodes"`
+	// StartFailure makes RunTask fail to start tasks, reporting this reason as a failure
+	StartFailure string `json:"startFailure"`
+}
+
+// ECS is an in-memory ECS. It implements ecsiface.ECSAPI through its embedded client.
+type ECS struct {
+	*ecs.ECS
+
+	// Now returns the current time, used for timestamps; defaults to time.Now
+	Now func() time.Time
+
+	mu              sync.Mutex
+	calls           []string
+	clusters        map[string]*cluster
+	taskDefinitions map[string][]*ecs.TaskDefinition
+	behaviors       map[string]Behavior
+	tags            map[string][]*ecs.Tag
+	nextID          int
+}
+
+type cluster struct {
+	name     string
+	arn      string
+	services map[string]*service
+	tasks    map[string]*task
+}
+
+// New creates an in-memory ECS with the given clusters.
+func New(clusters ...string) *ECS {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(Region),
+		Credentials: credentials.NewStaticCredentials("fake", "fake", ""),
+	}))
+	f := &ECS{
+		ECS:             ecs.New(sess),
+		Now:             time.Now,
+		clusters:        map[string]*cluster{},
+		taskDefinitions: map[string][]*ecs.TaskDefinition{},
+		behaviors:       map[string]Behavior{},
+		tags:            map[string][]*ecs.Tag{},
+	}
+	// Answer every request from memory instead of sending it
+	f.ECS.Handlers.Clear()
+	f.ECS.Handlers.Send.PushBack(f.handle)
+	for _, name := range clusters {
+		f.AddCluster(name)
+	}
+	return f
+}
+
+// AddCluster adds an empty cluster.
+func (f *ECS) AddCluster(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clusters[name] = &cluster{
+		name:     name,
+		arn:      f.arn("cluster", name),
+		services: map[string]*service{},
+		tasks:    map[string]*task{},
+	}
+}
+
+// Behave sets how deployments and tasks of the given task definition family, or of a single revision given
+// as family:revision, end. By default they succeed on the first step.
+func (f *ECS) Behave(taskDefinition string, behavior Behavior) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.behaviors[taskDefinition] = behavior
+}
+
+// Calls returns the names of the operations called so far, in order, e.g. "UpdateService".
+func (f *ECS) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+// WaiterOptions returns waiter options removing the delays between attempts of waiters, for use as
+// czecs.Client.WaiterOptions.
+func (f *ECS) WaiterOptions() []request.WaiterOption {
+	return []request.WaiterOption{request.WithWaiterDelay(request.ConstantWaiterDelay(0))}
+}
+
+// handle answers a request from memory.
+func (f *ECS) handle(r *request.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Operation.Name)
+	// Like the HTTP client of a real ECS client, fail requests whose context is canceled
+	if err := r.Context().Err(); err != nil {
+		r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", err)
+		r.Retryable = aws.Bool(false)
+		return
+	}
+
+	var output interface{}
+	var err error
+	switch input := r.Params.(type) {
+	case *ecs.RegisterTaskDefinitionInput:
+		output, err = f.registerTaskDefinition(input)
+	case *ecs.DescribeTaskDefinitionInput:
+		output, err = f.describeTaskDefinition(input)
+	case *ecs.DeregisterTaskDefinitionInput:
+		output, err = f.deregisterTaskDefinition(input)
+	case *ecs.CreateServiceInput:
+		output, err = f.createService(input)
+	case *ecs.UpdateServiceInput:
+		output, err = f.updateService(input)
+	case *ecs.DeleteServiceInput:
+		output, err = f.deleteService(input)
+	case *ecs.DescribeClustersInput:
+		output, err = f.describeClusters(input)
+	case *ecs.DescribeServicesInput:
+		output, err = f.describeServices(input)
+	case *ecs.RunTaskInput:
+		output, err = f.runTask(input)
+	case *ecs.ListTasksInput:
+		output, err = f.listTasks(input)
+	case *ecs.DescribeTasksInput:
+		output, err = f.describeTasks(input)
+	case *ecs.StopTaskInput:
+		output, err = f.stopTask(input)
+	case *ecs.TagResourceInput:
+		output, err = f.tagResource(input)
+	case *ecs.UntagResourceInput:
+		output, err = f.untagResource(input)
+	default:
+		err = awserr.New("UnsupportedOperation", fmt.Sprintf("ecsfake does not support %s", r.Operation.Name), nil)
+	}
+	if err != nil {
+		r.Error = err
+		r.Retryable = aws.Bool(false)
+		return
+	}
+	// Copy the output, so that callers cannot change the state of the fake
+	awsutil.Copy(r.Data, output)
+}
+
+func (f *ECS) cluster(name *string) (*cluster, error) {
+	clusterName := aws.StringValue(name)
+	if clusterName == "" {
+		clusterName = "default"
+	}
+	for key, c := range f.clusters {
+		if key == clusterName || c.arn == clusterName {
+			return c, nil
+		}
+	}
+	return nil, awserr.New(ecs.ErrCodeClusterNotFoundException, fmt.Sprintf("Cluster not found: %s", clusterName), nil)
+}
+
+// describeClusters describes the given clusters, as ACTIVE clusters without counts.
+func (f *ECS) describeClusters(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error) {
+	names := input.Clusters
+	if len(names) == 0 {
+		names = []*string{aws.String("default")}
+	}
+	output := &ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{}, Failures: []*ecs.Failure{}}
+	for _, name := range names {
+		c, err := f.cluster(name)
+		if err != nil {
+			output.Failures = append(output.Failures, &ecs.Failure{
+				Arn:    aws.String(f.arn("cluster", aws.StringValue(name))),
+				Reason: aws.String("MISSING"),
+			})
+			continue
+		}
+		output.Clusters = append(output.Clusters, &ecs.Cluster{
+			ClusterArn:  aws.String(c.arn),
+			ClusterName: aws.String(c.name),
+			Status:      aws.String("ACTIVE"),
+		})
+	}
+	return output, nil
+}
+
+func (f *ECS) arn(resource string, name string) string {
+	return fmt.Sprintf("arn:aws:ecs:%s:%s:%s/%s", Region, Account, resource, name)
+}
+
+func (f *ECS) newID() string {
+	f.nextID++
+	return fmt.Sprintf("%08x", f.nextID)
+}
+
+func invalidParameter(format string, args ...interface{}) error {
+	return awserr.New(ecs.ErrCodeInvalidParameterException, fmt.Sprintf(format, args...), nil)
+}
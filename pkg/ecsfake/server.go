@@ -0,0 +1,71 @@
+package ecsfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+)
+
+// targetPrefix prefixes the X-Amz-Target header of ECS API requests, followed by the operation name.
+const targetPrefix = "AmazonEC2ContainerServiceV20141113."
+
+// ServeHTTP answers ECS API requests sent over HTTP, so that the fake can stand in for the ECS endpoint
+// of programs in other processes, such as the czecs command with --ecs-endpoint.
+func (f *ECS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	if r.Method != http.MethodPost || !strings.HasPrefix(target, targetPrefix) {
+		writeError(w, http.StatusBadRequest, "UnknownOperationException", "not an ECS API request")
+		return
+	}
+	operation := strings.TrimPrefix(target, targetPrefix)
+	// Every operation has a method of the client creating its request, e.g. UpdateServiceRequest
+	method := reflect.ValueOf(f.ECS).MethodByName(operation + "Request")
+	if !method.IsValid() {
+		writeError(w, http.StatusBadRequest, "UnknownOperationException", "unknown operation "+operation)
+		return
+	}
+	input := reflect.New(method.Type().In(0).Elem())
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "SerializationException", err.Error())
+		return
+	}
+	if len(body) > 0 {
+		if err := jsonutil.UnmarshalJSON(input.Interface(), bytes.NewReader(body)); err != nil {
+			writeError(w, http.StatusBadRequest, "SerializationException", err.Error())
+			return
+		}
+	}
+
+	results := method.Call([]reflect.Value{input})
+	req := results[0].Interface().(*request.Request)
+	if err := req.Send(); err != nil {
+		code, message := "InternalFailure", err.Error()
+		if aerr, ok := err.(awserr.Error); ok {
+			code, message = aerr.Code(), aerr.Message()
+		}
+		writeError(w, http.StatusBadRequest, code, message)
+		return
+	}
+	output, err := jsonutil.BuildJSON(results[1].Interface())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalFailure", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Write(output)
+}
+
+// writeError writes an error in the format of the ECS API.
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": message})
+}
@@ -0,0 +1,290 @@
+package ecsfake
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// service is a service of a cluster, with the number of steps each of its deployments has taken.
+type service struct {
+	*ecs.Service
+	steps map[string]int
+}
+
+func (f *ECS) createService(input *ecs.CreateServiceInput) (*ecs.CreateServiceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(input.ServiceName)
+	if existing, ok := c.services[name]; ok && aws.StringValue(existing.Status) != "INACTIVE" {
+		return nil, invalidParameter("Creation of service was not idempotent.")
+	}
+	taskDefinition, err := f.taskDefinition(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+
+	// Like ECS, replica services run one task unless told otherwise
+	desiredCount := aws.Int64Value(input.DesiredCount)
+	if input.DesiredCount == nil && aws.StringValue(input.SchedulingStrategy) != ecs.SchedulingStrategyDaemon {
+		desiredCount = 1
+	}
+	now := f.Now()
+	deploymentConfiguration := input.DeploymentConfiguration
+	if deploymentConfiguration == nil {
+		deploymentConfiguration = &ecs.DeploymentConfiguration{
+			MaximumPercent:        aws.Int64(200),
+			MinimumHealthyPercent: aws.Int64(100),
+		}
+	}
+	s := &service{
+		Service: &ecs.Service{
+			ServiceName:             &name,
+			ServiceArn:              aws.String(f.arn("service", fmt.Sprintf("%s/%s", c.name, name))),
+			ClusterArn:              &c.arn,
+			Status:                  aws.String("ACTIVE"),
+			TaskDefinition:          taskDefinition.TaskDefinitionArn,
+			DesiredCount:            &desiredCount,
+			RunningCount:            aws.Int64(0),
+			PendingCount:            aws.Int64(0),
+			LaunchType:              input.LaunchType,
+			LoadBalancers:           input.LoadBalancers,
+			NetworkConfiguration:    input.NetworkConfiguration,
+			DeploymentConfiguration: deploymentConfiguration,
+			CreatedAt:               &now,
+		},
+		steps: map[string]int{},
+	}
+	s.Deployments = []*ecs.Deployment{f.newDeployment(s)}
+	c.services[name] = s
+	f.tags[aws.StringValue(s.ServiceArn)] = nil
+	if len(input.Tags) > 0 {
+		f.tag(aws.StringValue(s.ServiceArn), input.Tags)
+	}
+	return &ecs.CreateServiceOutput{Service: s.Service}, nil
+}
+
+func (f *ECS) updateService(input *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	s, err := f.activeService(input.Cluster, input.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.DesiredCount != nil {
+		s.DesiredCount = input.DesiredCount
+		s.primary().DesiredCount = input.DesiredCount
+	}
+	if input.DeploymentConfiguration != nil {
+		s.DeploymentConfiguration = input.DeploymentConfiguration
+	}
+	if input.NetworkConfiguration != nil {
+		s.NetworkConfiguration = input.NetworkConfiguration
+	}
+	newTaskDefinition := false
+	if input.TaskDefinition != nil {
+		taskDefinition, err := f.taskDefinition(aws.StringValue(input.TaskDefinition))
+		if err != nil {
+			return nil, err
+		}
+		if aws.StringValue(taskDefinition.Status) != ecs.TaskDefinitionStatusActive {
+			return nil, invalidParameter("TaskDefinition is inactive")
+		}
+		newTaskDefinition = aws.StringValue(taskDefinition.TaskDefinitionArn) != aws.StringValue(s.TaskDefinition)
+		s.TaskDefinition = taskDefinition.TaskDefinitionArn
+	}
+	if newTaskDefinition || aws.BoolValue(input.ForceNewDeployment) {
+		// The previous primary deployment keeps running until the new one completes
+		for _, deployment := range s.Deployments {
+			deployment.Status = aws.String("ACTIVE")
+		}
+		s.Deployments = append([]*ecs.Deployment{f.newDeployment(s)}, s.Deployments...)
+	}
+	return &ecs.UpdateServiceOutput{Service: s.Service}, nil
+}
+
+func (f *ECS) deleteService(input *ecs.DeleteServiceInput) (*ecs.DeleteServiceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	s, err := f.activeService(input.Cluster, input.Service)
+	if err != nil {
+		return nil, err
+	}
+	if aws.Int64Value(s.DesiredCount) > 0 && !aws.BoolValue(input.Force) {
+		return nil, invalidParameter("The service cannot be stopped while it is scaled above 0.")
+	}
+	s.Status = aws.String("DRAINING")
+	s.DesiredCount = aws.Int64(0)
+	return &ecs.DeleteServiceOutput{Service: s.Service}, nil
+}
+
+// describeServices takes a step for the deployments of each described service, then describes them.
+func (f *ECS) describeServices(input *ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	includeTags := false
+	for _, field := range input.Include {
+		includeTags = includeTags || aws.StringValue(field) == ecs.ServiceFieldTags
+	}
+
+	output := &ecs.DescribeServicesOutput{Services: []*ecs.Service{}, Failures: []*ecs.Failure{}}
+	for _, name := range input.Services {
+		s := findService(c, aws.StringValue(name))
+		if s == nil {
+			output.Failures = append(output.Failures, &ecs.Failure{
+				Arn:    aws.String(f.arn("service", fmt.Sprintf("%s/%s", c.name, aws.StringValue(name)))),
+				Reason: aws.String("MISSING"),
+			})
+			continue
+		}
+		f.step(s)
+		described := *s.Service
+		if includeTags {
+			described.Tags = f.tags[aws.StringValue(s.ServiceArn)]
+		}
+		output.Services = append(output.Services, &described)
+	}
+	return output, nil
+}
+
+// step advances the service by one step: a draining service becomes inactive, and the primary deployment
+// progresses as configured by the behavior of its task definition family.
+func (f *ECS) step(s *service) {
+	if aws.StringValue(s.Status) == "DRAINING" {
+		s.Status = aws.String("INACTIVE")
+		s.RunningCount = aws.Int64(0)
+		s.Deployments = []*ecs.Deployment{}
+		return
+	}
+	deployment := s.primary()
+	if deployment != nil && aws.StringValue(deployment.RolloutState) == ecs.DeploymentRolloutStateCompleted &&
+		aws.Int64Value(deployment.RunningCount) != aws.Int64Value(deployment.DesiredCount) {
+		// A scaled service starts or stops tasks of its completed deployment in a single step
+		deployment.RunningCount = deployment.DesiredCount
+		s.RunningCount = s.DesiredCount
+		f.addEvent(s, fmt.Sprintf("(service %s) has reached a steady state.", aws.StringValue(s.ServiceName)))
+		return
+	}
+	if deployment == nil || aws.StringValue(deployment.RolloutState) != ecs.DeploymentRolloutStateInProgress {
+		return
+	}
+	id := aws.StringValue(deployment.Id)
+	s.steps[id]++
+	taskDefinition, err := f.taskDefinition(aws.StringValue(deployment.TaskDefinition))
+	if err != nil {
+		return
+	}
+	behavior := f.behavior(taskDefinition)
+	if s.steps[id] <= behavior.Steps {
+		deployment.PendingCount = deployment.DesiredCount
+		return
+	}
+
+	now := f.Now()
+	deployment.UpdatedAt = &now
+	deployment.PendingCount = aws.Int64(0)
+	if behavior.Unable {
+		deployment.FailedTasks = aws.Int64(aws.Int64Value(deployment.FailedTasks) + 1)
+		f.addEvent(s, fmt.Sprintf("(service %s) was unable to place a task because no container instance met all of its requirements.", aws.StringValue(s.ServiceName)))
+		if s.DeploymentConfiguration == nil || s.DeploymentConfiguration.DeploymentCircuitBreaker == nil {
+			return
+		}
+		circuitBreaker := s.DeploymentConfiguration.DeploymentCircuitBreaker
+		if !aws.BoolValue(circuitBreaker.Enable) {
+			return
+		}
+		deployment.RolloutState = aws.String(ecs.DeploymentRolloutStateFailed)
+		deployment.RolloutStateReason = aws.String("ECS deployment circuit breaker: tasks failed to start.")
+		if aws.BoolValue(circuitBreaker.Rollback) && len(s.Deployments) > 1 {
+			// Roll back to the previous deployment, like ECS does
+			s.TaskDefinition = s.Deployments[1].TaskDefinition
+			deployment.Status = aws.String("ACTIVE")
+			s.Deployments = append([]*ecs.Deployment{f.newDeployment(s)}, s.Deployments...)
+		}
+		return
+	}
+
+	deployment.RolloutState = aws.String(ecs.DeploymentRolloutStateCompleted)
+	deployment.RolloutStateReason = aws.String(fmt.Sprintf("ECS deployment %s completed.", id))
+	deployment.RunningCount = deployment.DesiredCount
+	s.RunningCount = s.DesiredCount
+	s.Deployments = []*ecs.Deployment{deployment}
+	f.addEvent(s, fmt.Sprintf("(service %s) has reached a steady state.", aws.StringValue(s.ServiceName)))
+}
+
+func (f *ECS) newDeployment(s *service) *ecs.Deployment {
+	now := f.Now()
+	id := "ecs-svc/" + f.newID()
+	return &ecs.Deployment{
+		Id:                   &id,
+		Status:               aws.String("PRIMARY"),
+		TaskDefinition:       s.TaskDefinition,
+		DesiredCount:         s.DesiredCount,
+		PendingCount:         aws.Int64(0),
+		RunningCount:         aws.Int64(0),
+		FailedTasks:          aws.Int64(0),
+		LaunchType:           s.LaunchType,
+		NetworkConfiguration: s.NetworkConfiguration,
+		RolloutState:         aws.String(ecs.DeploymentRolloutStateInProgress),
+		RolloutStateReason:   aws.String("ECS deployment " + id + " in progress."),
+		CreatedAt:            &now,
+		UpdatedAt:            &now,
+	}
+}
+
+// addEvent adds an event to the service; the most recent event comes first, like in ECS.
+func (f *ECS) addEvent(s *service, message string) {
+	now := f.Now()
+	event := &ecs.ServiceEvent{Id: aws.String(f.newID()), CreatedAt: &now, Message: &message}
+	s.Events = append([]*ecs.ServiceEvent{event}, s.Events...)
+}
+
+func (s *service) primary() *ecs.Deployment {
+	for _, deployment := range s.Deployments {
+		if aws.StringValue(deployment.Status) == "PRIMARY" {
+			return deployment
+		}
+	}
+	return nil
+}
+
+// activeService finds a service that can be updated or deleted.
+func (f *ECS) activeService(clusterName *string, name *string) (*service, error) {
+	c, err := f.cluster(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	s := findService(c, aws.StringValue(name))
+	if s == nil {
+		return nil, awserr.New(ecs.ErrCodeServiceNotFoundException, "Service not found.", nil)
+	}
+	if aws.StringValue(s.Status) != "ACTIVE" {
+		return nil, awserr.New(ecs.ErrCodeServiceNotActiveException, "Service was not ACTIVE.", nil)
+	}
+	return s, nil
+}
+
+// findService finds a service by name or ARN.
+func findService(c *cluster, name string) *service {
+	for _, s := range c.services {
+		if aws.StringValue(s.ServiceName) == name || aws.StringValue(s.ServiceArn) == name {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package ecsfake
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func (f *ECS) tagResource(input *ecs.TagResourceInput) (*ecs.TagResourceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	if _, ok := f.tags[aws.StringValue(input.ResourceArn)]; !ok {
+		return nil, invalidParameter("The specified resource could not be found.")
+	}
+	f.tag(aws.StringValue(input.ResourceArn), input.Tags)
+	return &ecs.TagResourceOutput{}, nil
+}
+
+func (f *ECS) untagResource(input *ecs.UntagResourceInput) (*ecs.UntagResourceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	resourceArn := aws.StringValue(input.ResourceArn)
+	tags, ok := f.tags[resourceArn]
+	if !ok {
+		return nil, invalidParameter("The specified resource could not be found.")
+	}
+	kept := []*ecs.Tag{}
+	for _, tag := range tags {
+		removed := false
+		for _, key := range input.TagKeys {
+			removed = removed || aws.StringValue(key) == aws.StringValue(tag.Key)
+		}
+		if !removed {
+			kept = append(kept, tag)
+		}
+	}
+	f.tags[resourceArn] = kept
+	return &ecs.UntagResourceOutput{}, nil
+}
+
+// tag adds tags to a resource, replacing those with the same keys.
+func (f *ECS) tag(resourceArn string, tags []*ecs.Tag) {
+	for _, tag := range tags {
+		replaced := false
+		for _, existing := range f.tags[resourceArn] {
+			if aws.StringValue(existing.Key) == aws.StringValue(tag.Key) {
+				existing.Value = tag.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			f.tags[resourceArn] = append(f.tags[resourceArn], &ecs.Tag{Key: tag.Key, Value: tag.Value})
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ecsfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func (f *ECS) registerTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	// The registered task definition has the same fields as the input, plus those set by ECS
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	taskDefinition := &ecs.TaskDefinition{}
+	if err := json.Unmarshal(raw, taskDefinition); err != nil {
+		return nil, err
+	}
+	registrationDefaults(taskDefinition)
+	family := aws.StringValue(input.Family)
+	revision := int64(len(f.taskDefinitions[family]) + 1)
+	taskDefinition.Revision = &revision
+	taskDefinition.TaskDefinitionArn = aws.String(f.arn("task-definition", fmt.Sprintf("%s:%d", family, revision)))
+	taskDefinition.Status = aws.String(ecs.TaskDefinitionStatusActive)
+	registeredAt := f.Now()
+	taskDefinition.RegisteredAt = &registeredAt
+	f.taskDefinitions[family] = append(f.taskDefinitions[family], taskDefinition)
+	if len(input.Tags) > 0 {
+		f.tag(aws.StringValue(taskDefinition.TaskDefinitionArn), input.Tags)
+	}
+	return &ecs.RegisterTaskDefinitionOutput{TaskDefinition: taskDefinition, Tags: input.Tags}, nil
+}
+
+// registrationDefaults fills in the fields ECS sets when registering a task definition that leaves them out.
+func registrationDefaults(taskDefinition *ecs.TaskDefinition) {
+	if taskDefinition.NetworkMode == nil {
+		taskDefinition.NetworkMode = aws.String(ecs.NetworkModeBridge)
+	}
+	for _, container := range taskDefinition.ContainerDefinitions {
+		if container.Cpu == nil {
+			container.Cpu = aws.Int64(0)
+		}
+		if container.Essential == nil {
+			container.Essential = aws.Bool(true)
+		}
+		if container.MountPoints == nil {
+			container.MountPoints = []*ecs.MountPoint{}
+		}
+		if container.VolumesFrom == nil {
+			container.VolumesFrom = []*ecs.VolumeFrom{}
+		}
+		for _, portMapping := range container.PortMappings {
+			if portMapping.Protocol == nil {
+				portMapping.Protocol = aws.String(ecs.TransportProtocolTcp)
+			}
+			if portMapping.HostPort == nil {
+				if aws.StringValue(taskDefinition.NetworkMode) == ecs.NetworkModeBridge {
+					portMapping.HostPort = aws.Int64(0)
+				} else {
+					portMapping.HostPort = portMapping.ContainerPort
+				}
+			}
+		}
+	}
+}
+
+func (f *ECS) describeTaskDefinition(input *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	taskDefinition, err := f.taskDefinition(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: taskDefinition}, nil
+}
+
+func (f *ECS) deregisterTaskDefinition(input *ecs.DeregisterTaskDefinitionInput) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	name := aws.StringValue(input.TaskDefinition)
+	if !strings.Contains(name, ":") {
+		return nil, invalidParameter("TaskDefinition must include a revision: %s", name)
+	}
+	taskDefinition, err := f.taskDefinition(name)
+	if err != nil {
+		return nil, err
+	}
+	taskDefinition.Status = aws.String(ecs.TaskDefinitionStatusInactive)
+	return &ecs.DeregisterTaskDefinitionOutput{TaskDefinition: taskDefinition}, nil
+}
+
+// taskDefinition finds a task definition by family (its latest active revision), family:revision or ARN.
+func (f *ECS) taskDefinition(name string) (*ecs.TaskDefinition, error) {
+	familyRevision := name
+	if slash := strings.LastIndex(name, "/"); strings.HasPrefix(name, "arn:") && slash >= 0 {
+		familyRevision = name[slash+1:]
+	}
+	family := familyRevision
+	revision := int64(0)
+	if colon := strings.LastIndex(familyRevision, ":"); colon >= 0 {
+		family = familyRevision[:colon]
+		var err error
+		if revision, err = strconv.ParseInt(familyRevision[colon+1:], 10, 64); err != nil {
+			return nil, invalidParameter("Invalid revision number. Number: %s", familyRevision[colon+1:])
+		}
+	}
+
+	revisions := f.taskDefinitions[family]
+	if revision == 0 {
+		for i := len(revisions) - 1; i >= 0; i-- {
+			if aws.StringValue(revisions[i].Status) == ecs.TaskDefinitionStatusActive {
+				return revisions[i], nil
+			}
+		}
+	} else if revision <= int64(len(revisions)) {
+		return revisions[revision-1], nil
+	}
+	return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+}
+
+// behavior returns the behavior of the given task definition: that of its revision if set, otherwise that of its family.
+func (f *ECS) behavior(taskDefinition *ecs.TaskDefinition) Behavior {
+	family := aws.StringValue(taskDefinition.Family)
+	if behavior, ok := f.behaviors[fmt.Sprintf("%s:%d", family, aws.Int64Value(taskDefinition.Revision))]; ok {
+		return behavior
+	}
+	return f.behaviors[family]
+}
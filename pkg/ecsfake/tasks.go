@@ -0,0 +1,200 @@
+package ecsfake
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// task is a task of a cluster, with the behavior of its task definition when it was started.
+type task struct {
+	*ecs.Task
+	behavior Behavior
+	steps    int
+}
+
+func (f *ECS) runTask(input *ecs.RunTaskInput) (*ecs.RunTaskOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	taskDefinition, err := f.taskDefinition(aws.StringValue(input.TaskDefinition))
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(taskDefinition.Status) != ecs.TaskDefinitionStatusActive {
+		return nil, invalidParameter("TaskDefinition is inactive")
+	}
+	behavior := f.behavior(taskDefinition)
+
+	output := &ecs.RunTaskOutput{Tasks: []*ecs.Task{}, Failures: []*ecs.Failure{}}
+	count := aws.Int64Value(input.Count)
+	if count == 0 {
+		count = 1
+	}
+	for i := int64(0); i < count; i++ {
+		if behavior.StartFailure != "" {
+			output.Failures = append(output.Failures, &ecs.Failure{Arn: &c.arn, Reason: aws.String(behavior.StartFailure)})
+			continue
+		}
+		now := f.Now()
+		taskArn := f.arn("task", fmt.Sprintf("%s/%s", c.name, f.newID()))
+		t := &task{
+			Task: &ecs.Task{
+				TaskArn:           &taskArn,
+				ClusterArn:        &c.arn,
+				TaskDefinitionArn: taskDefinition.TaskDefinitionArn,
+				LastStatus:        aws.String("PROVISIONING"),
+				DesiredStatus:     aws.String("RUNNING"),
+				LaunchType:        input.LaunchType,
+				Group:             input.Group,
+				StartedBy:         input.StartedBy,
+				Overrides:         input.Overrides,
+				CreatedAt:         &now,
+			},
+			behavior: behavior,
+		}
+		for _, containerDefinition := range taskDefinition.ContainerDefinitions {
+			t.Containers = append(t.Containers, &ecs.Container{
+				Name:       containerDefinition.Name,
+				Image:      containerDefinition.Image,
+				TaskArn:    &taskArn,
+				LastStatus: aws.String("PENDING"),
+			})
+		}
+		c.tasks[taskArn] = t
+		output.Tasks = append(output.Tasks, t.Task)
+	}
+	return output, nil
+}
+
+// describeTasks takes a step for each described task, then describes them.
+func (f *ECS) describeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	output := &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{}, Failures: []*ecs.Failure{}}
+	for _, name := range input.Tasks {
+		t := f.findTask(c, aws.StringValue(name))
+		if t == nil {
+			output.Failures = append(output.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+			continue
+		}
+		f.stepTask(t)
+		output.Tasks = append(output.Tasks, t.Task)
+	}
+	return output, nil
+}
+
+func (f *ECS) stopTask(input *ecs.StopTaskInput) (*ecs.StopTaskOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, invalidParameter("%s", err.Error())
+	}
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	t := f.findTask(c, aws.StringValue(input.Task))
+	if t == nil {
+		return nil, invalidParameter("The referenced task was not found.")
+	}
+	if aws.StringValue(t.LastStatus) != "STOPPED" {
+		f.stop(t, ecs.TaskStopCodeUserInitiated, aws.StringValue(input.Reason), nil)
+	}
+	return &ecs.StopTaskOutput{Task: t.Task}, nil
+}
+
+// stepTask advances the task by one step: it runs until it has taken the steps of its behavior, then stops.
+func (f *ECS) stepTask(t *task) {
+	if aws.StringValue(t.LastStatus) == "STOPPED" {
+		return
+	}
+	t.steps++
+	if t.steps <= t.behavior.Steps {
+		if t.StartedAt == nil {
+			now := f.Now()
+			t.StartedAt = &now
+		}
+		t.LastStatus = aws.String("RUNNING")
+		for _, container := range t.Containers {
+			container.LastStatus = aws.String("RUNNING")
+		}
+		return
+	}
+	f.stop(t, ecs.TaskStopCodeEssentialContainerExited, "Essential container in task exited", t.behavior.ExitCodes)
+}
+
+// stop stops the task; its containers exit with the given exit codes, or 0 if not given.
+func (f *ECS) stop(t *task, stopCode string, reason string, exitCodes map[string]int64) {
+	now := f.Now()
+	t.LastStatus = aws.String("STOPPED")
+	t.DesiredStatus = aws.String("STOPPED")
+	t.StopCode = &stopCode
+	t.StoppedReason = &reason
+	t.StoppedAt = &now
+	for _, container := range t.Containers {
+		container.LastStatus = aws.String("STOPPED")
+		container.ExitCode = aws.Int64(exitCodes[aws.StringValue(container.Name)])
+	}
+}
+
+// findTask finds a task by ARN or ID.
+func (f *ECS) findTask(c *cluster, name string) *task {
+	if t, ok := c.tasks[name]; ok {
+		return t
+	}
+	return c.tasks[f.arn("task", fmt.Sprintf("%s/%s", c.name, name))]
+}
+
+// listTasks lists the tasks of a service, or else the tasks of the cluster started by RunTask. Tasks of services
+// are not modeled beyond their ARNs: each deployment runs as many tasks as its running count, named after it,
+// so a new deployment replaces every task.
+func (f *ECS) listTasks(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+	c, err := f.cluster(input.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	desiredStatus := aws.StringValue(input.DesiredStatus)
+	if desiredStatus == "" {
+		desiredStatus = ecs.DesiredStatusRunning
+	}
+
+	taskArns := []string{}
+	if input.ServiceName != nil {
+		s := findService(c, aws.StringValue(input.ServiceName))
+		if s == nil {
+			return nil, awserr.New(ecs.ErrCodeServiceNotFoundException, "Service not found.", nil)
+		}
+		if desiredStatus == ecs.DesiredStatusRunning {
+			for _, deployment := range s.Deployments {
+				id := strings.TrimPrefix(aws.StringValue(deployment.Id), "ecs-svc/")
+				for i := int64(0); i < aws.Int64Value(deployment.RunningCount); i++ {
+					taskArns = append(taskArns, f.arn("task", fmt.Sprintf("%s/%s%08x", c.name, id, i)))
+				}
+			}
+		}
+	} else {
+		for taskArn, t := range c.tasks {
+			if aws.StringValue(t.DesiredStatus) != desiredStatus {
+				continue
+			}
+			if input.StartedBy != nil && aws.StringValue(t.StartedBy) != aws.StringValue(input.StartedBy) {
+				continue
+			}
+			taskArns = append(taskArns, taskArn)
+		}
+	}
+	sort.Strings(taskArns)
+	return &ecs.ListTasksOutput{TaskArns: aws.StringSlice(taskArns)}, nil
+}
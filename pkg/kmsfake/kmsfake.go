@@ -0,0 +1,223 @@
+// Package kmsfake is an in-memory AWS KMS with local keys, for testing the encryption of balances files
+// without an AWS account.
+//
+// Like ecsfake, the fake is a real *kms.KMS client whose requests are answered from memory. It supports
+// Encrypt, Decrypt and GenerateDataKey with symmetric keys. The material of each key is derived from its
+// name, so that ciphertexts stay valid across processes: the fake is not secure, and must only be used
+// with test data. For example:
+//
+//	fake := kmsfake.New("alias/test")
+//	tasks.SetKMS(fake)
+//	encrypted, err := tasks.EncryptBalances(balances, tasks.EncryptOptions{KMSKeys: []string{"alias/test"}})
+//
+// The fake is also an http.Handler serving the KMS API, to stand in for the KMS endpoint of another process.
+package kmsfake
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Region is the region of the fake, used in ARNs.
+const Region = "us-west-2"
+
+// Account is the AWS account ID of the fake, used in ARNs.
+const Account = "123456789012"
+
+// KMS is an in-memory KMS. It implements kmsiface.KMSAPI through its embedded client.
+type KMS struct {
+	*kms.KMS
+
+	mu sync.Mutex
+	// keys are the ARNs of the keys, by ARN, key ID and alias
+	keys map[string]string
+}
+
+// New creates an in-memory KMS with the given keys; see AddKey.
+func New(keys ...string) *KMS {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(Region),
+		Credentials: credentials.NewStaticCredentials("fake", "fake", ""),
+	}))
+	f := &KMS{
+		KMS:  kms.New(sess),
+		keys: map[string]string{},
+	}
+	// Answer every request from memory instead of sending it
+	f.KMS.Handlers.Clear()
+	f.KMS.Handlers.Send.PushBack(f.handle)
+	for _, key := range keys {
+		f.AddKey(key)
+	}
+	return f
+}
+
+// AddKey adds a key, given by ARN, key ID or alias (alias/name). It can then be referred to by ARN as well.
+func (f *KMS) AddKey(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arn := key
+	switch {
+	case strings.HasPrefix(key, "alias/"):
+		arn = fmt.Sprintf("arn:aws:kms:%s:%s:%s", Region, Account, key)
+	case !strings.HasPrefix(key, "arn:"):
+		arn = fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", Region, Account, key)
+	}
+	f.keys[key] = arn
+	f.keys[arn] = arn
+}
+
+// handle answers a request from memory.
+func (f *KMS) handle(r *request.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var output interface{}
+	var err error
+	switch input := r.Params.(type) {
+	case *kms.EncryptInput:
+		output, err = f.encrypt(input)
+	case *kms.DecryptInput:
+		output, err = f.decrypt(input)
+	case *kms.GenerateDataKeyInput:
+		output, err = f.generateDataKey(input)
+	default:
+		err = awserr.New("UnsupportedOperation", fmt.Sprintf("kmsfake does not support %s", r.Operation.Name), nil)
+	}
+	if err != nil {
+		r.Error = err
+		r.Retryable = aws.Bool(false)
+		return
+	}
+	awsutil.Copy(r.Data, output)
+}
+
+func (f *KMS) encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	arn, err := f.key(input.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := seal(arn, input.Plaintext, input.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.EncryptOutput{KeyId: &arn, CiphertextBlob: blob}, nil
+}
+
+func (f *KMS) decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	// The ciphertext blob starts with the ARN of its key, like those of AWS KMS refer to their key
+	separator := bytes.IndexByte(input.CiphertextBlob, 0)
+	if separator < 0 {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "invalid ciphertext", nil)
+	}
+	arn, err := f.key(aws.String(string(input.CiphertextBlob[:separator])))
+	if err != nil {
+		return nil, err
+	}
+	if input.KeyId != nil {
+		requested, err := f.key(input.KeyId)
+		if err != nil {
+			return nil, err
+		}
+		if requested != arn {
+			return nil, awserr.New(kms.ErrCodeIncorrectKeyException, "the ciphertext was encrypted with another key", nil)
+		}
+	}
+	plaintext, err := open(arn, input.CiphertextBlob[separator+1:], input.EncryptionContext)
+	if err != nil {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "cannot decrypt the ciphertext", err)
+	}
+	return &kms.DecryptOutput{KeyId: &arn, Plaintext: plaintext}, nil
+}
+
+func (f *KMS) generateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	size := aws.Int64Value(input.NumberOfBytes)
+	switch aws.StringValue(input.KeySpec) {
+	case kms.DataKeySpecAes256:
+		size = 32
+	case kms.DataKeySpecAes128:
+		size = 16
+	}
+	if size == 0 {
+		return nil, awserr.New("ValidationException", "KeySpec or NumberOfBytes is required", nil)
+	}
+	plaintext := make([]byte, size)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+	encrypted, err := f.encrypt(&kms.EncryptInput{KeyId: input.KeyId, Plaintext: plaintext, EncryptionContext: input.EncryptionContext})
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{KeyId: encrypted.KeyId, Plaintext: plaintext, CiphertextBlob: encrypted.CiphertextBlob}, nil
+}
+
+// key returns the ARN of the given key.
+func (f *KMS) key(keyID *string) (string, error) {
+	arn, ok := f.keys[aws.StringValue(keyID)]
+	if !ok {
+		return "", awserr.New(kms.ErrCodeNotFoundException, fmt.Sprintf("Key '%s' does not exist", aws.StringValue(keyID)), nil)
+	}
+	return arn, nil
+}
+
+// seal encrypts the plaintext with the key, authenticating the encryption context.
+func seal(arn string, plaintext []byte, context map[string]*string) ([]byte, error) {
+	gcm, err := keyGCM(arn)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	blob := append([]byte(arn), 0)
+	blob = append(blob, nonce...)
+	return gcm.Seal(blob, nonce, plaintext, contextBytes(context)), nil
+}
+
+func open(arn string, sealed []byte, context map[string]*string) ([]byte, error) {
+	gcm, err := keyGCM(arn)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], contextBytes(context))
+}
+
+// keyGCM returns AES-GCM with the material of the given key, derived from its ARN.
+func keyGCM(arn string) (cipher.AEAD, error) {
+	material := sha256.Sum256([]byte("kmsfake:" + arn))
+	block, err := aes.NewCipher(material[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// contextBytes returns the encryption context in a canonical form, sorted by key.
+func contextBytes(context map[string]*string) []byte {
+	var pairs []string
+	for key, value := range context {
+		pairs = append(pairs, fmt.Sprintf("%q=%q", key, aws.StringValue(value)))
+	}
+	sort.Strings(pairs)
+	return []byte(strings.Join(pairs, ","))
+}
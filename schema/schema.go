@@ -0,0 +1,268 @@
+// Package schema validates values against a JSON Schema. Only the subset of JSON Schema useful for
+// describing template values is supported: type, required, properties, additionalProperties, items,
+// enum, pattern, minLength, maxLength, minimum and maximum. Other keywords are ignored.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Type                 types              `json:"type"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// types is the type keyword, either a single type or a list of types.
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = types{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// additional is the additionalProperties keyword, either a boolean or a schema.
+type additional struct {
+	allowed bool
+	schema  *Schema
+}
+
+func (a *additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// Parse parses a JSON Schema.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, errors.Wrap(err, "Error parsing JSON of schema")
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile compiles the patterns of the schema and all its subschemas.
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %#v in schema", s.Pattern)
+		}
+		s.pattern = pattern
+	}
+	subschemas := []*Schema{s.Items}
+	for _, property := range s.Properties {
+		subschemas = append(subschemas, property)
+	}
+	if s.AdditionalProperties != nil {
+		subschemas = append(subschemas, s.AdditionalProperties.schema)
+	}
+	for _, subschema := range subschemas {
+		if subschema == nil {
+			continue
+		}
+		if err := subschema.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidationError lists every place where the values do not match the schema.
+type ValidationError struct {
+	// Problems are of the form "<path>: <problem>", e.g. ".Values.tag: required"
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validate validates value against the schema. Paths in problems start with root, e.g. ".Values".
+// Returns a *ValidationError if the value does not match.
+func (s *Schema) Validate(value interface{}, root string) error {
+	var problems []string
+	s.validate(value, root, &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+func (s *Schema) validate(value interface{}, path string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		*problems = append(*problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	actual := typeOf(value)
+	if len(s.Type) > 0 && !s.Type.allow(actual) {
+		report("expected %s, got %s", strings.Join(s.Type, " or "), actual)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			formatted, _ := json.Marshal(v)
+			allowed[i] = string(formatted)
+		}
+		report("must be one of %s", strings.Join(allowed, ", "))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, problems)
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case string:
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			report("%#v does not match pattern %#v", v, s.Pattern)
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(v) < *s.MinLength {
+			report("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(v) > *s.MaxLength {
+			report("must be at most %d characters long", *s.MaxLength)
+		}
+	default:
+		if number, ok := toFloat(value); ok {
+			if s.Minimum != nil && number < *s.Minimum {
+				report("must be at least %v", *s.Minimum)
+			}
+			if s.Maximum != nil && number > *s.Maximum {
+				report("must be at most %v", *s.Maximum)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, path string, problems *[]string) {
+	for _, key := range s.Required {
+		if _, ok := object[key]; !ok {
+			*problems = append(*problems, fmt.Sprintf("%s.%s: required", path, key))
+		}
+	}
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if property, ok := s.Properties[key]; ok {
+			property.validate(object[key], path+"."+key, problems)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.allowed {
+			*problems = append(*problems, fmt.Sprintf("%s.%s: not allowed by schema", path, key))
+		} else if s.AdditionalProperties.schema != nil {
+			s.AdditionalProperties.schema.validate(object[key], path+"."+key, problems)
+		}
+	}
+}
+
+// allow returns whether a value of the given type matches one of the types.
+func (t types) allow(actual string) bool {
+	for _, allowed := range t {
+		if allowed == actual || (allowed == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a value parsed from JSON or --set.
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if number, ok := toFloat(value); ok {
+		if number == math.Trunc(number) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// inEnum returns whether value is one of the enum values, comparing numbers by value.
+func inEnum(value interface{}, enum []interface{}) bool {
+	number, isNumber := toFloat(value)
+	for _, allowed := range enum {
+		if allowedNumber, ok := toFloat(allowed); ok && isNumber {
+			if number == allowedNumber {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
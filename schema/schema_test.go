@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["name", "tag"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {"type": "string", "minLength": 3, "maxLength": 5},
+    "tag": {"type": "string", "pattern": "^v[0-9]+$"},
+    "env": {"enum": ["staging", "prod"]},
+    "replicas": {"type": "integer", "minimum": 1, "maximum": 10},
+    "cpu": {"type": ["number", "string"]},
+    "ports": {"type": "array", "items": {"type": "integer"}},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+    "database": {
+      "type": "object",
+      "required": ["host"],
+      "properties": {"host": {"type": "string"}, "port": {"type": "integer"}}
+    }
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		values string
+		// wantProblems are the problems reported, or none if the values are valid
+		wantProblems []string
+	}{
+		{
+			name:   "valid",
+			values: `{"name": "web", "tag": "v1", "env": "prod", "replicas": 2, "cpu": 0.5, "ports": [80], "labels": {"team": "a"}, "database": {"host": "db"}}`,
+		},
+		{
+			name:         "required",
+			values:       `{"name": "web"}`,
+			wantProblems: []string{".Values.tag: required"},
+		},
+		{
+			name:         "nested required",
+			values:       `{"name": "web", "tag": "v1", "database": {"port": 5432}}`,
+			wantProblems: []string{".Values.database.host: required"},
+		},
+		{
+			name:         "type",
+			values:       `{"name": 3, "tag": "v1", "replicas": 1.5, "cpu": true}`,
+			wantProblems: []string{".Values.cpu: expected number or string, got boolean", ".Values.name: expected string, got integer", ".Values.replicas: expected integer, got number"},
+		},
+		{
+			name:         "not an object",
+			values:       `"web"`,
+			wantProblems: []string{".Values: expected object, got string"},
+		},
+		{
+			name:         "enum",
+			values:       `{"name": "web", "tag": "v1", "env": "dev"}`,
+			wantProblems: []string{`.Values.env: must be one of "staging", "prod"`},
+		},
+		{
+			name:         "pattern",
+			values:       `{"name": "web", "tag": "latest"}`,
+			wantProblems: []string{`.Values.tag: "latest" does not match pattern "^v[0-9]+$"`},
+		},
+		{
+			name:         "additionalProperties false",
+			values:       `{"name": "web", "tag": "v1", "extra": 1}`,
+			wantProblems: []string{".Values.extra: not allowed by schema"},
+		},
+		{
+			name:         "additionalProperties schema",
+			values:       `{"name": "web", "tag": "v1", "labels": {"team": 1}}`,
+			wantProblems: []string{".Values.labels.team: expected string, got integer"},
+		},
+		{
+			name:         "items",
+			values:       `{"name": "web", "tag": "v1", "ports": [80, "443"]}`,
+			wantProblems: []string{".Values.ports[1]: expected integer, got string"},
+		},
+		{
+			name:         "minimum and maximum",
+			values:       `{"name": "web", "tag": "v1", "replicas": 11}`,
+			wantProblems: []string{".Values.replicas: must be at most 10"},
+		},
+		{
+			name:         "minLength",
+			values:       `{"name": "ab", "tag": "v1"}`,
+			wantProblems: []string{".Values.name: must be at least 3 characters long"},
+		},
+		{
+			name:         "maxLength",
+			values:       `{"name": "abcdef", "tag": "v1"}`,
+			wantProblems: []string{".Values.name: must be at most 5 characters long"},
+		},
+		{
+			// Five characters, but ten bytes
+			name:   "multi-byte maxLength",
+			values: `{"name": "ウェブアプ", "tag": "v1"}`,
+		},
+		{
+			// Two characters, but six bytes
+			name:         "multi-byte minLength",
+			values:       `{"name": "ウェ", "tag": "v1"}`,
+			wantProblems: []string{".Values.name: must be at least 3 characters long"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var values interface{}
+			if err := json.Unmarshal([]byte(test.values), &values); err != nil {
+				t.Fatal(err)
+			}
+			err := s.Validate(values, ".Values")
+			var problems []string
+			if err != nil {
+				validationError, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("expected a *ValidationError, got %T", err)
+				}
+				problems = validationError.Problems
+			}
+			if !reflect.DeepEqual(problems, test.wantProblems) {
+				t.Errorf("problems = %#v, want %#v", problems, test.wantProblems)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid JSON":    `{`,
+		"invalid type":    `{"type": 1}`,
+		"invalid pattern": `{"properties": {"tag": {"pattern": "("}}}`,
+	}
+	for name, data := range tests {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package tasks
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Formats of encrypted balances files.
+const (
+	// FormatSops is the format of sops (https://github.com/getsops/sops) in JSON or YAML: every value is
+	// encrypted on its own with a data key, which is encrypted with AWS KMS keys and age recipients
+	FormatSops = "sops"
+	// FormatEnvelope is the czecs envelope format: the whole file is encrypted with a data key generated by
+	// an AWS KMS key; see Envelope
+	FormatEnvelope = "envelope"
+)
+
+// kmsClient is the KMS client set by SetKMS, if any.
+var kmsClient kmsiface.KMSAPI
+
+// SetKMS sets the KMS client encrypting and decrypting the data keys of encrypted balances files, e.g. a
+// kmsfake.KMS. If not set, AWS KMS is used in the region of each key, with the session set by SetSession.
+func SetKMS(client kmsiface.KMSAPI) {
+	kmsClient = client
+}
+
+// kmsFor returns the KMS client for the given key ID, key ARN or alias, assuming the given IAM role if any.
+func kmsFor(keyID string, role string) (kmsiface.KMSAPI, error) {
+	if kmsClient != nil {
+		return kmsClient, nil
+	}
+	sess, err := getSession()
+	if err != nil {
+		return nil, err
+	}
+	config := &aws.Config{}
+	// Keys given by ARN are used in their own region, other keys in the region of the session
+	if keyARN, err := arn.Parse(keyID); err == nil {
+		config.Region = aws.String(keyARN.Region)
+	}
+	if role != "" {
+		config.Credentials = stscreds.NewCredentials(sess, role)
+	}
+	return kms.New(sess, config), nil
+}
+
+// EncryptOptions configures EncryptBalances.
+type EncryptOptions struct {
+	// Format is FormatSops or FormatEnvelope; defaults to FormatSops
+	Format string
+	// KMSKeys are the AWS KMS keys encrypting the data key, by ARN, or by ID or alias in the region of the
+	// session; the envelope format takes exactly one
+	KMSKeys []string
+	// AgeRecipients are the age public keys the data key is encrypted to, in the sops format only
+	AgeRecipients []string
+	// UnencryptedSuffix leaves values in plaintext whose key, or the key of a map they are in, ends with it,
+	// in the sops format only; defaults to _unencrypted
+	UnencryptedSuffix string
+}
+
+// EncryptedFormat returns the format of the given encrypted balances file, FormatSops or FormatEnvelope, or
+// "" if it is not encrypted.
+func EncryptedFormat(content []byte) string {
+	// Both formats are marked by a top level key; other keys are not needed to find out
+	var topLevel map[string]interface{}
+	if err := yaml.Unmarshal(content, &topLevel); err != nil {
+		return ""
+	}
+	if _, ok := topLevel[sopsKey].(map[string]interface{}); ok {
+		return FormatSops
+	}
+	if _, ok := topLevel[envelopeKey].(map[string]interface{}); ok && len(topLevel) == 1 {
+		return FormatEnvelope
+	}
+	return ""
+}
+
+// EncryptBalances encrypts the given JSON or YAML balances file. The sops format keeps the format of the
+// file; the envelope format is always JSON.
+func EncryptBalances(plaintext []byte, opts EncryptOptions) ([]byte, error) {
+	if format := EncryptedFormat(plaintext); format != "" {
+		return nil, fmt.Errorf("balances are already encrypted in the %s format", format)
+	}
+	switch opts.Format {
+	case "", FormatSops:
+		if len(opts.KMSKeys) == 0 && len(opts.AgeRecipients) == 0 {
+			return nil, errors.New("no KMS key or age recipient to encrypt the balances for")
+		}
+		return encryptSops(plaintext, opts)
+	case FormatEnvelope:
+		if len(opts.KMSKeys) != 1 || len(opts.AgeRecipients) != 0 {
+			return nil, errors.New("the envelope format is encrypted with exactly one KMS key")
+		}
+		return encryptEnvelope(plaintext, opts.KMSKeys[0])
+	default:
+		return nil, fmt.Errorf("unknown encrypted format %#v; expected %s or %s", opts.Format, FormatSops, FormatEnvelope)
+	}
+}
+
+// DecryptBalances decrypts the given encrypted balances file, returning it in the format it was encrypted from.
+func DecryptBalances(content []byte) ([]byte, error) {
+	switch EncryptedFormat(content) {
+	case FormatSops:
+		return decryptSops(content)
+	case FormatEnvelope:
+		return decryptEnvelope(content)
+	default:
+		return nil, errors.New("balances are not encrypted")
+	}
+}
+
+// ReencryptBalances encrypts the given plaintext, an edited version of the given encrypted balances file, for
+// the same keys as the encrypted file.
+func ReencryptBalances(encrypted []byte, plaintext []byte) ([]byte, error) {
+	switch EncryptedFormat(encrypted) {
+	case FormatSops:
+		return reencryptSops(encrypted, plaintext)
+	case FormatEnvelope:
+		envelope, err := parseEnvelope(encrypted)
+		if err != nil {
+			return nil, err
+		}
+		return encryptEnvelope(plaintext, envelope.KeyID)
+	default:
+		return nil, errors.New("balances are not encrypted")
+	}
+}
+
+// balancesJSON returns the content of the given balances file as JSON, decrypting it first if it is encrypted.
+func balancesJSON(content []byte) ([]byte, error) {
+	if EncryptedFormat(content) == "" {
+		return content, nil
+	}
+	plaintext, err := DecryptBalances(content)
+	if err != nil {
+		return nil, err
+	}
+	if isJSON(plaintext) {
+		return plaintext, nil
+	}
+	// sops files can be YAML
+	return yaml.YAMLToJSON(plaintext)
+}
+
+// isJSON returns whether the given JSON or YAML document is JSON.
+func isJSON(content []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(content), []byte("{"))
+}
+
+// newDataKey returns a random key for AES-256.
+func newDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// newGCM returns AES-GCM with the given key and nonce size.
+func newGCM(key []byte, nonceSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
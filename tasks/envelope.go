@@ -0,0 +1,119 @@
+package tasks
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+)
+
+// envelopeKey is the only top level key of a balances file in the czecs envelope format.
+const envelopeKey = "czecsEnvelope"
+
+// envelopeVersion is the version of the envelope format written.
+const envelopeVersion = 1
+
+// Envelope is a balances file in the czecs envelope format, as the value of its only top level key,
+// czecsEnvelope. The whole file is encrypted with AES-256-GCM, using a data key generated by an AWS KMS key:
+//
+//	{
+//	  "czecsEnvelope": {
+//	    "version": 1,
+//	    "keyId": "arn:aws:kms:us-west-2:123456789012:key/...",
+//	    "encryptedKey": "<base64 data key encrypted by KMS>",
+//	    "nonce": "<base64 nonce>",
+//	    "ciphertext": "<base64 encrypted balances file>"
+//	  }
+//	}
+type Envelope struct {
+	Version int `json:"version"`
+	// KeyID is the ARN of the KMS key that generated the data key
+	KeyID        string `json:"keyId"`
+	EncryptedKey string `json:"encryptedKey"`
+	Nonce        string `json:"nonce"`
+	Ciphertext   string `json:"ciphertext"`
+}
+
+func parseEnvelope(content []byte) (*Envelope, error) {
+	var file map[string]*Envelope
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, errors.Wrap(err, "cannot parse envelope")
+	}
+	envelope := file[envelopeKey]
+	if envelope == nil || envelope.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope; expected version %d", envelopeVersion)
+	}
+	return envelope, nil
+}
+
+func encryptEnvelope(plaintext []byte, keyID string) ([]byte, error) {
+	client, err := kmsFor(keyID, "")
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot generate a data key with KMS key %v", keyID)
+	}
+	gcm, err := newGCM(dataKey.Plaintext, 12)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope := &Envelope{
+		Version:      envelopeVersion,
+		KeyID:        aws.StringValue(dataKey.KeyId),
+		EncryptedKey: base64.StdEncoding.EncodeToString(dataKey.CiphertextBlob),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext:   base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}
+	encrypted, err := json.MarshalIndent(map[string]*Envelope{envelopeKey: envelope}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(encrypted, '\n'), nil
+}
+
+func decryptEnvelope(content []byte) ([]byte, error) {
+	envelope, err := parseEnvelope(content)
+	if err != nil {
+		return nil, err
+	}
+	var fields [3][]byte
+	for i, field := range []string{envelope.EncryptedKey, envelope.Nonce, envelope.Ciphertext} {
+		if fields[i], err = base64.StdEncoding.DecodeString(field); err != nil {
+			return nil, errors.Wrap(err, "invalid envelope")
+		}
+	}
+	encryptedKey, nonce, ciphertext := fields[0], fields[1], fields[2]
+	client, err := kmsFor(envelope.KeyID, "")
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: encryptedKey,
+		KeyId:          aws.String(envelope.KeyID),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decrypt the data key with KMS key %v", envelope.KeyID)
+	}
+	gcm, err := newGCM(dataKey.Plaintext, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decrypt envelope")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,140 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chanzuckerberg/czecs/pkg/kmsfake"
+)
+
+func TestDecryptEnvelope(t *testing.T) {
+	setTestKeys(t)
+	// envelope.json was encrypted from balances.json by czecs values encrypt --envelope
+	encrypted := readTestFile(t, "envelope.json")
+	if format := EncryptedFormat(encrypted); format != FormatEnvelope {
+		t.Fatalf("EncryptedFormat = %#v", format)
+	}
+	decrypted, err := DecryptBalances(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, readTestFile(t, "balances.json")) {
+		t.Errorf("decrypted:\n%s", decrypted)
+	}
+}
+
+func TestEncryptEnvelope(t *testing.T) {
+	setTestKeys(t)
+	for _, file := range []string{"balances.json", "balances.yaml"} {
+		plaintext := readTestFile(t, file)
+		encrypted, err := EncryptBalances(plaintext, EncryptOptions{Format: FormatEnvelope, KMSKeys: []string{testKMSKey}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(encrypted, []byte("hunter2")) {
+			t.Errorf("%s: the password is not encrypted:\n%s", file, encrypted)
+		}
+		envelope, err := parseEnvelope(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if envelope.KeyID != testKMSKey {
+			t.Errorf("%s: KeyID = %#v", file, envelope.KeyID)
+		}
+		decrypted, err := DecryptBalances(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file is encrypted as a whole, keeping its format and comments
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%s: decrypted:\n%s", file, decrypted)
+		}
+	}
+}
+
+func TestEnvelopeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// edit changes the envelope of envelope.json
+		edit    func(envelope *Envelope)
+		kms     *kmsfake.KMS
+		wantErr string
+	}{
+		{
+			name:    "unsupported version",
+			edit:    func(envelope *Envelope) { envelope.Version = 2 },
+			wantErr: "unsupported envelope",
+		},
+		{
+			name: "tampered ciphertext",
+			edit: func(envelope *Envelope) {
+				ciphertext, _ := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+				ciphertext[0] ^= 1
+				envelope.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+			},
+			wantErr: "cannot decrypt envelope",
+		},
+		{
+			name:    "invalid base64",
+			edit:    func(envelope *Envelope) { envelope.Nonce = "!" },
+			wantErr: "invalid envelope",
+		},
+		{
+			name:    "unknown KMS key",
+			kms:     kmsfake.New("alias/other"),
+			wantErr: "cannot decrypt the data key",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestKeys(t)
+			if test.kms != nil {
+				SetKMS(test.kms)
+			}
+			var file map[string]*Envelope
+			if err := json.Unmarshal(readTestFile(t, "envelope.json"), &file); err != nil {
+				t.Fatal(err)
+			}
+			if test.edit != nil {
+				test.edit(file[envelopeKey])
+			}
+			content, err := json.Marshal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = DecryptBalances(content)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEncryptBalancesErrors(t *testing.T) {
+	setTestKeys(t)
+	plaintext := readTestFile(t, "balances.json")
+	tests := map[string]struct {
+		content []byte
+		opts    EncryptOptions
+	}{
+		"already encrypted":        {content: readTestFile(t, "envelope.json"), opts: EncryptOptions{KMSKeys: []string{testKMSKey}}},
+		"no key":                   {content: plaintext},
+		"envelope with two keys":   {content: plaintext, opts: EncryptOptions{Format: FormatEnvelope, KMSKeys: []string{testKMSKey, testKMSKey}}},
+		"envelope with age":        {content: plaintext, opts: EncryptOptions{Format: FormatEnvelope, KMSKeys: []string{testKMSKey}, AgeRecipients: []string{testAgeKey}}},
+		"unknown format":           {content: plaintext, opts: EncryptOptions{Format: "pgp", KMSKeys: []string{testKMSKey}}},
+		"unknown KMS key":          {content: plaintext, opts: EncryptOptions{KMSKeys: []string{"alias/other"}}},
+		"invalid age recipient":    {content: plaintext, opts: EncryptOptions{AgeRecipients: []string{"age1invalid"}}},
+		"envelope unknown KMS key": {content: plaintext, opts: EncryptOptions{Format: FormatEnvelope, KMSKeys: []string{"alias/other"}}},
+	}
+	for name, test := range tests {
+		if _, err := EncryptBalances(test.content, test.opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := DecryptBalances(plaintext); err == nil {
+		t.Error("expected decrypting plaintext balances to fail")
+	}
+}
@@ -0,0 +1,334 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// AuthorizationEnvPrefix prefixes the names of the environment variables holding the Authorization header
+// sent to a host, followed by the host name in upper case with other characters than letters and digits
+// replaced by _, e.g. CZECS_HTTP_AUTH_TEMPLATES_EXAMPLE_COM="Bearer TOKEN".
+const AuthorizationEnvPrefix = "CZECS_HTTP_AUTH_"
+
+// checksumFragment is the URI fragment giving the expected SHA-256 checksum of the content, e.g.
+// https://example.com/czecs.json#sha256=<hex digest>
+const checksumFragment = "sha256="
+
+// FetchOptions configures how files are read from http, https and s3 URIs.
+type FetchOptions struct {
+	// Timeout is the timeout of each request; 0 means no timeout
+	Timeout time.Duration
+	// Retries is the number of times a request is retried after a network error, a throttling or a server error
+	Retries int
+	// RetryDelay is the delay before the first retry, doubled before every further retry
+	RetryDelay time.Duration
+	// CacheDir caches fetched content, revalidated by ETag, and content pinned by checksum; "" disables the cache
+	CacheDir string
+	// Authorization is the Authorization header sent to each host, e.g. "Bearer TOKEN" or "Basic dXNlcjpwYXNz";
+	// for hosts not listed, it is read from the AuthorizationEnvPrefix environment variable of the host, if any
+	Authorization map[string]string
+	// Authorize, if set, returns the Authorization header sent to a host, or "" to look it up in Authorization and
+	// the environment. It is only called when a file is read from the host, so that e.g. a secret it needs only
+	// has to be set for commands reading from that host.
+	Authorize func(host string) (string, error)
+}
+
+// DefaultFetchOptions returns the options used unless SetFetchOptions is called.
+func DefaultFetchOptions() FetchOptions {
+	return FetchOptions{
+		Timeout:    30 * time.Second,
+		Retries:    3,
+		RetryDelay: time.Second,
+	}
+}
+
+var (
+	fetchOptions = DefaultFetchOptions()
+	// bucketRegions are the regions of the S3 buckets found in another region than that of the session
+	bucketRegions   = map[string]string{}
+	bucketRegionsMu sync.Mutex
+)
+
+// SetFetchOptions sets how files are read from URIs.
+func SetFetchOptions(opts FetchOptions) {
+	fetchOptions = opts
+}
+
+// readURI reads the content of an http, https or s3 URI, verifying the checksum given as its fragment, if any.
+func readURI(uri *url.URL) ([]byte, error) {
+	checksum := ""
+	if uri.Fragment != "" {
+		if !strings.HasPrefix(uri.Fragment, checksumFragment) {
+			return nil, fmt.Errorf("unexpected fragment %#v in URI %v; expected %s<hex digest>", uri.Fragment, uri, checksumFragment)
+		}
+		checksum = strings.ToLower(strings.TrimPrefix(uri.Fragment, checksumFragment))
+	}
+	withoutFragment := *uri
+	withoutFragment.Fragment = ""
+
+	var fetch func(etag string) (*fetched, error)
+	switch uri.Scheme {
+	case "s3":
+		fetch = func(etag string) (*fetched, error) { return fetchS3(&withoutFragment, etag) }
+	case "http", "https":
+		fetch = func(etag string) (*fetched, error) { return fetchHTTP(&withoutFragment, etag) }
+	default:
+		return nil, fmt.Errorf("Unexpected url scheme %v in URI %v", uri.Scheme, uri)
+	}
+
+	content, err := fetchCached(withoutFragment.String(), checksum, fetch)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		if actual := sha256Hex(content); actual != checksum {
+			return nil, fmt.Errorf("checksum mismatch for %v: expected sha256 %s, got %s", &withoutFragment, checksum, actual)
+		}
+	}
+	return content, nil
+}
+
+// fetched is the response to a request for the content of a URI.
+type fetched struct {
+	content []byte
+	etag    string
+	// notModified is set if the content still has the ETag given in the request
+	notModified bool
+}
+
+// cacheEntry describes content cached for a URI.
+type cacheEntry struct {
+	URI    string `json:"uri"`
+	ETag   string `json:"etag,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+// fetchCached returns the content of the URI, from the cache if it has content with the given checksum or
+// the content has not changed since it was cached, and otherwise by fetching it and caching it.
+func fetchCached(uri string, checksum string, fetch func(etag string) (*fetched, error)) ([]byte, error) {
+	dir := fetchOptions.CacheDir
+	if dir == "" {
+		result, err := fetch("")
+		if err != nil {
+			return nil, err
+		}
+		return result.content, nil
+	}
+
+	key := sha256Hex([]byte(uri))
+	entryFile, contentFile := filepath.Join(dir, key+".json"), filepath.Join(dir, key)
+	var entry cacheEntry
+	var cached []byte
+	if rawEntry, err := ioutil.ReadFile(entryFile); err == nil && json.Unmarshal(rawEntry, &entry) == nil {
+		if cached, err = ioutil.ReadFile(contentFile); err != nil || sha256Hex(cached) != entry.SHA256 {
+			// Incomplete or corrupted entry; fetch the content again
+			entry, cached = cacheEntry{}, nil
+		}
+	}
+	if cached != nil && checksum != "" && entry.SHA256 == checksum {
+		return cached, nil
+	}
+
+	result, err := fetch(entry.ETag)
+	if err != nil {
+		return nil, err
+	}
+	if result.notModified && cached != nil {
+		return cached, nil
+	}
+	if result.etag == "" && checksum == "" {
+		// Nothing to revalidate the content with later
+		return result.content, nil
+	}
+	entry = cacheEntry{URI: uri, ETag: result.etag, SHA256: sha256Hex(result.content)}
+	rawEntry, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(contentFile, result.content, 0600)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(entryFile, rawEntry, 0600)
+	}
+	if err != nil {
+		// The content was fetched; caching it is only an optimization
+		log.Warnf("Cannot cache %v in %v: %v", uri, dir, err)
+	}
+	return result.content, nil
+}
+
+// fetchHTTP gets the content of an http or https URI, retrying with backoff on network errors, throttling
+// and server errors. Any other status than 200 (or 304 if etag is given) is an error.
+func fetchHTTP(uri *url.URL, etag string) (*fetched, error) {
+	client := &http.Client{Timeout: fetchOptions.Timeout}
+	delay := fetchOptions.RetryDelay
+	for attempt := 0; ; attempt++ {
+		result, retryable, err := getHTTP(client, uri, etag)
+		if err == nil || !retryable || attempt >= fetchOptions.Retries {
+			return result, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// getHTTP makes a single request for the content of the URI, and returns whether a failure can be retried.
+func getHTTP(client *http.Client, uri *url.URL, etag string) (*fetched, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Could not retrieve %v", uri)
+	}
+	authorization, err := authorizationFor(uri.Hostname())
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Could not retrieve %v", uri)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, errors.Wrapf(err, "Could not retrieve %v", uri)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return &fetched{etag: etag, notModified: true}, false, nil
+	case resp.StatusCode == http.StatusOK:
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, true, errors.Wrapf(err, "Could not retrieve %v", uri)
+		}
+		return &fetched{content: content, etag: resp.Header.Get("ETag")}, false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return nil, retryable, fmt.Errorf("Could not retrieve %v: HTTP status %s", uri, resp.Status)
+}
+
+// authorizationFor returns the Authorization header to send to the host, or "" if none.
+func authorizationFor(host string) (string, error) {
+	if fetchOptions.Authorize != nil {
+		authorization, err := fetchOptions.Authorize(host)
+		if err != nil || authorization != "" {
+			return authorization, err
+		}
+	}
+	if authorization, ok := fetchOptions.Authorization[host]; ok {
+		return authorization, nil
+	}
+	return os.Getenv(AuthorizationEnvName(host)), nil
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]`)
+
+// AuthorizationEnvName returns the name of the environment variable holding the Authorization header sent to the host.
+func AuthorizationEnvName(host string) string {
+	return AuthorizationEnvPrefix + nonAlphanumeric.ReplaceAllString(strings.ToUpper(host), "_")
+}
+
+// fetchS3 gets the object of an s3://bucket/key URI, of the version given by its versionId query parameter if
+// any. If the bucket is in another region than that of the session, its region is looked up and used instead.
+func fetchS3(uri *url.URL, etag string) (*fetched, error) {
+	sess, err := getSession()
+	if err != nil {
+		return nil, err
+	}
+	bucket := uri.Host
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(strings.TrimPrefix(uri.Path, "/")),
+	}
+	if versionID := uri.Query().Get("versionId"); versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+
+	bucketRegionsMu.Lock()
+	region := bucketRegions[bucket]
+	bucketRegionsMu.Unlock()
+	result, err := getS3(sess, region, input)
+	if err != nil && region == "" && isWrongRegion(err) {
+		region, err = s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, aws.StringValue(sess.Config.Region))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not find the region of S3 bucket %v", bucket)
+		}
+		bucketRegionsMu.Lock()
+		bucketRegions[bucket] = region
+		bucketRegionsMu.Unlock()
+		result, err = getS3(sess, region, input)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not retrieve S3 object %v", uri)
+	}
+	return result, nil
+}
+
+// getS3 gets the object from S3 in the given region, or that of the session if "".
+func getS3(sess *session.Session, region string, input *s3.GetObjectInput) (*fetched, error) {
+	config := &aws.Config{MaxRetries: aws.Int(fetchOptions.Retries)}
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+	ctx := aws.BackgroundContext()
+	if fetchOptions.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fetchOptions.Timeout)
+		defer cancel()
+	}
+	output, err := s3.New(sess, config).GetObjectWithContext(ctx, input)
+	if requestFailure, ok := err.(awserr.RequestFailure); ok && requestFailure.StatusCode() == http.StatusNotModified {
+		return &fetched{etag: aws.StringValue(input.IfNoneMatch), notModified: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	content, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &fetched{content: content, etag: aws.StringValue(output.ETag)}, nil
+}
+
+// isWrongRegion returns whether the S3 request failed because the bucket is in another region.
+func isWrongRegion(err error) bool {
+	requestFailure, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return false
+	}
+	switch requestFailure.Code() {
+	case "BucketRegionError", "PermanentRedirect", "AuthorizationHeaderMalformed":
+		return true
+	}
+	return requestFailure.StatusCode() == http.StatusMovedPermanently
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,238 @@
+package tasks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves the responses of respond, recording the requests made to it.
+type testServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+func newTestServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, attempt int)) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, r)
+		attempt := len(s.requests)
+		s.mutex.Unlock()
+		respond(w, r, attempt)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) Requests() []*http.Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*http.Request{}, s.requests...)
+}
+
+// setTestFetchOptions sets fetch options retrying quickly, and caching in a temporary directory if cache is set.
+func setTestFetchOptions(t *testing.T, cache bool) {
+	previous := fetchOptions
+	t.Cleanup(func() { SetFetchOptions(previous) })
+	opts := DefaultFetchOptions()
+	opts.RetryDelay = time.Millisecond
+	if cache {
+		opts.CacheDir = t.TempDir()
+	}
+	SetFetchOptions(opts)
+}
+
+func readTestURI(t *testing.T, uri string) ([]byte, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readURI(parsed)
+}
+
+func TestReadURIStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are the statuses of the successive responses, the last one repeating
+		statuses []int
+
+		wantErr      string
+		wantRequests int
+	}{
+		{
+			name:         "ok",
+			statuses:     []int{http.StatusOK},
+			wantRequests: 1,
+		},
+		{
+			name:         "not found is not retried",
+			statuses:     []int{http.StatusNotFound},
+			wantErr:      "HTTP status 404",
+			wantRequests: 1,
+		},
+		{
+			name:         "server errors are retried",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			wantRequests: 3,
+		},
+		{
+			name:         "throttling is retried",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			wantRequests: 2,
+		},
+		{
+			name:         "retries are exhausted",
+			statuses:     []int{http.StatusServiceUnavailable},
+			wantErr:      "HTTP status 503",
+			wantRequests: 4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestFetchOptions(t, false)
+			server := newTestServer(t, func(w http.ResponseWriter, r *http.Request, attempt int) {
+				status := test.statuses[len(test.statuses)-1]
+				if attempt <= len(test.statuses) {
+					status = test.statuses[attempt-1]
+				}
+				w.WriteHeader(status)
+				w.Write([]byte("content"))
+			})
+
+			content, err := readTestURI(t, server.URL+"/czecs.json")
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			case err == nil && string(content) != "content":
+				t.Errorf("content = %#v", string(content))
+			}
+			if requests := len(server.Requests()); requests != test.wantRequests {
+				t.Errorf("made %d requests, want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestReadURINotModified(t *testing.T) {
+	setTestFetchOptions(t, true)
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request, attempt int) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("content"))
+	})
+
+	for i := 0; i < 2; i++ {
+		content, err := readTestURI(t, server.URL+"/czecs.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "content" {
+			t.Errorf("content = %#v", string(content))
+		}
+	}
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("made %d requests, want 2", len(requests))
+	}
+	if etag := requests[1].Header.Get("If-None-Match"); etag != `"v1"` {
+		t.Errorf("expected the cached content to be revalidated, got If-None-Match %#v", etag)
+	}
+}
+
+func TestReadURIChecksum(t *testing.T) {
+	setTestFetchOptions(t, true)
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request, attempt int) {
+		w.Write([]byte("content"))
+	})
+
+	_, err := readTestURI(t, server.URL+"/czecs.json#sha256="+sha256Hex([]byte("other content")))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	// The content fetched above was cached, and is served from the cache once pinned by its checksum
+	uri := server.URL + "/czecs.json#sha256=" + strings.ToUpper(sha256Hex([]byte("content")))
+	for i := 0; i < 2; i++ {
+		content, err := readTestURI(t, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "content" {
+			t.Errorf("content = %#v", string(content))
+		}
+	}
+	if requests := len(server.Requests()); requests != 1 {
+		t.Errorf("made %d requests, want 1", requests)
+	}
+
+	if _, err := readTestURI(t, server.URL+"/czecs.json#md5=abc"); err == nil {
+		t.Error("expected an unknown fragment to be rejected")
+	}
+}
+
+func TestReadURIAuthorization(t *testing.T) {
+	setTestFetchOptions(t, false)
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request, attempt int) {
+		if r.Header.Get("Authorization") != "Bearer TOKEN" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("content"))
+	})
+	uri := server.URL + "/czecs.json"
+	parsed, _ := url.Parse(uri)
+
+	if _, err := readTestURI(t, uri); err == nil || !strings.Contains(err.Error(), "HTTP status 401") {
+		t.Fatalf("expected the request to be unauthorized without a header, got %v", err)
+	}
+
+	name := AuthorizationEnvName(parsed.Hostname())
+	if name != "CZECS_HTTP_AUTH_127_0_0_1" {
+		t.Errorf("AuthorizationEnvName = %#v", name)
+	}
+	t.Setenv(name, "Bearer TOKEN")
+	if _, err := readTestURI(t, uri); err != nil {
+		t.Fatal(err)
+	}
+
+	// Headers given in the options take precedence over the environment
+	opts := fetchOptions
+	opts.Authorization = map[string]string{parsed.Hostname(): "Bearer OTHER"}
+	SetFetchOptions(opts)
+	if _, err := readTestURI(t, uri); err == nil || !strings.Contains(err.Error(), "HTTP status 401") {
+		t.Errorf("expected the header of the options to be sent, got %v", err)
+	}
+
+	// Authorize takes precedence, and is only called for the host read from
+	var hosts []string
+	opts.Authorize = func(host string) (string, error) {
+		hosts = append(hosts, host)
+		return "Bearer TOKEN", nil
+	}
+	SetFetchOptions(opts)
+	if _, err := readTestURI(t, uri); err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0] != parsed.Hostname() {
+		t.Errorf("Authorize called for %v", hosts)
+	}
+	opts.Authorize = func(host string) (string, error) {
+		return "", errors.New("environment variable TOKEN is not set")
+	}
+	SetFetchOptions(opts)
+	if _, err := readTestURI(t, uri); err == nil || !strings.Contains(err.Error(), "TOKEN is not set") {
+		t.Errorf("expected the error of Authorize, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// GitPrefix prefixes sources read from a git repository, of the form git::<repository URL>//<path>?ref=<ref>,
+// e.g. git::https://github.com/example/infra.git//ecs/czecs.json?ref=v1.2.0. The repository URL can use any
+// protocol git supports with a URL, e.g. https, ssh or file. The ref is a tag, branch or commit SHA; the
+// default branch is used if it is not given.
+const GitPrefix = "git::"
+
+var (
+	// fetchedRepositories are the cached repositories fetched by this process, which are not fetched again
+	fetchedRepositories   = map[string]bool{}
+	fetchedRepositoriesMu sync.Mutex
+	commitSHA             = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// gitSource is a file in a git repository.
+type gitSource struct {
+	repository string
+	ref        string
+	path       string
+}
+
+// IsGitSource returns whether the given string is a source in a git repository, of the form described by GitPrefix.
+func IsGitSource(fileOrURI string) bool {
+	return strings.HasPrefix(fileOrURI, GitPrefix)
+}
+
+func parseGitSource(source string) (*gitSource, error) {
+	uri, err := url.Parse(strings.TrimPrefix(source, GitPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid git source %v", source)
+	}
+	// The path of the file in the repository follows the first // of the URL path
+	separator := strings.Index(uri.Path, "//")
+	if separator < 0 || uri.Path[separator+2:] == "" {
+		return nil, fmt.Errorf("git source %v has no file path; expected %s<repository URL>//<path>[?ref=<ref>]", source, GitPrefix)
+	}
+	query := uri.Query()
+	parsed := &gitSource{
+		ref:  query.Get("ref"),
+		path: uri.Path[separator+2:],
+	}
+	query.Del("ref")
+	uri.RawQuery = query.Encode()
+	uri.Path = uri.Path[:separator]
+	uri.RawPath = ""
+	parsed.repository = uri.String()
+	return parsed, nil
+}
+
+// String returns the source in the form described by GitPrefix.
+func (s *gitSource) String() string {
+	source := GitPrefix + s.repository + "//" + s.path
+	if s.ref != "" {
+		source += "?ref=" + url.QueryEscape(s.ref)
+	}
+	return source
+}
+
+// Resolve returns the local path of the given file: for a git source, the path of the file in a checkout of
+// its repository at its ref, so that files next to it can be found using relative paths; any other file
+// name or URI is returned as is.
+//
+// Repositories are cloned into the git directory of the cache directory of FetchOptions, or of the user's
+// cache directory if none, and fetched again once per process, unless a commit SHA is requested that is
+// already there. Each commit is checked out into its own directory, which is reused afterwards.
+func Resolve(fileOrURI string) (string, error) {
+	if !IsGitSource(fileOrURI) {
+		return fileOrURI, nil
+	}
+	source, err := parseGitSource(fileOrURI)
+	if err != nil {
+		return "", err
+	}
+	checkout, err := checkoutGit(source.repository, source.ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot check out %v", fileOrURI)
+	}
+	return filepath.Join(checkout, filepath.FromSlash(source.path)), nil
+}
+
+// checkoutGit returns the directory of a checkout of the repository at the given ref.
+func checkoutGit(repository string, ref string) (string, error) {
+	// git would take them for options
+	if strings.HasPrefix(repository, "-") {
+		return "", fmt.Errorf("invalid git repository %#v: must not start with -", repository)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid git ref %#v: must not start with -", ref)
+	}
+	root, err := gitCacheDir(repository)
+	if err != nil {
+		return "", err
+	}
+	mirror := filepath.Join(root, "repository.git")
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	fetchedRepositoriesMu.Lock()
+	defer fetchedRepositoriesMu.Unlock()
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		log.Infof("Cloning %v", repository)
+		if _, err := runGit("", "clone", "--bare", "--quiet", "--", repository, mirror); err != nil {
+			os.RemoveAll(mirror)
+			return "", err
+		}
+		fetchedRepositories[repository] = true
+	}
+	pinned := commitSHA.MatchString(ref)
+	if pinned {
+		// A commit never changes, so there is no need to fetch it again once it is there
+		if _, err := runGit(mirror, "cat-file", "-e", ref+"^{commit}"); err == nil {
+			fetchedRepositories[repository] = true
+		}
+	}
+	if !fetchedRepositories[repository] {
+		log.Debugf("Fetching %v", repository)
+		if _, err := runGit(mirror, "fetch", "--quiet", "--force", "--prune", "--tags", "origin", "+refs/heads/*:refs/heads/*"); err != nil {
+			return "", err
+		}
+		fetchedRepositories[repository] = true
+	}
+
+	commit, err := runGit(mirror, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref %#v not found in %v", ref, repository)
+	}
+	checkout := filepath.Join(root, commit)
+	if _, err := os.Stat(checkout); err == nil {
+		return checkout, nil
+	}
+	log.Debugf("Checking out %v at %v (%s)", repository, ref, commit)
+	if _, err := runGit(mirror, "worktree", "add", "--detach", "--force", "--", checkout, commit); err != nil {
+		os.RemoveAll(checkout)
+		return "", err
+	}
+	return checkout, nil
+}
+
+// gitCacheDir returns the directory caching the repository and its checkouts.
+func gitCacheDir(repository string) (string, error) {
+	dir := fetchOptions.CacheDir
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Wrap(err, "cannot find a directory to clone git repositories into; set a cache directory")
+		}
+		dir = filepath.Join(userCacheDir, "czecs")
+	}
+	return filepath.Join(dir, "git", sha256Hex([]byte(repository))[:16]), nil
+}
+
+// runGit runs git in the given git directory (if any), returning its trimmed output.
+func runGit(gitDir string, args ...string) (string, error) {
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Never prompt for credentials; fail instead
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
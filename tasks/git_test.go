@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseGitSource(t *testing.T) {
+	tests := []struct {
+		source string
+
+		wantRepository string
+		wantRef        string
+		wantPath       string
+		wantErr        bool
+	}{
+		{
+			source:         "git::https://github.com/example/infra.git//ecs/czecs.json?ref=v1.2.0",
+			wantRepository: "https://github.com/example/infra.git",
+			wantRef:        "v1.2.0",
+			wantPath:       "ecs/czecs.json",
+		},
+		{
+			source:         "git::file:///src/infra//czecs.json",
+			wantRepository: "file:///src/infra",
+			wantPath:       "czecs.json",
+		},
+		{
+			source:  "git::https://github.com/example/infra.git",
+			wantErr: true,
+		},
+		{
+			source:  "git::https://github.com/example/infra.git//?ref=v1",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			source, err := parseGitSource(test.source)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if source.repository != test.wantRepository || source.ref != test.wantRef || source.path != test.wantPath {
+				t.Errorf("parsed %#v", *source)
+			}
+		})
+	}
+}
+
+// newGitRepository creates a repository with a commit of czecs.json with the given content, tagged v1.
+func newGitRepository(t *testing.T, content string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "czecs.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet", dir},
+		{"-C", dir, "add", "czecs.json"},
+		{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "czecs.json"},
+		{"-C", dir, "tag", "v1"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return dir
+}
+
+func TestResolveGitSource(t *testing.T) {
+	repository := newGitRepository(t, `{"family": "web"}`)
+	defer SetFetchOptions(fetchOptions)
+	opts := DefaultFetchOptions()
+	opts.CacheDir = t.TempDir()
+	SetFetchOptions(opts)
+
+	for _, ref := range []string{"", "?ref=v1"} {
+		file, err := Resolve("git::file://" + filepath.ToSlash(repository) + "//czecs.json" + ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(file, opts.CacheDir) {
+			t.Errorf("%s is not in the cache directory %s", file, opts.CacheDir)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != `{"family": "web"}` {
+			t.Errorf("content = %#v", string(content))
+		}
+	}
+
+	if _, err := Resolve("git::file://" + filepath.ToSlash(repository) + "//czecs.json?ref=v2"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a missing ref to fail, got %v", err)
+	}
+}
+
+func TestCheckoutGitRejectsOptions(t *testing.T) {
+	defer SetFetchOptions(fetchOptions)
+	opts := DefaultFetchOptions()
+	opts.CacheDir = t.TempDir()
+	SetFetchOptions(opts)
+
+	tests := []struct {
+		repository string
+		ref        string
+	}{
+		{repository: "--upload-pack=touch pwned", ref: "v1"},
+		{repository: "file:///src/infra", ref: "--output=pwned"},
+	}
+	for _, test := range tests {
+		_, err := checkoutGit(test.repository, test.ref)
+		if err == nil || !strings.Contains(err.Error(), "must not start with -") {
+			t.Errorf("checkoutGit(%#v, %#v) = %v, expected it to be rejected", test.repository, test.ref, err)
+		}
+	}
+	if entries, _ := ioutil.ReadDir(opts.CacheDir); len(entries) != 0 {
+		t.Errorf("expected nothing to be cloned, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// PartialsPattern matches the files of a directory loaded as partials.
+const PartialsPattern = "_*.tpl"
+
+// templateFuncs returns the functions available to templates. include renders a named template defined
+// in tmpl or any of its partials, so that its output can be piped to indent or nindent.
+func templateFuncs(tmpl *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			var out bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&out, name, data); err != nil {
+				return "", err
+			}
+			return out.String(), nil
+		},
+		"indent": indent,
+		"nindent": func(spaces int, s string) string {
+			return "\n" + indent(spaces, s)
+		},
+	}
+}
+
+// indent indents every line of s by the given number of spaces.
+func indent(spaces int, s string) string {
+	padding := strings.Repeat(" ", spaces)
+	return padding + strings.Replace(s, "\n", "\n"+padding, -1)
+}
+
+// partialFiles returns the partials of a template: the _*.tpl files next to a local template, followed by
+// the given partials, each either a local or git directory of _*.tpl files or a file name or URI of one partial.
+func partialFiles(defnFilename string, partials []string) ([]string, error) {
+	var sources []string
+	if !IsURI(defnFilename) {
+		sources = append(sources, filepath.Dir(defnFilename))
+	}
+	sources = append(sources, partials...)
+
+	var files []string
+	seen := map[string]bool{}
+	for _, source := range sources {
+		// A directory of partials can be in a git repository
+		source, err := Resolve(source)
+		if err != nil {
+			return nil, err
+		}
+		matches := []string{source}
+		if info, err := os.Stat(source); !IsURI(source) && err == nil && info.IsDir() {
+			matches, err = filepath.Glob(filepath.Join(source, PartialsPattern))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error listing partials in %v", source)
+			}
+			sort.Strings(matches)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// parsePartials adds the partials of a template to tmpl. Partials define named templates with
+// {{define "name"}}, which the template can use with include or template. A named template defined more than
+// once is the one defined last, in the order of partialFiles, so the given partials override those next to the
+// template; the template itself overrides them all.
+func parsePartials(tmpl *template.Template, defnFilename string, partials []string) error {
+	files, err := partialFiles(defnFilename, partials)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		rawPartial, err := ReadFileOrURI(file)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading partial %v", file)
+		}
+		if _, err := tmpl.New(file).Parse(string(rawPartial)); err != nil {
+			return errors.Wrapf(err, "Error parsing partial %v", file)
+		}
+	}
+	return nil
+}
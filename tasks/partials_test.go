@@ -0,0 +1,159 @@
+package tasks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePartialFiles writes files with the given contents, by path relative to a temporary directory, returning
+// the directory.
+func writePartialFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestProcessTemplatePartials(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// partials are the explicit partials, relative to the directory of the files
+		partials []string
+
+		want    string
+		wantErr string
+	}{
+		{
+			name: "partials next to the template",
+			files: map[string]string{
+				"web.json":        `{"logConfiguration": {{ include "log" .Values | nindent 2 }}}`,
+				"_log.tpl":        `{{ define "log" }}{"logDriver": "awslogs",` + "\n" + `"options": {"awslogs-group": "{{ .group }}"}}{{ end }}`,
+				"notapartial.tpl": `{{ define "log" }}ignored{{ end }}`,
+			},
+			want: "{\"logConfiguration\": \n  {\"logDriver\": \"awslogs\",\n  \"options\": {\"awslogs-group\": \"web\"}}}",
+		},
+		{
+			name: "explicit partial files and directories",
+			files: map[string]string{
+				"web.json":             `[{{ template "secrets" }}, {{ include "sidecar" . }}]`,
+				"shared/_secrets.tpl":  `{{ define "secrets" }}"secrets"{{ end }}`,
+				"shared/sidecar.tpl":   `{{ define "sidecar" }}"sidecar"{{ end }}`,
+				"shared/_ignored.json": `{{ define "sidecar" }}ignored{{ end }}`,
+			},
+			partials: []string{"shared", "shared/sidecar.tpl"},
+			want:     `["secrets", "sidecar"]`,
+		},
+		{
+			name: "explicit partials override the partials next to the template",
+			files: map[string]string{
+				"web.json":           `{{ include "image" . }}`,
+				"_image.tpl":         `{{ define "image" }}default{{ end }}`,
+				"override/_a.tpl":    `{{ define "image" }}override{{ end }}`,
+				"override/_b.tpl":    `{{ define "image" }}later override{{ end }}`,
+				"override/image.tpl": `{{ define "image" }}not a partial{{ end }}`,
+			},
+			partials: []string{"override"},
+			want:     "later override",
+		},
+		{
+			name: "the last explicit partial wins",
+			files: map[string]string{
+				"web.json": `{{ include "image" . }}`,
+				"a.tpl":    `{{ define "image" }}a{{ end }}`,
+				"b.tpl":    `{{ define "image" }}b{{ end }}`,
+			},
+			partials: []string{"b.tpl", "a.tpl", "b.tpl"},
+			// A partial given twice is only loaded the first time
+			want: "a",
+		},
+		{
+			name: "the template overrides partials",
+			files: map[string]string{
+				"web.json":   `{{ define "image" }}template{{ end }}{{ include "image" . }}`,
+				"_image.tpl": `{{ define "image" }}partial{{ end }}`,
+			},
+			want: "template",
+		},
+		{
+			name: "missing partial",
+			files: map[string]string{
+				"web.json": `{{ include "missing" . }}`,
+			},
+			wantErr: `no template "missing"`,
+		},
+		{
+			name: "invalid partial",
+			files: map[string]string{
+				"web.json": `{}`,
+				"_bad.tpl": `{{ define "bad" }}`,
+			},
+			wantErr: "Error parsing partial",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writePartialFiles(t, test.files)
+			var partials []string
+			for _, partial := range test.partials {
+				partials = append(partials, filepath.Join(dir, partial))
+			}
+			values := map[string]interface{}{"Values": map[string]interface{}{"group": "web"}}
+			got, err := processTemplate(filepath.Join(dir, "web.json"), values, false, partials)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			}
+			if got != test.want {
+				t.Errorf("processTemplate = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPartialFiles(t *testing.T) {
+	dir := writePartialFiles(t, map[string]string{
+		"web.json":         `{}`,
+		"_b.tpl":           ``,
+		"_a.tpl":           ``,
+		"shared/_c.tpl":    ``,
+		"shared/other.tpl": ``,
+	})
+	got, err := partialFiles(filepath.Join(dir, "web.json"), []string{
+		filepath.Join(dir, "shared"),
+		filepath.Join(dir, "_a.tpl"),
+		"s3://bucket/_d.tpl",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sorted within each directory, in the order given, without duplicates
+	want := []string{
+		filepath.Join(dir, "_a.tpl"),
+		filepath.Join(dir, "_b.tpl"),
+		filepath.Join(dir, "shared", "_c.tpl"),
+		"s3://bucket/_d.tpl",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("partialFiles = %v, want %v", got, want)
+	}
+
+	// Remote templates have no partials next to them
+	got, err = partialFiles("s3://bucket/web.json", nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("partialFiles of a remote template = %v, %v", got, err)
+	}
+}
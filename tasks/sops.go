@@ -0,0 +1,682 @@
+package tasks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sopsKey is the top level key holding the metadata of a sops file.
+const sopsKey = "sops"
+
+// sopsVersion is the version of sops whose format is written.
+const sopsVersion = "3.7.3"
+
+// sopsNonceSize is the size of the AES-GCM nonce of each value sops encrypts.
+const sopsNonceSize = 32
+
+// sopsMACOnlyEncryptedInit starts the MAC of sops files with mac_only_encrypted, so that it differs from the
+// MAC of the same values without it.
+var sopsMACOnlyEncryptedInit = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// sopsValue matches a value encrypted by sops.
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsMetadata is the metadata of a sops file, holding its data key encrypted for each of its keys. Other key
+// types than AWS KMS and age, e.g. PGP, are not supported but kept when the file is re-encrypted.
+type sopsMetadata struct {
+	KMS               []sopsKMSKey  `yaml:"kms,omitempty"`
+	Age               []sopsAgeKey  `yaml:"age,omitempty"`
+	KeyGroups         []interface{} `yaml:"key_groups,omitempty"`
+	LastModified      string        `yaml:"lastmodified"`
+	MAC               string        `yaml:"mac"`
+	UnencryptedSuffix string        `yaml:"unencrypted_suffix,omitempty"`
+	EncryptedSuffix   string        `yaml:"encrypted_suffix,omitempty"`
+	UnencryptedRegex  string        `yaml:"unencrypted_regex,omitempty"`
+	EncryptedRegex    string        `yaml:"encrypted_regex,omitempty"`
+	MACOnlyEncrypted  bool          `yaml:"mac_only_encrypted,omitempty"`
+	Version           string        `yaml:"version"`
+}
+
+// sopsKMSKey is the data key encrypted with an AWS KMS key.
+type sopsKMSKey struct {
+	ARN        string             `yaml:"arn"`
+	Role       string             `yaml:"role,omitempty"`
+	Context    map[string]*string `yaml:"context,omitempty"`
+	CreatedAt  string             `yaml:"created_at"`
+	Enc        string             `yaml:"enc"`
+	AWSProfile string             `yaml:"aws_profile"`
+}
+
+// sopsAgeKey is the data key encrypted to an age recipient.
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsFile is a parsed sops file: its values and its metadata.
+type sopsFile struct {
+	tree     yaml.MapSlice
+	raw      yaml.MapSlice
+	metadata sopsMetadata
+}
+
+// parseSops parses a JSON or YAML sops file, keeping the order of its keys, which the MAC depends on.
+func parseSops(content []byte) (*sopsFile, error) {
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, errors.Wrap(err, "cannot parse sops file")
+	}
+	file := &sopsFile{}
+	for _, item := range tree {
+		if item.Key != sopsKey {
+			file.tree = append(file.tree, item)
+			continue
+		}
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(raw, &file.raw); err != nil {
+			return nil, errors.Wrap(err, "cannot parse sops metadata")
+		}
+		if err := yaml.Unmarshal(raw, &file.metadata); err != nil {
+			return nil, errors.Wrap(err, "cannot parse sops metadata")
+		}
+	}
+	if len(file.metadata.KeyGroups) > 0 {
+		return nil, errors.New("sops files with key groups are not supported; use sops to decrypt or edit them")
+	}
+	return file, nil
+}
+
+// checkNoComments fails if the YAML file has comments. sops keeps comments (encrypted) but czecs cannot, so
+// they would be lost when encrypting the file; only decrypting drops them, since they are not authenticated.
+func checkNoComments(content []byte) error {
+	if !isJSON(content) && yamlHasComments(content) {
+		return errors.New("YAML files with comments cannot be encrypted without losing their comments; remove them, or edit the file with sops")
+	}
+	return nil
+}
+
+// yamlHasComments returns whether the YAML document has comments: a # at the start of a line or after
+// whitespace, outside quoted strings and block scalars. Quoted strings spanning lines are not supported.
+func yamlHasComments(content []byte) bool {
+	// blockIndent is the indentation of the line starting the block scalar being skipped, if any
+	blockIndent := -1
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(trimmed)
+		if blockIndent >= 0 {
+			if strings.TrimSpace(trimmed) == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		var quote byte
+		for i := 0; i < len(trimmed); i++ {
+			c := trimmed[i]
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t:-[{,", trimmed[i-1]) >= 0):
+				quote = c
+			case quote == 0 && c == '#' && (i == 0 || trimmed[i-1] == ' ' || trimmed[i-1] == '\t'):
+				return true
+			}
+		}
+		if blockScalar.MatchString(trimmed) {
+			blockIndent = indent
+		}
+	}
+	return false
+}
+
+// blockScalar matches a line starting a literal or folded block scalar, e.g. "key: |" or "- >-".
+var blockScalar = regexp.MustCompile(`(^|:|^-)\s*[|>][0-9+-]*\s*$`)
+
+func decryptSops(content []byte) ([]byte, error) {
+	file, err := parseSops(content)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := file.metadata.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := file.decrypt(dataKey); err != nil {
+		return nil, err
+	}
+	return marshalSops(content, file.tree)
+}
+
+func encryptSops(plaintext []byte, opts EncryptOptions) ([]byte, error) {
+	if err := checkNoComments(plaintext); err != nil {
+		return nil, err
+	}
+	file, err := parseSops(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	file.metadata = sopsMetadata{
+		UnencryptedSuffix: opts.UnencryptedSuffix,
+		Version:           sopsVersion,
+	}
+	if file.metadata.UnencryptedSuffix == "" {
+		file.metadata.UnencryptedSuffix = "_unencrypted"
+	}
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, keyID := range opts.KMSKeys {
+		client, err := kmsFor(keyID, "")
+		if err != nil {
+			return nil, err
+		}
+		output, err := client.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: dataKey})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot encrypt the data key with KMS key %v", keyID)
+		}
+		file.metadata.KMS = append(file.metadata.KMS, sopsKMSKey{
+			ARN:       aws.StringValue(output.KeyId),
+			CreatedAt: now,
+			Enc:       base64.StdEncoding.EncodeToString(output.CiphertextBlob),
+		})
+	}
+	for _, recipient := range opts.AgeRecipients {
+		enc, err := ageEncrypt(recipient, dataKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot encrypt the data key to age recipient %v", recipient)
+		}
+		file.metadata.Age = append(file.metadata.Age, sopsAgeKey{Recipient: recipient, Enc: enc})
+	}
+	raw, err := yaml.Marshal(file.metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(raw, &file.raw); err != nil {
+		return nil, err
+	}
+	return file.seal(plaintext, dataKey)
+}
+
+// reencryptSops encrypts the plaintext with the data key and for the keys of the encrypted sops file.
+func reencryptSops(encrypted []byte, plaintext []byte) ([]byte, error) {
+	if format := EncryptedFormat(plaintext); format != "" {
+		return nil, fmt.Errorf("balances are already encrypted in the %s format", format)
+	}
+	for _, content := range [][]byte{encrypted, plaintext} {
+		if err := checkNoComments(content); err != nil {
+			return nil, err
+		}
+	}
+	file, err := parseSops(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := file.metadata.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	edited, err := parseSops(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	file.tree = edited.tree
+	return file.seal(plaintext, dataKey)
+}
+
+// decrypt decrypts the values of the file, and verifies its MAC.
+func (f *sopsFile) decrypt(dataKey []byte) error {
+	rules, err := f.metadata.rules()
+	if err != nil {
+		return err
+	}
+	hash := f.metadata.newMAC()
+	err = sopsWalk(f.tree, func(value interface{}, path []string) (interface{}, error) {
+		encrypted := rules.encrypted(path)
+		if encrypted {
+			if encryptedValue, ok := value.(string); ok {
+				var err error
+				if value, err = sopsDecryptValue(encryptedValue, dataKey, sopsPath(path)); err != nil {
+					return nil, errors.Wrapf(err, "cannot decrypt the value of %s", strings.Join(path, "."))
+				}
+			}
+		}
+		if encrypted || !f.metadata.MACOnlyEncrypted {
+			hash.Write(sopsBytes(value))
+		}
+		return value, nil
+	})
+	if err != nil {
+		return err
+	}
+	mac, err := sopsDecryptValue(f.metadata.MAC, dataKey, f.metadata.LastModified)
+	if err != nil {
+		return errors.Wrap(err, "cannot decrypt the MAC")
+	}
+	if mac != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return errors.New("MAC mismatch: the sops file was modified without its data key, or is corrupted")
+	}
+	return nil
+}
+
+// seal encrypts the values of the file with the data key, then returns the file with its metadata in the
+// format of the given original file.
+func (f *sopsFile) seal(original []byte, dataKey []byte) ([]byte, error) {
+	rules, err := f.metadata.rules()
+	if err != nil {
+		return nil, err
+	}
+	hash := f.metadata.newMAC()
+	err = sopsWalk(f.tree, func(value interface{}, path []string) (interface{}, error) {
+		encrypted := rules.encrypted(path)
+		if encrypted || !f.metadata.MACOnlyEncrypted {
+			hash.Write(sopsBytes(value))
+		}
+		if !encrypted {
+			return value, nil
+		}
+		return sopsEncryptValue(value, dataKey, sopsPath(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	mac, err := sopsEncryptValue(fmt.Sprintf("%X", hash.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	f.raw = setItem(f.raw, "lastmodified", lastModified)
+	f.raw = setItem(f.raw, "mac", mac)
+	return marshalSops(original, append(f.tree, yaml.MapItem{Key: sopsKey, Value: f.raw}))
+}
+
+// newMAC returns the hash of the values authenticated by the MAC of the file.
+func (m sopsMetadata) newMAC() hash.Hash {
+	mac := sha512.New()
+	if m.MACOnlyEncrypted {
+		mac.Write(sopsMACOnlyEncryptedInit)
+	}
+	return mac
+}
+
+// dataKey decrypts the data key with the first of the keys of the file that can decrypt it.
+func (m sopsMetadata) dataKey() ([]byte, error) {
+	var failures []string
+	for _, key := range m.KMS {
+		dataKey, err := key.decrypt()
+		if err == nil {
+			return dataKey, nil
+		}
+		failures = append(failures, fmt.Sprintf("KMS key %s: %v", key.ARN, err))
+	}
+	if len(m.Age) > 0 {
+		identities, err := ageIdentities()
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+		for _, key := range m.Age {
+			if len(identities) == 0 {
+				break
+			}
+			dataKey, err := ageDecrypt(key.Enc, identities)
+			if err == nil {
+				return dataKey, nil
+			}
+			failures = append(failures, fmt.Sprintf("age recipient %s: %v", key.Recipient, err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil, errors.New("the sops file has no AWS KMS or age key to decrypt it with")
+	}
+	return nil, fmt.Errorf("cannot decrypt the data key of the sops file: %s", strings.Join(failures, "; "))
+}
+
+func (k sopsKMSKey) decrypt() ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(k.Enc)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kmsFor(k.ARN, k.Role)
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    blob,
+		EncryptionContext: k.Context,
+		KeyId:             aws.String(k.ARN),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+// ageIdentities reads the age identities of the user from where sops reads them: the SOPS_AGE_KEY environment
+// variable, the file named by SOPS_AGE_KEY_FILE, or sops/age/keys.txt in the user's config directory.
+func ageIdentities() ([]age.Identity, error) {
+	if keys := os.Getenv("SOPS_AGE_KEY"); keys != "" {
+		return age.ParseIdentities(strings.NewReader(keys))
+	}
+	filename := os.Getenv("SOPS_AGE_KEY_FILE")
+	if filename == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot find the age keys; set SOPS_AGE_KEY or SOPS_AGE_KEY_FILE")
+		}
+		filename = filepath.Join(configDir, "sops", "age", "keys.txt")
+	}
+	keys, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the age keys; set SOPS_AGE_KEY or SOPS_AGE_KEY_FILE")
+	}
+	defer keys.Close()
+	return age.ParseIdentities(keys)
+}
+
+func ageDecrypt(enc string, identities []age.Identity) ([]byte, error) {
+	decrypted, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(decrypted)
+}
+
+func ageEncrypt(recipient string, dataKey []byte) (string, error) {
+	parsed, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", err
+	}
+	var enc bytes.Buffer
+	armored := armor.NewWriter(&enc)
+	encrypted, err := age.Encrypt(armored, parsed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := encrypted.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err := encrypted.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return enc.String(), nil
+}
+
+// sopsRules decides which values sops encrypts, by the keys of their path.
+type sopsRules struct {
+	unencryptedSuffix string
+	encryptedSuffix   string
+	unencryptedRegex  *regexp.Regexp
+	encryptedRegex    *regexp.Regexp
+}
+
+func (m sopsMetadata) rules() (*sopsRules, error) {
+	rules := &sopsRules{unencryptedSuffix: m.UnencryptedSuffix, encryptedSuffix: m.EncryptedSuffix}
+	var err error
+	if m.UnencryptedRegex != "" {
+		if rules.unencryptedRegex, err = regexp.Compile(m.UnencryptedRegex); err != nil {
+			return nil, errors.Wrap(err, "invalid unencrypted_regex")
+		}
+	}
+	if m.EncryptedRegex != "" {
+		if rules.encryptedRegex, err = regexp.Compile(m.EncryptedRegex); err != nil {
+			return nil, errors.Wrap(err, "invalid encrypted_regex")
+		}
+	}
+	return rules, nil
+}
+
+// encrypted returns whether the value at the given path is encrypted, applying the rules in the order sops does.
+func (r *sopsRules) encrypted(path []string) bool {
+	anyKey := func(match func(key string) bool) bool {
+		for _, key := range path {
+			if match(key) {
+				return true
+			}
+		}
+		return false
+	}
+	encrypted := true
+	if r.unencryptedSuffix != "" && anyKey(func(key string) bool { return strings.HasSuffix(key, r.unencryptedSuffix) }) {
+		encrypted = false
+	}
+	if r.encryptedSuffix != "" {
+		encrypted = anyKey(func(key string) bool { return strings.HasSuffix(key, r.encryptedSuffix) })
+	}
+	if r.unencryptedRegex != nil && anyKey(r.unencryptedRegex.MatchString) {
+		encrypted = false
+	}
+	if r.encryptedRegex != nil {
+		encrypted = anyKey(r.encryptedRegex.MatchString)
+	}
+	return encrypted
+}
+
+// sopsWalk replaces every leaf value of the tree by the result of leaf, called with the keys of its path.
+// Like in sops, the items of lists do not add to the path.
+func sopsWalk(tree yaml.MapSlice, leaf func(value interface{}, path []string) (interface{}, error)) error {
+	var walk func(value interface{}, path []string) (interface{}, error)
+	walk = func(value interface{}, path []string) (interface{}, error) {
+		switch v := value.(type) {
+		case yaml.MapSlice:
+			for i, item := range v {
+				// Like sops, only string keys are supported; YAML keys like on or yes are booleans unless quoted
+				key, ok := item.Key.(string)
+				if !ok {
+					return nil, fmt.Errorf("key %v is not a string; quote it", item.Key)
+				}
+				walked, err := walk(item.Value, append(path[:len(path):len(path)], key))
+				if err != nil {
+					return nil, err
+				}
+				v[i].Value = walked
+			}
+			return v, nil
+		case []interface{}:
+			for i, item := range v {
+				walked, err := walk(item, path)
+				if err != nil {
+					return nil, err
+				}
+				v[i] = walked
+			}
+			return v, nil
+		default:
+			return leaf(value, path)
+		}
+	}
+	_, err := walk(tree, nil)
+	return err
+}
+
+// sopsPath returns the additional data authenticated with the value at the given path.
+func sopsPath(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// sopsBytes returns the bytes of a value that sops adds to the MAC.
+func sopsBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case bool:
+		// sops capitalizes booleans in the MAC
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case nil:
+		return nil
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+func sopsEncryptValue(value interface{}, dataKey []byte, additionalData string) (interface{}, error) {
+	var plaintext, valueType string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		// Empty strings are not encrypted
+		if v == "" {
+			return "", nil
+		}
+		plaintext, valueType = v, "str"
+	case bool:
+		plaintext, valueType = strconv.FormatBool(v), "bool"
+	case float64:
+		plaintext, valueType = strconv.FormatFloat(v, 'f', -1, 64), "float"
+	case int, int64, uint64:
+		plaintext, valueType = fmt.Sprint(v), "int"
+	default:
+		return nil, fmt.Errorf("cannot encrypt a value of type %T", value)
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey, sopsNonceSize)
+	if err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag), valueType), nil
+}
+
+func sopsDecryptValue(value string, dataKey []byte, additionalData string) (interface{}, error) {
+	if value == "" {
+		return "", nil
+	}
+	match := sopsValue.FindStringSubmatch(value)
+	if match == nil {
+		return nil, errors.New("the value is not encrypted by sops")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(match[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	gcm, err := newGCM(dataKey, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+	switch match[4] {
+	case "str", "bytes", "comment":
+		return string(plaintext), nil
+	case "int":
+		return strconv.Atoi(string(plaintext))
+	case "float":
+		return strconv.ParseFloat(string(plaintext), 64)
+	case "bool":
+		return strconv.ParseBool(string(plaintext))
+	default:
+		return nil, fmt.Errorf("unknown type %#v", match[4])
+	}
+}
+
+// setItem sets the value of the given key of the map, adding it at the end if not there.
+func setItem(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// marshalSops marshals the tree in the format of the given original file, JSON or YAML, keeping the order of its keys.
+func marshalSops(original []byte, tree yaml.MapSlice) ([]byte, error) {
+	if !isJSON(original) {
+		return yaml.Marshal(tree)
+	}
+	var compact bytes.Buffer
+	if err := writeJSON(&compact, tree); err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, compact.Bytes(), "", "\t"); err != nil {
+		return nil, err
+	}
+	indented.WriteString("\n")
+	return indented.Bytes(), nil
+}
+
+// writeJSON writes a value parsed from YAML as JSON, keeping the order of the keys of maps.
+func writeJSON(w *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		w.WriteString("{")
+		for i, item := range v {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			key, err := json.Marshal(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			w.Write(key)
+			w.WriteString(":")
+			if err := writeJSON(w, item.Value); err != nil {
+				return err
+			}
+		}
+		w.WriteString("}")
+	case []interface{}:
+		w.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			if err := writeJSON(w, item); err != nil {
+				return err
+			}
+		}
+		w.WriteString("]")
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.Write(encoded)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chanzuckerberg/czecs/pkg/kmsfake"
+	"github.com/ghodss/yaml"
+)
+
+// The keys of the files in testdata; see testdata/README.md.
+const (
+	testKMSKey = "arn:aws:kms:us-west-2:123456789012:alias/czecs-test"
+	testAgeKey = "age148m7ptmwqghj2cw7xv3hl0xmewagerxur83yajeczcy7pvuw4afqzjvgw8"
+)
+
+// setTestKeys makes the KMS key and the age key of the test files available.
+func setTestKeys(t *testing.T) {
+	SetKMS(kmsfake.New(testKMSKey))
+	t.Cleanup(func() { SetKMS(nil) })
+	t.Setenv("SOPS_AGE_KEY", string(readTestFile(t, "age.key")))
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// checkValues checks that the JSON or YAML document has the same values as the want file.
+func checkValues(t *testing.T, got []byte, want string) {
+	t.Helper()
+	values := func(content []byte) interface{} {
+		document, err := yaml.YAMLToJSON(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(document, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if !reflect.DeepEqual(values(got), values(readTestFile(t, want))) {
+		t.Errorf("got values\n%s\nwant those of %s", got, want)
+	}
+}
+
+func TestDecryptSops(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "kms.sops.json", want: "balances.json"},
+		{file: "age.sops.yaml", want: "balances.yaml"},
+		{file: "encrypted_regex.sops.json", want: "balances.json"},
+		{file: "mac_only_encrypted.sops.yaml", want: "balances.yaml"},
+		// Comments are not authenticated, and are dropped
+		{file: "comments.sops.yaml", want: "comments.yaml"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			setTestKeys(t)
+			encrypted := readTestFile(t, test.file)
+			if format := EncryptedFormat(encrypted); format != FormatSops {
+				t.Fatalf("EncryptedFormat = %#v", format)
+			}
+			decrypted, err := DecryptBalances(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isJSON(decrypted) != isJSON(encrypted) {
+				t.Errorf("decrypted to another format:\n%s", decrypted)
+			}
+			checkValues(t, decrypted, test.want)
+		})
+	}
+}
+
+func TestDecryptSopsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		// edit changes the content of the file
+		edit    func(content string) string
+		noKeys  bool
+		wantErr string
+	}{
+		{
+			name:    "tampered value",
+			file:    "kms.sops.json",
+			edit:    func(content string) string { return strings.Replace(content, `"us-west-2"`, `"us-east-1"`, 1) },
+			wantErr: "MAC mismatch",
+		},
+		{
+			name:    "tampered value with mac_only_encrypted",
+			file:    "mac_only_encrypted.sops.yaml",
+			edit:    func(content string) string { return strings.Replace(content, "    host: ENC", "    hostname: ENC", 1) },
+			wantErr: "cannot decrypt the value of database.hostname",
+		},
+		{
+			name:    "removed value",
+			file:    "age.sops.yaml",
+			edit:    func(content string) string { return strings.Replace(content, "region_unencrypted: us-west-2\n", "", 1) },
+			wantErr: "MAC mismatch",
+		},
+		{
+			name: "key groups",
+			file: "age.sops.yaml",
+			edit: func(content string) string {
+				return strings.Replace(content, "sops:\n", "sops:\n    shamir_threshold: 2\n    key_groups:\n        - kms: []\n", 1)
+			},
+			wantErr: "sops files with key groups are not supported",
+		},
+		{
+			name:    "no key",
+			file:    "age.sops.yaml",
+			noKeys:  true,
+			wantErr: "cannot decrypt the data key",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestKeys(t)
+			if test.noKeys {
+				t.Setenv("SOPS_AGE_KEY", "")
+				t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(t.TempDir(), "keys.txt"))
+			}
+			content := string(readTestFile(t, test.file))
+			if test.edit != nil {
+				edited := test.edit(content)
+				if edited == content {
+					t.Fatal("the edit did not change the file")
+				}
+				content = edited
+			}
+			_, err := DecryptBalances([]byte(content))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDecryptSopsMACOnlyEncrypted(t *testing.T) {
+	setTestKeys(t)
+	// Values left in plaintext are not authenticated with mac_only_encrypted
+	content := strings.Replace(string(readTestFile(t, "mac_only_encrypted.sops.yaml")), "tag: v1", "tag: v2", 1)
+	decrypted, err := DecryptBalances([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(decrypted, []byte("tag: v2")) {
+		t.Errorf("decrypted:\n%s", decrypted)
+	}
+}
+
+func TestEncryptSops(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		opts EncryptOptions
+	}{
+		{name: "JSON with KMS", file: "balances.json", opts: EncryptOptions{KMSKeys: []string{testKMSKey}}},
+		{name: "YAML with age", file: "balances.yaml", opts: EncryptOptions{AgeRecipients: []string{testAgeKey}}},
+		{name: "KMS and age", file: "balances.json", opts: EncryptOptions{KMSKeys: []string{testKMSKey}, AgeRecipients: []string{testAgeKey}}},
+		{name: "unencrypted suffix", file: "balances.yaml", opts: EncryptOptions{AgeRecipients: []string{testAgeKey}, UnencryptedSuffix: "_plain"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestKeys(t)
+			plaintext := readTestFile(t, test.file)
+			encrypted, err := EncryptBalances(plaintext, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := EncryptedFormat(encrypted); format != FormatSops {
+				t.Fatalf("EncryptedFormat = %#v", format)
+			}
+			if isJSON(encrypted) != isJSON(plaintext) {
+				t.Errorf("encrypted to another format:\n%s", encrypted)
+			}
+			if bytes.Contains(encrypted, []byte("hunter2")) {
+				t.Errorf("the password is not encrypted:\n%s", encrypted)
+			}
+			unencrypted := test.opts.UnencryptedSuffix == ""
+			if bytes.Contains(encrypted, []byte("us-west-2")) != unencrypted {
+				t.Errorf("region_unencrypted is left in plaintext: %v, want %v", !unencrypted, unencrypted)
+			}
+
+			decrypted, err := DecryptBalances(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkValues(t, decrypted, test.file)
+		})
+	}
+}
+
+func TestReencryptBalances(t *testing.T) {
+	tests := []struct {
+		file string
+		// wantPlaintext is kept in plaintext after re-encrypting
+		wantPlaintext string
+	}{
+		{file: "kms.sops.json", wantPlaintext: `"region_unencrypted": "us-west-2"`},
+		{file: "age.sops.yaml", wantPlaintext: "region_unencrypted: us-west-2"},
+		{file: "encrypted_regex.sops.json", wantPlaintext: `"image": "example/web"`},
+		{file: "mac_only_encrypted.sops.yaml", wantPlaintext: "image: example/web"},
+		{file: "envelope.json"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			setTestKeys(t)
+			encrypted := readTestFile(t, test.file)
+			decrypted, err := DecryptBalances(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			edited := bytes.Replace(decrypted, []byte("hunter2"), []byte("hunter3"), 1)
+			if bytes.Equal(edited, decrypted) {
+				t.Fatalf("the password is not in the decrypted file:\n%s", decrypted)
+			}
+
+			reencrypted, err := ReencryptBalances(encrypted, edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format, want := EncryptedFormat(reencrypted), EncryptedFormat(encrypted); format != want {
+				t.Errorf("EncryptedFormat = %#v, want %#v", format, want)
+			}
+			if bytes.Contains(reencrypted, []byte("hunter3")) {
+				t.Errorf("the password is not encrypted:\n%s", reencrypted)
+			}
+			if !bytes.Contains(reencrypted, []byte(test.wantPlaintext)) {
+				t.Errorf("expected %s to be kept in plaintext:\n%s", test.wantPlaintext, reencrypted)
+			}
+			// Re-encrypted for the same keys, with the same rules
+			if test.file != "envelope.json" {
+				original, err := parseSops(encrypted)
+				if err != nil {
+					t.Fatal(err)
+				}
+				file, err := parseSops(reencrypted)
+				if err != nil {
+					t.Fatal(err)
+				}
+				original.metadata.LastModified, original.metadata.MAC = file.metadata.LastModified, file.metadata.MAC
+				if !reflect.DeepEqual(file.metadata, original.metadata) {
+					t.Errorf("metadata = %#v, want %#v", file.metadata, original.metadata)
+				}
+			}
+
+			redecrypted, err := DecryptBalances(reencrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(redecrypted, edited) {
+				t.Errorf("decrypted\n%s\nwant\n%s", redecrypted, edited)
+			}
+		})
+	}
+}
+
+func TestSopsComments(t *testing.T) {
+	setTestKeys(t)
+	_, err := EncryptBalances(readTestFile(t, "comments.yaml"), EncryptOptions{AgeRecipients: []string{testAgeKey}})
+	if err == nil || !strings.Contains(err.Error(), "comments") {
+		t.Errorf("expected encrypting a YAML file with comments to fail, got %v", err)
+	}
+
+	encrypted := readTestFile(t, "comments.sops.yaml")
+	decrypted, err := DecryptBalances(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReencryptBalances(encrypted, decrypted)
+	if err == nil || !strings.Contains(err.Error(), "comments") {
+		t.Errorf("expected re-encrypting a sops file with comments to fail, got %v", err)
+	}
+}
+
+func TestYAMLHasComments(t *testing.T) {
+	tests := map[string]bool{
+		"a: 1\n":                                            false,
+		"# comment\na: 1\n":                                 true,
+		"a: 1 # comment\n":                                  true,
+		"a:\n  # comment\n  b: 1\n":                         true,
+		"a: 'not # a comment'\n":                            false,
+		`a: "not # a comment"` + "\n":                       false,
+		`a: "escaped \" # quote"` + "\n":                    false,
+		"a: 'it''s' # comment\n":                            true,
+		"a: b#c\n":                                          false,
+		"a: |\n  # not a comment\nb: 1\n":                   false,
+		"a: >-\n  x\n\n  # not a comment\nb: 1 # comment\n": true,
+		"- |\n  # not a comment\n- b\n":                     false,
+		`{"a": "#"}`:                                        false,
+	}
+	for content, want := range tests {
+		if got := yamlHasComments([]byte(content)); got != want {
+			t.Errorf("yamlHasComments(%#v) = %v, want %v", content, got, want)
+		}
+	}
+}
@@ -3,69 +3,124 @@ package tasks
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"path"
+	"path/filepath"
 	"strings"
 	"text/template"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 )
 
-// ReadFileOrURI reads a file either from local disk or from the given URI.
-// Auto detect whether the given string is a URI. Supported URI schemes are s3, http, or https.
-func ReadFileOrURI(fileOrURI string) ([]byte, error) {
-	url, err := url.ParseRequestURI(fileOrURI)
+// awsSession is the session used to read S3 objects
+var awsSession *session.Session
+
+// SetSession sets the AWS session used to read files from S3 and to encrypt balances files with KMS. If not
+// set, a session is created from the AWS environment variables and shared config.
+func SetSession(sess *session.Session) {
+	awsSession = sess
+}
+
+// getSession returns the session set by SetSession, or a session created from the AWS environment variables
+// and shared config.
+func getSession() (*session.Session, error) {
+	if awsSession != nil {
+		return awsSession, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
-		return ioutil.ReadFile(fileOrURI)
+		return nil, errors.Wrap(err, "Could not create session")
+	}
+	return sess, nil
+}
+
+// IsURI returns whether the given string is a URI, rather than a path to a local file.
+func IsURI(fileOrURI string) bool {
+	if IsGitSource(fileOrURI) {
+		return true
 	}
-	switch url.Scheme {
-	case "s3":
-		sess, err := session.NewSession(&aws.Config{})
+	url, err := url.ParseRequestURI(fileOrURI)
+	return err == nil && url.Scheme != ""
+}
+
+// ResolveRelative returns the file or URI of the relative path ref, relative to the directory of the file or
+// URI base, e.g. s3://bucket/sidecars/proxy.json for s3://bucket/web.json and sidecars/proxy.json. The query
+// and fragment of a URI, such as its version or digest, only apply to base and are left out.
+func ResolveRelative(base string, ref string) (string, error) {
+	switch {
+	case IsGitSource(base):
+		source, err := parseGitSource(base)
 		if err != nil {
-			return nil, errors.Wrap(err, "Could not create session")
+			return "", err
 		}
-		svc := s3.New(sess)
-		result, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: &url.Host, Key: &url.Path})
+		source.path = path.Join(path.Dir(source.path), filepath.ToSlash(ref))
+		return source.String(), nil
+	case IsURI(base):
+		baseURL, err := url.Parse(base)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Could not retrieve S3 object %v", fileOrURI)
+			return "", errors.Wrapf(err, "invalid URI %v", base)
 		}
-		defer result.Body.Close()
-		return ioutil.ReadAll(result.Body)
-	case "http", "https":
-		resp, err := http.Get(fileOrURI)
+		refURL, err := url.Parse(filepath.ToSlash(ref))
 		if err != nil {
-			return nil, errors.Wrapf(err, "Could not retrieve %v", fileOrURI)
+			return "", errors.Wrapf(err, "invalid path %v", ref)
 		}
-		defer resp.Body.Close()
-		return ioutil.ReadAll(resp.Body)
+		return baseURL.ResolveReference(refURL).String(), nil
 	}
-	return nil, fmt.Errorf("Unexpected url scheme %v in URI %v", url.Scheme, fileOrURI)
+	return filepath.Join(filepath.Dir(base), ref), nil
 }
 
-func processTemplate(defnFilename string, values map[string]interface{}, strict bool) (string, error) {
+// ReadFileOrURI reads a file either from local disk or from the given URI.
+// Auto detect whether the given string is a URI. Supported URI schemes are s3, http, or https; see
+// FetchOptions for how they are read. A URI may end with #sha256=<hex digest> to verify its content, and
+// an s3 URI may select an object version with ?versionId=<version>. Files can also be read from git
+// repositories; see GitPrefix.
+func ReadFileOrURI(fileOrURI string) ([]byte, error) {
+	if IsGitSource(fileOrURI) {
+		file, err := Resolve(fileOrURI)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(file)
+	}
+	if !IsURI(fileOrURI) {
+		return ioutil.ReadFile(fileOrURI)
+	}
+	url, err := url.Parse(fileOrURI)
+	if err != nil {
+		return nil, err
+	}
+	return readURI(url)
+}
+
+func processTemplate(defnFilename string, values map[string]interface{}, strict bool, partials []string) (string, error) {
+	// Templates from git are read from a checkout, so that the partials next to them are found
+	defnFilename, err := Resolve(defnFilename)
+	if err != nil {
+		return "", err
+	}
 	rawDefn, err := ReadFileOrURI(defnFilename)
 	if err != nil {
 		return "", errors.Wrapf(err, "Error reading task definition from %v", defnFilename)
 	}
 	templateOption := "missingkey=zero"
 	if strict {
-		// TODO(mbarrien): missingkey=error is not actually returning an error if a value is missing,
-		// although it is ending the render early
 		templateOption = "missingkey=error"
 	}
-	tmpl, err := template.New(defnFilename).Option(templateOption).Parse(string(rawDefn))
-	if err != nil {
+	tmpl := template.New(defnFilename).Option(templateOption)
+	tmpl.Funcs(templateFuncs(tmpl))
+	if err := parsePartials(tmpl, defnFilename, partials); err != nil {
+		return "", err
+	}
+	if _, err := tmpl.Parse(string(rawDefn)); err != nil {
 		return "", errors.Wrap(err, "Error parsing task definition template")
 	}
 	var defn bytes.Buffer
-	if tmpl.Execute(&defn, values); err != nil {
+	if err := tmpl.Execute(&defn, values); err != nil {
 		return "", errors.Wrap(err, "Error executing task definition template")
 	}
 	// missingkey=zero doesn't work completely properly on map[string]interface{}
@@ -77,19 +132,12 @@ func processTemplate(defnFilename string, values map[string]interface{}, strict
 
 // ParseTaskDefinition parses an ECS task definition from a file, using the given values to fill in template variables.
 // Optionally, in strict mode fail with error if a template variable makes a reference to a value
-// that has not been provided.
-func ParseTaskDefinition(defnFilename string, values map[string]interface{}, strict bool) (*ecs.RegisterTaskDefinitionInput, error) {
-	filteredDefn, err := processTemplate(defnFilename, values, strict)
-	if err != nil {
-		return nil, err
-	}
-
-	decoder := json.NewDecoder(strings.NewReader(filteredDefn))
-	decoder.DisallowUnknownFields()
-
+// that has not been provided. The template can include the named templates defined in the _*.tpl files
+// next to it, and in the given partials (see parsePartials).
+func ParseTaskDefinition(defnFilename string, values map[string]interface{}, strict bool, partials []string) (*ecs.RegisterTaskDefinitionInput, error) {
 	var taskDefn ecs.RegisterTaskDefinitionInput
-	if err = decoder.Decode(&taskDefn); err != nil {
-		return nil, errors.Wrap(err, "Error parsing JSON of task definition")
+	if err := parseTemplate(defnFilename, "task definition", values, strict, partials, &taskDefn); err != nil {
+		return nil, err
 	}
 	return &taskDefn, nil
 }
@@ -97,31 +145,48 @@ func ParseTaskDefinition(defnFilename string, values map[string]interface{}, str
 // ParseTask parses an ECS task from a file, using the given values to fill in template variables.
 // Optionally, in strict mode fail with error if a template variable makes a reference to a value
 // that has not been provided.
-func ParseTask(taskFilename string, values map[string]interface{}, strict bool) (*ecs.RunTaskInput, error) {
-	filteredTask, err := processTemplate(taskFilename, values, strict)
-	if err != nil {
-		return nil, err
-	}
-
-	decoder := json.NewDecoder(strings.NewReader(filteredTask))
-	decoder.DisallowUnknownFields()
-
+func ParseTask(taskFilename string, values map[string]interface{}, strict bool, partials []string) (*ecs.RunTaskInput, error) {
 	var runTaskInput ecs.RunTaskInput
-	if err = decoder.Decode(&runTaskInput); err != nil {
-		return nil, errors.Wrap(err, "Error parsing JSON of task")
+	if err := parseTemplate(taskFilename, "task", values, strict, partials, &runTaskInput); err != nil {
+		return nil, err
 	}
 	return &runTaskInput, nil
 }
 
 // ParseBalances reads an arbitrary JSON file for use as values to use to replace template variable placeholders.
+// Encrypted balances files, in the sops format (JSON or YAML) or the czecs envelope format, are decrypted in
+// memory; see EncryptBalances.
 func ParseBalances(balancesFilename string) (map[string]interface{}, error) {
 	rawBalances, err := ReadFileOrURI(balancesFilename)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Error reading balances file %v", balancesFilename)
 	}
+	if rawBalances, err = balancesJSON(rawBalances); err != nil {
+		return nil, errors.Wrapf(err, "Error decrypting balances file %v", balancesFilename)
+	}
 	var balances map[string]interface{}
 	if err = json.Unmarshal(rawBalances, &balances); err != nil {
 		return nil, errors.Wrap(err, "Error parsing JSON of balances file")
 	}
 	return balances, nil
 }
+
+// ParseTemplate parses any JSON template from a file into v like ParseTaskDefinition, failing on fields unknown to v.
+func ParseTemplate(filename string, values map[string]interface{}, strict bool, partials []string, v interface{}) error {
+	return parseTemplate(filename, filename, values, strict, partials, v)
+}
+
+// parseTemplate parses a JSON template into v; what names the template in errors.
+func parseTemplate(filename string, what string, values map[string]interface{}, strict bool, partials []string, v interface{}) error {
+	filtered, err := processTemplate(filename, values, strict, partials)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(filtered))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(v); err != nil {
+		return errors.Wrapf(err, "Error parsing JSON of %v", what)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package tasks
+
+import "testing"
+
+func TestResolveRelative(t *testing.T) {
+	tests := []struct {
+		base string
+		ref  string
+		want string
+	}{
+		{base: "web.json", ref: "sidecars/proxy.json", want: "sidecars/proxy.json"},
+		{base: "deploy/web.json", ref: "sidecars/proxy.json", want: "deploy/sidecars/proxy.json"},
+		{base: "s3://bucket/web.json", ref: "sidecars/proxy.json", want: "s3://bucket/sidecars/proxy.json"},
+		{base: "s3://bucket/deploy/web.json?versionId=3", ref: "sidecars/proxy.json", want: "s3://bucket/deploy/sidecars/proxy.json"},
+		{base: "https://example.com/web.json#sha256=abc", ref: "../proxy.json", want: "https://example.com/proxy.json"},
+		{
+			base: "git::https://github.com/example/infra.git//ecs/web.json?ref=v1.2.0",
+			ref:  "sidecars/proxy.json",
+			want: "git::https://github.com/example/infra.git//ecs/sidecars/proxy.json?ref=v1.2.0",
+		},
+		{
+			base: "git::file:///src/infra//web.json",
+			ref:  "sidecars/proxy.json",
+			want: "git::file:///src/infra//sidecars/proxy.json",
+		},
+	}
+	for _, test := range tests {
+		got, err := ResolveRelative(test.base, test.ref)
+		if err != nil {
+			t.Errorf("ResolveRelative(%#v, %#v): %s", test.base, test.ref, err)
+		} else if got != test.want {
+			t.Errorf("ResolveRelative(%#v, %#v) = %#v, want %#v", test.base, test.ref, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+)
+
+// MarshalAPIJSON marshals an AWS SDK input or output struct to JSON the way the AWS API expects it,
+// i.e. using the field names of the API (e.g. "containerDefinitions") and leaving out unset fields.
+func MarshalAPIJSON(v interface{}) (json.RawMessage, error) {
+	return jsonutil.BuildJSON(v)
+}
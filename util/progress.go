@@ -0,0 +1,172 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// progressMutex serializes writes of all progress renderers
+var progressMutex sync.Mutex
+
+// DeploymentProgress renders the progress of an ECS service deployment each time a DescribeServices
+// waiter polls: service events since the deployment started, and the task counts and rollout state
+// of every deployment of the service.
+//
+// On a terminal the deployment status is a compact block that is redrawn in place on every poll.
+// Otherwise (e.g. CI logs) only new events and status lines that changed since the last poll are printed.
+type DeploymentProgress struct {
+	out    io.Writer
+	since  time.Time
+	tty    bool
+	prefix string
+
+	seenEvents map[string]bool
+	lastStatus string
+	drawnLines int
+}
+
+// NewDeploymentProgress creates a progress renderer writing to out, reporting service events created
+// at or after since. Terminal mode is used if out is a terminal.
+func NewDeploymentProgress(out io.Writer, since time.Time) *DeploymentProgress {
+	return &DeploymentProgress{
+		out:        out,
+		since:      since,
+		tty:        IsTerminal(out),
+		seenEvents: map[string]bool{},
+	}
+}
+
+// WithPrefix prefixes every line written with the given prefix and disables terminal mode, so that the
+// progress of several concurrent deployments can be written to the same output.
+func (p *DeploymentProgress) WithPrefix(prefix string) *DeploymentProgress {
+	p.prefix = prefix
+	p.tty = false
+	return p
+}
+
+// WaiterOption hooks the progress renderer into a waiter polling DescribeServices, such as
+// WaitUntilServicesStableWithContext.
+func (p *DeploymentProgress) WaiterOption(waiter *request.Waiter) {
+	oldNewRequest := waiter.NewRequest
+	waiter.NewRequest = func(opts []request.Option) (*request.Request, error) {
+		req, err := oldNewRequest(opts)
+		if err != nil {
+			return req, err
+		}
+		req.Handlers.Complete.PushBack(func(r *request.Request) {
+			if output, ok := r.Data.(*ecs.DescribeServicesOutput); ok && (r.Error == nil || isDeploymentCheckError(r.Error)) {
+				p.Update(output)
+			}
+		})
+		return req, nil
+	}
+}
+
+// Update renders the given DescribeServices response.
+func (p *DeploymentProgress) Update(output *ecs.DescribeServicesOutput) {
+	events := p.newEvents(output.Services)
+	status := deploymentStatus(output.Services)
+
+	// Write everything at once, so concurrent deployments do not interleave their lines
+	var b strings.Builder
+	if p.tty && p.drawnLines > 0 {
+		// Move the cursor to the start of the previously drawn status block and clear to end of screen
+		fmt.Fprintf(&b, "\033[%dA\033[J", p.drawnLines)
+	}
+	for _, event := range events {
+		fmt.Fprintf(&b, "%s%s\n", p.prefix, event)
+	}
+	if p.tty || status != p.lastStatus {
+		for _, line := range strings.SplitAfter(status, "\n") {
+			if line != "" {
+				fmt.Fprintf(&b, "%s%s", p.prefix, line)
+			}
+		}
+		p.lastStatus = status
+		p.drawnLines = strings.Count(status, "\n")
+	}
+
+	progressMutex.Lock()
+	defer progressMutex.Unlock()
+	fmt.Fprint(p.out, b.String())
+}
+
+// newEvents returns the formatted service events not yet printed, oldest first.
+func (p *DeploymentProgress) newEvents(services []*ecs.Service) []string {
+	var events []*ecs.ServiceEvent
+	for _, service := range services {
+		for _, event := range service.Events {
+			if event.Id == nil || p.seenEvents[*event.Id] {
+				continue
+			}
+			if event.CreatedAt != nil && event.CreatedAt.Before(p.since) {
+				continue
+			}
+			p.seenEvents[*event.Id] = true
+			events = append(events, event)
+		}
+	}
+	// ECS returns events newest first
+	sort.SliceStable(events, func(i, j int) bool {
+		return aws.TimeValue(events[i].CreatedAt).Before(aws.TimeValue(events[j].CreatedAt))
+	})
+
+	lines := make([]string, len(events))
+	for i, event := range events {
+		lines[i] = fmt.Sprintf("%s %s", aws.TimeValue(event.CreatedAt).Local().Format("15:04:05"), aws.StringValue(event.Message))
+	}
+	return lines
+}
+
+// deploymentStatus formats the task counts and rollout state of all deployments of the given services,
+// one line per service followed by one line per deployment.
+func deploymentStatus(services []*ecs.Service) string {
+	var b strings.Builder
+	for _, service := range services {
+		fmt.Fprintf(&b, "%s: desired %d, pending %d, running %d\n",
+			aws.StringValue(service.ServiceName),
+			aws.Int64Value(service.DesiredCount),
+			aws.Int64Value(service.PendingCount),
+			aws.Int64Value(service.RunningCount))
+		for _, deployment := range service.Deployments {
+			rolloutState := aws.StringValue(deployment.RolloutState)
+			if rolloutState == "" {
+				rolloutState = "-"
+			}
+			fmt.Fprintf(&b, "  %-7s %s desired %d, pending %d, running %d, failed %d, rollout %s\n",
+				aws.StringValue(deployment.Status),
+				TaskDefinitionName(aws.StringValue(deployment.TaskDefinition)),
+				aws.Int64Value(deployment.DesiredCount),
+				aws.Int64Value(deployment.PendingCount),
+				aws.Int64Value(deployment.RunningCount),
+				aws.Int64Value(deployment.FailedTasks),
+				rolloutState)
+		}
+	}
+	return b.String()
+}
+
+// TaskDefinitionName returns the family:revision part of a task definition ARN.
+func TaskDefinitionName(taskDefinitionArn string) string {
+	parts := strings.Split(taskDefinitionArn, "/")
+	return parts[len(parts)-1]
+}
+
+// IsTerminal returns whether the given writer is a terminal (character device).
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
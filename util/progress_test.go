@@ -0,0 +1,125 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+var progressSince = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// progressOutput returns a DescribeServices response of the web service with the given running count, with
+// the given events (by ID), created the given number of seconds after progressSince and listed newest first.
+func progressOutput(running int64, events map[string]int) *ecs.DescribeServicesOutput {
+	service := &ecs.Service{
+		ServiceName:  aws.String("web"),
+		DesiredCount: aws.Int64(2),
+		PendingCount: aws.Int64(2 - running),
+		RunningCount: aws.Int64(running),
+		Deployments: []*ecs.Deployment{{
+			Status:         aws.String("PRIMARY"),
+			TaskDefinition: aws.String("arn:aws:ecs:us-west-2:123456789012:task-definition/web:2"),
+			DesiredCount:   aws.Int64(2),
+			PendingCount:   aws.Int64(2 - running),
+			RunningCount:   aws.Int64(running),
+			RolloutState:   aws.String(ecs.DeploymentRolloutStateInProgress),
+		}},
+	}
+	for id, seconds := range events {
+		service.Events = append(service.Events, &ecs.ServiceEvent{
+			Id:        aws.String(id),
+			CreatedAt: aws.Time(progressSince.Add(time.Duration(seconds) * time.Second)),
+			Message:   aws.String("(service web) " + id),
+		})
+	}
+	// Newest first, like ECS
+	sort.Slice(service.Events, func(i, j int) bool {
+		return service.Events[i].CreatedAt.After(*service.Events[j].CreatedAt)
+	})
+	return &ecs.DescribeServicesOutput{Services: []*ecs.Service{service}}
+}
+
+// eventLine is the line printed for an event created the given number of seconds after progressSince.
+func eventLine(seconds int, id string) string {
+	return fmt.Sprintf("%s (service web) %s\n", progressSince.Add(time.Duration(seconds)*time.Second).Local().Format("15:04:05"), id)
+}
+
+func statusLines(running int64) string {
+	return fmt.Sprintf("web: desired 2, pending %d, running %d\n  PRIMARY web:2 desired 2, pending %d, running %d, failed 0, rollout IN_PROGRESS\n",
+		2-running, running, 2-running, running)
+}
+
+func TestDeploymentProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := NewDeploymentProgress(&out, progressSince)
+	if p.tty {
+		t.Fatal("a buffer is not a terminal")
+	}
+
+	// Events of earlier deployments are left out, and the others are printed oldest first
+	p.Update(progressOutput(0, map[string]int{"old": -60, "started": 1, "registered": 2}))
+	want := eventLine(1, "started") + eventLine(2, "registered") + statusLines(0)
+	if got := out.String(); got != want {
+		t.Errorf("first update = %q, want %q", got, want)
+	}
+
+	// Events already printed are not printed again, nor is an unchanged status
+	out.Reset()
+	p.Update(progressOutput(0, map[string]int{"started": 1, "registered": 2, "placed": 3}))
+	if got, want := out.String(), eventLine(3, "placed"); got != want {
+		t.Errorf("update with unchanged status = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	p.Update(progressOutput(1, map[string]int{"started": 1, "registered": 2, "placed": 3}))
+	if got, want := out.String(), statusLines(1); got != want {
+		t.Errorf("update with changed status = %q, want %q", got, want)
+	}
+}
+
+func TestDeploymentProgressTerminal(t *testing.T) {
+	var out bytes.Buffer
+	p := NewDeploymentProgress(&out, progressSince)
+	p.tty = true
+
+	p.Update(progressOutput(0, map[string]int{"started": 1}))
+	want := eventLine(1, "started") + statusLines(0)
+	if got := out.String(); got != want {
+		t.Errorf("first update = %q, want %q", got, want)
+	}
+
+	// The status block is redrawn in place on every poll, even if unchanged, with new events above it
+	out.Reset()
+	p.Update(progressOutput(0, map[string]int{"started": 1, "placed": 3}))
+	want = "\033[2A\033[J" + eventLine(3, "placed") + statusLines(0)
+	if got := out.String(); got != want {
+		t.Errorf("second update = %q, want %q", got, want)
+	}
+}
+
+func TestDeploymentProgressPrefix(t *testing.T) {
+	var out bytes.Buffer
+	p := NewDeploymentProgress(&out, progressSince)
+	p.tty = true
+	// A prefix disables redrawing, which would clobber the lines of other deployments
+	p.WithPrefix("[us-west-2] ")
+	if p.tty {
+		t.Error("terminal mode not disabled by a prefix")
+	}
+
+	p.Update(progressOutput(0, map[string]int{"started": 1}))
+	p.Update(progressOutput(1, map[string]int{"started": 1}))
+	want := "[us-west-2] " + eventLine(1, "started") +
+		"[us-west-2] web: desired 2, pending 2, running 0\n" +
+		"[us-west-2]   PRIMARY web:2 desired 2, pending 2, running 0, failed 0, rollout IN_PROGRESS\n" +
+		"[us-west-2] web: desired 2, pending 1, running 1\n" +
+		"[us-west-2]   PRIMARY web:2 desired 2, pending 1, running 1, failed 0, rollout IN_PROGRESS\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -2,38 +2,168 @@ package util
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/cloudflare/cfssl/log"
 )
 
-// GetFailOnAbortContext ends a ECS DescribeTask Waiter loop early if it finds messages in the event log that indicate the operation already failed.
+const (
+	// DeploymentFailedErrorCode is the code of the error that ends a waiter using GetFailOnAbortContext or
+	// GetFailOnRolloutContext when the deployment is found to have failed.
+	DeploymentFailedErrorCode = "DeploymentFailed"
+	// deploymentCompletedCode is used internally to end a waiter successfully when the rollout state
+	// of the deployment is COMPLETED.
+	deploymentCompletedCode = "DeploymentCompleted"
+)
+
+// GetFailOnAbortContext ends a ECS DescribeServices Waiter loop early if it finds messages in the event log that indicate the operation already failed.
 func GetFailOnAbortContext(createdAt time.Time) request.WaiterOption {
-	// Instead of waiting until the end of the timeout period, we examine the events log, looking for
-	// a message which tells us the upgrade failed. So we need to filter out events that happened before
-	// createdAt, to avoid reacting to errors from previous upgrades
+	return deploymentCheck(func(service *ecs.Service) (bool, string) {
+		return failedEvent(service, createdAt)
+	})
+}
+
+// GetFailOnRolloutContext ends a ECS DescribeServices Waiter loop early once the deployment with the given ID
+// (or the PRIMARY deployment, if the ID is empty) has finished rolling out.
+//
+// The rollout state of the deployment is preferred: COMPLETED ends the wait successfully, and FAILED ends it
+// with an error. Failure is also detected once the deployment's failed task count exceeds maxFailedTasks (if
+// nonzero), or once the deployment with the given ID is gone, e.g. after a circuit breaker rollback. While
+// the deployment is IN_PROGRESS, which it stays when the circuit breaker is disabled, or when the API does not
+// report a rollout state (e.g. older API versions or non-ECS deployment controllers), the heuristic of
+// GetFailOnAbortContext is used as well.
+func GetFailOnRolloutContext(deploymentID string, createdAt time.Time, maxFailedTasks int) request.WaiterOption {
+	return deploymentCheck(func(service *ecs.Service) (bool, string) {
+		deployment := findDeployment(service, deploymentID)
+		if deployment == nil && deploymentID != "" {
+			return false, fmt.Sprintf("deployment %s is no longer active", deploymentID)
+		}
+		if deployment == nil {
+			return failedEvent(service, createdAt)
+		}
+		if maxFailedTasks > 0 && aws.Int64Value(deployment.FailedTasks) > int64(maxFailedTasks) {
+			return false, fmt.Sprintf("deployment %s has %d failed tasks", aws.StringValue(deployment.Id), aws.Int64Value(deployment.FailedTasks))
+		}
+		switch aws.StringValue(deployment.RolloutState) {
+		case ecs.DeploymentRolloutStateCompleted:
+			return true, ""
+		case ecs.DeploymentRolloutStateFailed:
+			return false, fmt.Sprintf("deployment %s failed: %s", aws.StringValue(deployment.Id), aws.StringValue(deployment.RolloutStateReason))
+		}
+		return failedEvent(service, createdAt)
+	})
+}
+
+// findDeployment returns the deployment of the service with the given ID, or the PRIMARY deployment if the ID is empty.
+func findDeployment(service *ecs.Service, deploymentID string) *ecs.Deployment {
+	for _, deployment := range service.Deployments {
+		if deploymentID == "" && aws.StringValue(deployment.Status) == "PRIMARY" {
+			return deployment
+		}
+		if deploymentID != "" && aws.StringValue(deployment.Id) == deploymentID {
+			return deployment
+		}
+	}
+	return nil
+}
+
+// failedEvent searches the event log of a service for a message which tells us the deployment failed.
+// Events that happened before createdAt are ignored, to avoid reacting to errors from previous deployments.
+func failedEvent(service *ecs.Service, createdAt time.Time) (bool, string) {
+	for _, event := range service.Events {
+		if event.CreatedAt == nil || event.CreatedAt.Before(createdAt) {
+			continue
+		}
+		if strings.Contains(aws.StringValue(event.Message), "unable") {
+			return false, aws.StringValue(event.Message)
+		}
+	}
+	return false, ""
+}
+
+// isDeploymentCheckError returns whether the error was set by the response handler of deploymentCheck,
+// meaning the response itself was received successfully.
+func isDeploymentCheckError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == DeploymentFailedErrorCode || aerr.Code() == deploymentCompletedCode)
+}
+
+// deploymentCheck examines every DescribeServices response of the waiter with the given check, which returns
+// whether the deployment completed, or a non-empty reason if it failed.
+//
+// Waiter acceptors can only match JMESPath expressions, which cannot compare the string and time fields of
+// the SDK response structs, so the check is done in a response handler instead. The handler reports its
+// result as an error, which is matched by the acceptors added here. They come before the acceptors of the
+// waiter, so that e.g. a rolled back service is not taken as stable.
+func deploymentCheck(check func(service *ecs.Service) (bool, string)) request.WaiterOption {
 	return func(waiter *request.Waiter) {
-		waiter.Acceptors = append(waiter.Acceptors, request.WaiterAcceptor{
-			State:    request.FailureWaiterState,
-			Matcher:  request.PathAnyWaiterMatch,
-			Argument: fmt.Sprintf("length(services[?events[?contains(message, 'unable') && updatedAt > %d]]) == `0`", createdAt.Unix()),
-			Expected: true,
-		})
+		oldNewRequest := waiter.NewRequest
+		waiter.NewRequest = func(opts []request.Option) (*request.Request, error) {
+			req, err := oldNewRequest(opts)
+			if err != nil {
+				return req, err
+			}
+			req.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+				output, ok := r.Data.(*ecs.DescribeServicesOutput)
+				if !ok || r.Error != nil {
+					return
+				}
+				completed := len(output.Services) > 0
+				for _, service := range output.Services {
+					serviceCompleted, reason := check(service)
+					if reason != "" {
+						r.Error = awserr.New(DeploymentFailedErrorCode, fmt.Sprintf("service %s: %s", aws.StringValue(service.ServiceName), reason), nil)
+						r.Retryable = aws.Bool(false)
+						return
+					}
+					completed = completed && serviceCompleted
+				}
+				if completed {
+					r.Error = awserr.New(deploymentCompletedCode, "deployment completed", nil)
+					r.Retryable = aws.Bool(false)
+				}
+			})
+			return req, nil
+		}
+		waiter.Acceptors = append([]request.WaiterAcceptor{
+			{
+				State:    request.FailureWaiterState,
+				Matcher:  request.ErrorWaiterMatch,
+				Expected: DeploymentFailedErrorCode,
+			},
+			{
+				State:    request.SuccessWaiterState,
+				Matcher:  request.ErrorWaiterMatch,
+				Expected: deploymentCompletedCode,
+			},
+		}, waiter.Acceptors...)
 	}
 }
 
 // SleepProgressWithContext prints something to the screen to show the waiter is still waiting.
 func SleepProgressWithContext(waiter *request.Waiter) {
-	// At the end of the wait loop, print a newline.
-	waiter.SleepWithContext = func(context aws.Context, duration time.Duration) error {
-		fmt.Printf(".")
-		result := aws.SleepWithContext(context, duration)
-		if result != nil {
-			fmt.Printf("\n")
+	SleepProgress(os.Stdout)(waiter)
+}
+
+// SleepProgress is like SleepProgressWithContext, but writes to the given writer.
+func SleepProgress(out io.Writer) request.WaiterOption {
+	return func(waiter *request.Waiter) {
+		// At the end of the wait loop, print a newline.
+		waiter.SleepWithContext = func(context aws.Context, duration time.Duration) error {
+			fmt.Fprintf(out, ".")
+			result := aws.SleepWithContext(context, duration)
+			if result != nil {
+				fmt.Fprintf(out, "\n")
+			}
+			return result
 		}
-		return result
 	}
 }
 
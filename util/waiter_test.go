@@ -0,0 +1,108 @@
+package util_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/chanzuckerberg/czecs/pkg/ecsfake"
+	"github.com/chanzuckerberg/czecs/util"
+)
+
+func TestGetFailOnRolloutContext(t *testing.T) {
+	tests := []struct {
+		name           string
+		behavior       ecsfake.Behavior
+		circuitBreaker bool
+		// settle describes the service this many times before waiting, e.g. to let a rollback finish
+		settle int
+
+		wantErr string
+	}{
+		{
+			name: "completed",
+		},
+		{
+			name:     "unable to place tasks without the circuit breaker",
+			behavior: ecsfake.Behavior{Unable: true},
+			wantErr:  "unable to place a task",
+		},
+		{
+			name:           "circuit breaker failed",
+			behavior:       ecsfake.Behavior{Unable: true},
+			circuitBreaker: true,
+			wantErr:        "ECS deployment circuit breaker",
+		},
+		{
+			name:           "circuit breaker rolled back",
+			behavior:       ecsfake.Behavior{Unable: true},
+			circuitBreaker: true,
+			settle:         3,
+			wantErr:        "is no longer active",
+		},
+		{
+			name:     "timeout",
+			behavior: ecsfake.Behavior{Steps: 10},
+			wantErr:  "exceeded wait attempts",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := ecsfake.New("test")
+			fake.Behave("web:2", test.behavior)
+			for i := 0; i < 2; i++ {
+				_, err := fake.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+					Family:               aws.String("web"),
+					ContainerDefinitions: []*ecs.ContainerDefinition{{Name: aws.String("web"), Image: aws.String("example/web")}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			createServiceInput := &ecs.CreateServiceInput{
+				Cluster:        aws.String("test"),
+				ServiceName:    aws.String("web"),
+				TaskDefinition: aws.String("web:1"),
+			}
+			if test.circuitBreaker {
+				createServiceInput.DeploymentConfiguration = &ecs.DeploymentConfiguration{
+					DeploymentCircuitBreaker: &ecs.DeploymentCircuitBreaker{Enable: aws.Bool(true), Rollback: aws.Bool(true)},
+				}
+			}
+			if _, err := fake.CreateService(createServiceInput); err != nil {
+				t.Fatal(err)
+			}
+			describeServicesInput := &ecs.DescribeServicesInput{Cluster: aws.String("test"), Services: []*string{aws.String("web")}}
+			if _, err := fake.DescribeServices(describeServicesInput); err != nil {
+				t.Fatal(err)
+			}
+
+			createdAt := time.Now()
+			output, err := fake.UpdateService(&ecs.UpdateServiceInput{
+				Cluster:        aws.String("test"),
+				Service:        aws.String("web"),
+				TaskDefinition: aws.String("web:2"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deploymentID := aws.StringValue(output.Service.Deployments[0].Id)
+			for i := 0; i < test.settle; i++ {
+				if _, err := fake.DescribeServices(describeServicesInput); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = fake.WaitUntilServicesStableWithContext(aws.BackgroundContext(), describeServicesInput,
+				append(util.WaiterDelay(15, 15), util.GetFailOnRolloutContext(deploymentID, createdAt, 0), fake.WaiterOptions()[0])...)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Fatalf("expected an error containing %#v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}